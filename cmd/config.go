@@ -15,40 +15,106 @@
 package cmd
 
 import (
-	"os"
+	"time"
 
 	"perun.network/go-perun/log"
 	plogrus "perun.network/go-perun/log/logrus"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 type logCfg struct {
 	Level string
 	level logrus.Level
-	File  string
+	// Format is either text or json. Defaults to text.
+	Format string
+	// Levels overrides the level of single components, see components.
+	Levels map[string]string
+	File   string
+	// Rotate configures the rotation of File.
+	Rotate rotateCfg
+}
+
+type rotateCfg struct {
+	MaxSize    int // Megabytes after which the file is rotated. Default 100.
+	MaxBackups int // Number of rotated files to keep. Zero keeps all.
+	MaxAge     int // Days to keep rotated files. Zero keeps them forever.
+	Compress   bool
 }
 
 var logConfig logCfg
 
 func setConfig() {
+	// The config file can set all options, the flags take precedence.
+	if err := viper.UnmarshalKey("log", &logConfig); err != nil {
+		log.Fatal(errors.WithMessage(err, "parsing log config"))
+	}
+	logConfig.Level = viper.GetString("log.level")
+	logConfig.File = viper.GetString("log.file")
+	logConfig.Format = viper.GetString("log.format")
+
 	lvl, err := logrus.ParseLevel(logConfig.Level)
 	if err != nil {
 		log.Fatal(errors.WithMessage(err, "parsing log level"))
 	}
 	logConfig.level = lvl
+	levels, err := componentLevels(lvl, logConfig.Levels)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Set the logging output file
 	logger := logrus.New()
+	formatter := &componentFormatter{levels: levels}
+	switch logConfig.Format {
+	case "", "text":
+		formatter.Formatter = &logrus.TextFormatter{}
+	case "json":
+		formatter.Formatter = &logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}
+	default:
+		log.Fatalf("Unknown log format '%s', expected text or json", logConfig.Format)
+	}
+	logger.SetFormatter(formatter)
+
+	// Set the logging output file
 	if logConfig.File != "" {
-		f, err := os.OpenFile(logConfig.File,
-			os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Fatal(errors.WithMessage(err, "opening logging file"))
+		logger.SetOutput(&lumberjack.Logger{
+			Filename:   logConfig.File,
+			MaxSize:    logConfig.Rotate.MaxSize,
+			MaxBackups: logConfig.Rotate.MaxBackups,
+			MaxAge:     logConfig.Rotate.MaxAge,
+			Compress:   logConfig.Rotate.Compress,
+		})
+	}
+	// The formatter filters by component, so the logger needs to pass the
+	// entries of the most verbose one.
+	for _, l := range levels {
+		if l > lvl {
+			lvl = l
 		}
-		logger.SetOutput(f)
 	}
 	logger.SetLevel(lvl)
 	log.Set(plogrus.FromLogrus(logger))
 }
+
+// componentLevels returns the level of every component. Components without
+// an override get the level `def`.
+func componentLevels(def logrus.Level, overrides map[string]string) (map[string]logrus.Level, error) {
+	levels := make(map[string]logrus.Level, len(components))
+	for _, c := range components {
+		levels[c] = def
+	}
+	for c, l := range overrides {
+		if _, ok := levels[c]; !ok {
+			return nil, errors.Errorf("unknown log component '%s', expected one of %v", c, components)
+		}
+		lvl, err := logrus.ParseLevel(l)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "parsing log level of %s", c)
+		}
+		levels[c] = lvl
+	}
+	return levels, nil
+}
@@ -0,0 +1,283 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"perun.network/go-perun/log"
+)
+
+var (
+	benchCmd = &cobra.Command{
+		Use:   "benchmark",
+		Short: "Work with benchmark results",
+		// The results do not need the node config.
+		PersistentPreRun: func(*cobra.Command, []string) {},
+	}
+
+	compareCmd = &cobra.Command{
+		Use:   "compare <baseline.json> <current.json>",
+		Short: "Compare two benchmark results",
+		Long: `Compares the throughput and latency percentiles of two results written with
+	'benchmark ... --out=FILE.json'. Exits with a non-zero code if the throughput of
+	the current run is lower than the baseline by more than the threshold, or if a
+	latency percentile is higher by more than the threshold and the latencies are
+	significantly higher according to a one-sided Mann-Whitney U test.`,
+		Args: cobra.ExactArgs(2),
+		Run:  runCompare,
+	}
+
+	compareFlags = defaultCompareConfig()
+)
+
+type (
+	compareConfig struct {
+		// threshold is the tolerated deterioration in percent.
+		threshold float64
+		// alpha is the significance level of the Mann-Whitney U test.
+		alpha float64
+	}
+
+	// comparison is the result of comparing two benchmark runs.
+	comparison struct {
+		metrics []metricDelta
+		// p is the p-value of the one-sided Mann-Whitney U test that the
+		// current latencies are higher than those of the baseline.
+		p float64
+		// lowerRate is set if the throughput dropped beyond the threshold.
+		lowerRate bool
+		// higherLatency is set if a latency percentile rose beyond the
+		// threshold and the latencies are significantly higher.
+		higherLatency bool
+		regressed     bool
+	}
+
+	metricDelta struct {
+		name           string
+		baseline, curr float64
+		format         func(float64) string
+		// deltaPct is the relative change in percent.
+		deltaPct float64
+		// worse is set if the change is a deterioration beyond the threshold.
+		worse bool
+	}
+)
+
+func init() {
+	compareCmd.Flags().Float64Var(&compareFlags.threshold, "threshold", compareFlags.threshold, "Tolerated deterioration in percent")
+	compareCmd.Flags().Float64Var(&compareFlags.alpha, "alpha", compareFlags.alpha, "Significance level of the Mann-Whitney U test")
+	benchCmd.AddCommand(compareCmd)
+	demoCmd.AddCommand(benchCmd)
+}
+
+func defaultCompareConfig() compareConfig {
+	return compareConfig{threshold: 5, alpha: 0.05}
+}
+
+func runCompare(c *cobra.Command, args []string) {
+	regressed, err := compareBenchmarks(args[0], args[1], compareFlags)
+	if err != nil {
+		log.WithError(err).Fatalln("Could not compare benchmarks.")
+	}
+	if regressed {
+		os.Exit(1)
+	}
+}
+
+// CompareBenchmarks compares two benchmark results. It is the 'benchmark
+// compare' command of the demo.
+func CompareBenchmarks(args []string) error {
+	var cfg compareConfig
+	// Input was already validated by command parser.
+	cfg.threshold, _ = strconv.ParseFloat(args[2], 64)
+	cfg.alpha, _ = strconv.ParseFloat(args[3], 64)
+	_, err := compareBenchmarks(args[0], args[1], cfg)
+	return err
+}
+
+// compareBenchmarks prints the comparison of the results in the files and
+// returns whether the current run regressed.
+func compareBenchmarks(baselinePath, currentPath string, cfg compareConfig) (bool, error) {
+	baseline, err := loadBenchResults(baselinePath)
+	if err != nil {
+		return false, err
+	}
+	current, err := loadBenchResults(currentPath)
+	if err != nil {
+		return false, err
+	}
+	c := compareBench(baseline.Total, current.Total, cfg)
+	fmt.Fprintf(stdout, "Baseline: %s at %s\nCurrent:  %s at %s\n\n",
+		baseline.Meta.Revision, baseline.Meta.Time.Format(time.RFC3339), current.Meta.Revision, current.Meta.Time.Format(time.RFC3339))
+	if err := c.print(cfg); err != nil {
+		return false, err
+	}
+	return c.regressed, nil
+}
+
+func loadBenchResults(path string) (benchResults, error) {
+	var r benchResults
+	if filepath.Ext(path) != ".json" {
+		return r, errors.Errorf("'%s' is not a .json file, only JSON results contain the latencies", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return r, errors.Wrap(err, "reading benchmark results")
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, errors.Wrapf(err, "parsing '%s'", path)
+	} else if r.Meta.Mode == benchModeOnChain {
+		return r, errors.Errorf("'%s' contains on-chain results, which cannot be compared", path)
+	}
+	return r, nil
+}
+
+// compareBench compares the throughput and latency percentiles. The current
+// run regressed if its throughput is lower by more than the threshold, or if a
+// latency percentile is higher by more than the threshold and the latencies
+// are significantly higher. Lower latencies never count as a regression.
+func compareBench(baseline, current benchStats, cfg compareConfig) comparison {
+	fmtRate := func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) }
+	fmtDur := func(v float64) string { return time.Duration(v).Round(time.Microsecond).String() }
+	bl, cl := baseline.Latency, current.Latency
+	c := comparison{
+		metrics: []metricDelta{
+			{name: "tx/s", baseline: baseline.TxPerSec, curr: current.TxPerSec, format: fmtRate},
+			{name: "median", baseline: float64(bl.Median), curr: float64(cl.Median), format: fmtDur},
+			{name: "p90", baseline: float64(bl.P90), curr: float64(cl.P90), format: fmtDur},
+			{name: "p95", baseline: float64(bl.P95), curr: float64(cl.P95), format: fmtDur},
+			{name: "p99", baseline: float64(bl.P99), curr: float64(cl.P99), format: fmtDur},
+			{name: "p99.9", baseline: float64(bl.P999), curr: float64(cl.P999), format: fmtDur},
+		},
+	}
+	_, c.p = mannWhitney(baseline.Latencies, current.Latencies)
+	latencyWorse := false
+	for i := range c.metrics {
+		m := &c.metrics[i]
+		if m.baseline != 0 {
+			m.deltaPct = (m.curr - m.baseline) / m.baseline * 100
+		}
+		// Lower throughput and higher latencies are worse.
+		if i == 0 {
+			m.worse = m.deltaPct < -cfg.threshold
+			c.lowerRate = m.worse
+		} else {
+			m.worse = m.deltaPct > cfg.threshold
+			latencyWorse = latencyWorse || m.worse
+		}
+	}
+	c.higherLatency = latencyWorse && c.p < cfg.alpha
+	c.regressed = c.lowerRate || c.higherLatency
+	return c
+}
+
+func (c comparison) print(cfg compareConfig) error {
+	w := tabwriter.NewWriter(stdout, 0, 0, 3, ' ', tabwriter.Debug)
+	fmt.Fprintln(w, "Metric\tBaseline\tCurrent\tDelta\t")
+	for _, m := range c.metrics {
+		flag := ""
+		if m.worse {
+			flag = " ⚠️"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%+.1f%%%s\t\n", m.name, m.format(m.baseline), m.format(m.curr), m.deltaPct, flag)
+	}
+	fmt.Fprintln(w)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "One-sided Mann-Whitney U test for higher latencies: p = %.4f (α = %v)\n", c.p, cfg.alpha)
+	if c.lowerRate {
+		fmt.Fprintf(stdout, "🚨 Regression: throughput lower than the baseline by more than %v%%.\n", cfg.threshold)
+	}
+	if c.higherLatency {
+		fmt.Fprintf(stdout, "🚨 Regression: latencies significantly higher than the baseline by more than %v%%.\n", cfg.threshold)
+	}
+	switch {
+	case c.regressed:
+		return nil
+	case c.p < cfg.alpha:
+		fmt.Fprintln(stdout, "✅ No regression beyond the threshold.")
+	default:
+		fmt.Fprintln(stdout, "✅ No significant increase of the latencies.")
+	}
+	return nil
+}
+
+// mannWhitney returns the U statistic of `a`, the number of pairs in which
+// the value of `a` is greater than that of `b` with ties counting half, and
+// the one-sided p-value of the Mann-Whitney U test that `b` tends to be
+// greater than `a`. It uses the normal approximation with tie and continuity
+// correction, which is accurate for the sample sizes of benchmarks. Without
+// variance, e.g. if all values are equal, p is 1.
+func mannWhitney(a, b []time.Duration) (u, p float64) {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return 0, 1
+	}
+	type obs struct {
+		v     time.Duration
+		fromA bool
+	}
+	all := make([]obs, 0, len(a)+len(b))
+	for _, v := range a {
+		all = append(all, obs{v, true})
+	}
+	for _, v := range b {
+		all = append(all, obs{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// Sum the ranks of `a`, ties get the average of their ranks.
+	var rankSumA, ties float64
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank := float64(i+j+1) / 2 // Ranks are 1-based.
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankSumA += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	n := n1 + n2
+	u = rankSumA - n1*(n1+1)/2
+	mean := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 {
+		return u, 1
+	}
+	// A small U means that `b` is greater. The continuity correction moves
+	// U by 0.5 towards the mean.
+	z := (u - mean + 0.5) / sigma
+	return u, math.Erfc(-z/math.Sqrt2) / 2
+}
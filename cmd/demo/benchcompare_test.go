@@ -0,0 +1,123 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func durations(vs ...int) []time.Duration {
+	ds := make([]time.Duration, len(vs))
+	for i, v := range vs {
+		ds[i] = time.Duration(v)
+	}
+	return ds
+}
+
+func TestMannWhitney(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []time.Duration
+		u, p float64
+	}{
+		{"b greater", durations(1, 2, 3), durations(4, 5, 6), 0, 0.0404278},
+		{"b smaller", durations(4, 5, 6), durations(1, 2, 3), 9, 0.9854518},
+		{"ties", durations(1, 2, 2, 3), durations(2, 3, 4, 4), 2.5, 0.0670846},
+		{"overlap", durations(10, 11, 12, 13, 14, 15, 16, 17), durations(12, 14, 16, 18, 20, 22, 24, 26), 10.5, 0.0135414},
+		{"all ties", durations(5, 5, 5), durations(5, 5), 3, 1},
+		{"n=1 greater", durations(1), durations(2), 0, 0.5},
+		{"n=1 smaller", durations(2), durations(1), 1, 0.9772499},
+		{"n=1 equal", durations(1), durations(1), 0.5, 1},
+		{"n=1 vs many", durations(3), durations(1, 2, 4, 5), 2, 0.6381632},
+		{"empty", nil, durations(1, 2), 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, p := mannWhitney(tt.a, tt.b)
+			if u != tt.u {
+				t.Errorf("U = %v, want %v", u, tt.u)
+			}
+			if math.Abs(p-tt.p) > 1e-6 {
+				t.Errorf("p = %.7f, want %.7f", p, tt.p)
+			}
+		})
+	}
+}
+
+func TestCompareBench(t *testing.T) {
+	fast := benchStats{TxPerSec: 100, Latency: durationStats{Median: 10, P90: 10, P95: 10, P99: 10, P999: 10}}
+	slow := benchStats{TxPerSec: 50, Latency: durationStats{Median: 20, P90: 20, P95: 20, P99: 20, P999: 20}}
+	var fastLat, slowLat []time.Duration
+	for i := 0; i < 30; i++ {
+		fastLat = append(fastLat, time.Duration(10+i%3))
+		slowLat = append(slowLat, time.Duration(20+i%3))
+	}
+	fast.Latencies, slow.Latencies = fastLat, slowLat
+	cfg := defaultCompareConfig()
+
+	tests := []struct {
+		name              string
+		baseline, current benchStats
+		regressed         bool
+	}{
+		{"slower", fast, slow, true},
+		{"faster", slow, fast, false},
+		{"same", fast, fast, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := compareBench(tt.baseline, tt.current, cfg)
+			if c.regressed != tt.regressed {
+				t.Errorf("regressed = %v, want %v (p = %v)", c.regressed, tt.regressed, c.p)
+			}
+		})
+	}
+
+	t.Run("worse but not significant", func(t *testing.T) {
+		// The percentiles are worse but the latencies are the same.
+		current := slow
+		current.TxPerSec, current.Latencies = fast.TxPerSec, fastLat
+		if c := compareBench(fast, current, cfg); c.regressed {
+			t.Errorf("regressed with p = %v", c.p)
+		}
+	})
+
+	t.Run("lower throughput only", func(t *testing.T) {
+		current := fast
+		current.TxPerSec = slow.TxPerSec
+		if c := compareBench(fast, current, cfg); !c.regressed || !c.lowerRate || c.higherLatency {
+			t.Errorf("lowerRate = %v, higherLatency = %v, want only lowerRate", c.lowerRate, c.higherLatency)
+		}
+	})
+
+	t.Run("lower latencies and throughput", func(t *testing.T) {
+		current := fast
+		current.TxPerSec = 10
+		if c := compareBench(slow, current, cfg); !c.regressed || c.higherLatency {
+			t.Errorf("lowerRate = %v, higherLatency = %v, want only lowerRate", c.lowerRate, c.higherLatency)
+		}
+	})
+
+	t.Run("lower latencies but higher p99", func(t *testing.T) {
+		current := fast
+		current.TxPerSec = slow.TxPerSec
+		current.Latency.P99 = 40
+		if c := compareBench(slow, current, cfg); c.regressed {
+			t.Errorf("regressed with p = %v", c.p)
+		}
+	})
+}
@@ -15,75 +15,238 @@
 package demo
 
 import (
+	"context"
 	"fmt"
 	"math/big"
-	"os"
 	"strconv"
-	"text/tabwriter"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/montanaflynn/stats"
 	"github.com/pkg/errors"
 )
 
-type run struct {
-	// data are the recorded times for sendUpdate in nanoseconds
-	data []float64
-	// start of the last run
-	start time.Time
-}
-
-func (r *run) Start() {
-	r.start = time.Now()
-}
+type (
+	run struct {
+		samples []sample
+	}
 
-func (r *run) Stop() {
-	r.data = append(r.data, float64(time.Since(r.start).Nanoseconds()))
-}
+	// sample is the timing of a single benchmark transaction.
+	sample struct {
+		// latency is the time from proposing the update, or from the
+		// schedule in open-loop mode, until it completed. In closed-loop
+		// mode, it excludes the time a worker waits for another worker
+		// that updates the same channel.
+		latency time.Duration
+		// sign is the time spent signing the new state.
+		sign time.Duration
+		// network is the time of the update minus signing, which is mostly
+		// the round-trip to the peer.
+		network time.Duration
+	}
 
-func (r *run) String() string {
-	functions := []func(stats.Float64Data) (float64, error){stats.Sum, stats.Min, stats.Max, stats.Median, stats.StdDevP}
-	var str string
-	values := make([]float64, len(functions))
+	benchConfig struct {
+		// peers are the aliases of the peers.
+		peers []string
+		// amount is sent per transaction.
+		amount *big.Int
+		// txCount is the number of transactions per channel.
+		txCount int
+		// workers is the number of concurrent senders, defaults to one per
+		// channel.
+		workers int
+		// rate is the target rate in tx/s over all channels (open-loop).
+		// Zero sends as fast as possible (closed-loop).
+		rate float64
+		// warmup is the number of unrecorded transactions per channel
+		// before the measurement.
+		warmup int
+		// out is the file to which the results are written, empty for none.
+		out string
+	}
 
-	for i, f := range functions {
-		values[i], _ = f(r.data)
-		str += (time.Duration(values[i]) * time.Nanosecond).Round(time.Microsecond).String() + "\t"
+	benchJob struct {
+		peer string
+		// scheduled is the time at which an open-loop transaction is due.
+		// Zero for closed-loop transactions.
+		scheduled time.Time
 	}
+)
 
-	freq := (float64(len(r.data)) / values[0]) * float64(time.Second.Nanoseconds())
-	return fmt.Sprintf("N\ttx/s\tSum\tMin\tMax\tMedian\tStddev\t\n%d\t%.1f\t", len(r.data), freq) + str
+func (r *run) add(s sample) {
+	r.samples = append(r.samples, s)
 }
 
-// Benchmark updates the channel with a `peer` `n` times and measures the of every update.
-// A statistic is then printed with run.String()
+// Benchmark updates the channels with one or more peers `txCount` times each
+// and measures the time of every update. The updates are sent by concurrent
+// workers, either as fast as possible or at a fixed rate. A statistic per
+// channel and over all channels is then printed.
 func (n *node) Benchmark(args []string) error {
-	n.mtx.Lock()
-	defer n.mtx.Unlock()
-	peer := n.peers[args[0]]
 	totalAmountDot, _ := strconv.Atoi(args[1])
 	txCount, _ := strconv.Atoi(args[2])
-	var r run
-
 	if txCount < 1 {
 		return errors.New("Number of runs cant be less than 1")
-	} else if peer == nil {
-		return errors.New("Peer not found")
-	} else if peer.ch == nil {
-		return errors.New("Open a state channel first")
 	}
-
+	// Options were already validated by command parser.
+	cfg := benchConfig{txCount: txCount, out: args[6]}
+	if args[3] != "" {
+		cfg.workers, _ = strconv.Atoi(args[3])
+	}
+	if args[4] != "" {
+		cfg.rate, _ = strconv.ParseFloat(args[4], 64)
+	}
+	if args[5] != "" {
+		cfg.warmup, _ = strconv.Atoi(args[5])
+	}
+	peers, err := n.benchPeers(args[0])
+	if err != nil {
+		return err
+	}
+	cfg.peers = peers
+	if cfg.workers == 0 {
+		cfg.workers = len(peers)
+	}
 	totalAmountPlank := dotToPlank(big.NewFloat(float64(totalAmountDot)))[0]
-	txAmount := new(big.Int).Div(totalAmountPlank, big.NewInt(int64(txCount)))
-	for i := 0; i < txCount; i++ {
-		r.Start()
-		if err := peer.ch.sendMoney(txAmount); err != nil {
-			return errors.WithMessage(err, "could not send update")
+	cfg.amount = new(big.Int).Div(totalAmountPlank, big.NewInt(int64(txCount)))
+
+	mode := "max throughput"
+	if cfg.rate > 0 {
+		mode = fmt.Sprintf("%.1f tx/s", cfg.rate)
+	}
+	fmt.Fprintf(stdout, "⏱  Benchmarking %d channel(s) with %d worker(s) at %s...\n", len(peers), cfg.workers, mode)
+	if cfg.warmup > 0 {
+		warmup := cfg
+		warmup.txCount, warmup.rate = cfg.warmup, 0
+		if _, _, err := n.runBenchmark(warmup); err != nil {
+			return errors.WithMessage(err, "warm-up")
 		}
-		r.Stop()
 	}
+	collisions := make([]uint64, len(peers))
+	for i, alias := range peers {
+		collisions[i] = n.collisions(alias)
+	}
+	start := time.Now()
+	runs, elapsed, err := n.runBenchmark(cfg)
+	if err != nil {
+		return err
+	}
+
+	results := newBenchResults(cfg, start, runs, elapsed)
+	for i, alias := range peers {
+		results.Channels[i].Collisions = n.collisions(alias) - collisions[i]
+		results.Total.Collisions += results.Channels[i].Collisions
+	}
+	return n.reportBenchResults(results, cfg.out)
+}
+
+// benchPeers returns the peers of a comma separated list of aliases or all
+// peers with an open channel for "all".
+func (n *node) benchPeers(arg string) ([]string, error) {
+	if arg == "all" {
+		peers := n.channelAliases()
+		if len(peers) == 0 {
+			return nil, errors.New("Open a state channel first")
+		}
+		return peers, nil
+	}
+	var peers []string
+	for _, alias := range strings.Split(arg, ",") {
+		p, ok := n.core.Peer(alias)
+		if !ok {
+			return nil, errors.Errorf("Peer not found: %s", alias)
+		} else if p.Channel == nil {
+			return nil, errors.Errorf("Open a state channel with %s first", alias)
+		}
+		peers = append(peers, alias)
+	}
+	return peers, nil
+}
+
+// collisions returns the number of update collisions of the channel with
+// `alias`, zero if none is open.
+func (n *node) collisions(alias string) uint64 {
+	if p, ok := n.core.Peer(alias); ok && p.Channel != nil {
+		return p.Channel.Collisions
+	}
+	return 0
+}
+
+// runBenchmark sends the transactions of `cfg` and returns the recorded times
+// per peer and the wall-clock time of the whole run. It stops at the first
+// error.
+func (n *node) runBenchmark(cfg benchConfig) (map[string]*run, time.Duration, error) {
+	ctx, cancel := context.WithCancel(n.ctx)
+	defer cancel()
+	runs := make(map[string]*run, len(cfg.peers))
+	for _, p := range cfg.peers {
+		runs[p] = new(run)
+	}
+
+	var (
+		mtx      sync.Mutex // Protects runs and firstErr
+		firstErr error
+		wg       sync.WaitGroup
+		jobs     = make(chan benchJob)
+	)
+	start := time.Now()
+	go dispatchBenchJobs(ctx, cfg, start, jobs)
+	wg.Add(cfg.workers)
+	for i := 0; i < cfg.workers; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				s, err := n.benchSend(job.peer, cfg.amount)
+				if !job.scheduled.IsZero() {
+					s.latency = time.Since(job.scheduled)
+				}
+
+				mtx.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = errors.WithMessage(err, "could not send update")
+					cancel()
+				} else if err == nil {
+					runs[job.peer].add(s)
+				}
+				mtx.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return runs, time.Since(start), firstErr
+}
+
+// dispatchBenchJobs round-robins the transactions over the channels. With a
+// target rate, every transaction is scheduled at a fixed time and its
+// latency includes the time it waited for a free worker.
+func dispatchBenchJobs(ctx context.Context, cfg benchConfig, start time.Time, jobs chan<- benchJob) {
+	defer close(jobs)
+	var interval time.Duration
+	if cfg.rate > 0 {
+		interval = time.Duration(float64(time.Second) / cfg.rate)
+	}
+	for i := 0; i < cfg.txCount*len(cfg.peers); i++ {
+		job := benchJob{peer: cfg.peers[i%len(cfg.peers)]}
+		if interval > 0 {
+			job.scheduled = start.Add(time.Duration(i) * interval)
+			select {
+			case <-time.After(time.Until(job.scheduled)):
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.Debug)
-	fmt.Fprintln(w, r.String())
-	return w.Flush()
+// benchSend sends `amount` to the peer with `alias` and returns the time of
+// the update, spent signing and on the network. Sends to the same peer are
+// serialized by the core node, which starts timing the update once it is its
+// turn. Sends to different peers run concurrently.
+func (n *node) benchSend(alias string, amount *big.Int) (sample, error) {
+	p, err := n.core.Send(n.ctx, alias, amount, "")
+	return sample{latency: p.Duration, sign: p.SignDuration, network: p.Duration - p.SignDuration}, err
 }
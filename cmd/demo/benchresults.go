@@ -0,0 +1,370 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/montanaflynn/stats"
+	"github.com/pkg/errors"
+)
+
+// revision is the git revision of the build. It can be set with
+// -ldflags "-X github.com/perun-network/perun-polkadot-demo/cmd/demo.revision=<rev>".
+var revision string
+
+// histogramWidth is the width of the longest histogram bar.
+const histogramWidth = 40
+
+// Benchmark modes.
+const (
+	benchModeSend     = "send"
+	benchModePingPong = "pingpong"
+	benchModeOnChain  = "onchain"
+)
+
+type (
+	// benchResults are the results of a benchmark. All durations are in
+	// nanoseconds.
+	benchResults struct {
+		Meta     benchMeta    `json:"meta"`
+		Channels []benchStats `json:"channels"`
+		// Total is over all channels.
+		Total benchStats `json:"total"`
+		// Responder are the results of the peer in ping-pong mode.
+		Responder *responderStats `json:"responder,omitempty"`
+	}
+
+	// benchMeta describes a benchmark run.
+	benchMeta struct {
+		Time        time.Time     `json:"time"`
+		Mode        string        `json:"mode"`
+		Revision    string        `json:"revision"`
+		Node        string        `json:"node"`
+		Transport   string        `json:"transport"`
+		Persistence bool          `json:"persistence"`
+		Peers       []string      `json:"peers"`
+		AmountPlank *big.Int      `json:"amountPlank"` // Per transaction.
+		TxCount     int           `json:"txCount"`     // Per channel.
+		Workers     int           `json:"workers"`
+		Rate        float64       `json:"rate"` // Zero for max throughput.
+		Warmup      int           `json:"warmup"`
+		Elapsed     time.Duration `json:"elapsed"`
+	}
+
+	benchStats struct {
+		Peer      string            `json:"peer"`
+		N         int               `json:"n"`
+		TxPerSec  float64           `json:"txPerSec"`
+		Latency   durationStats     `json:"latency"`
+		Sign      durationStats     `json:"sign"`
+		Network   durationStats     `json:"network"`
+		Histogram []histogramBucket `json:"histogram"`
+		// Collisions counts the updates that collided with an update of
+		// the peer.
+		Collisions uint64 `json:"collisions"`
+		// Latencies are the raw latencies in the order of completion.
+		Latencies []time.Duration `json:"latencies"`
+	}
+
+	// responderStats are the round-trip latencies of a ping-pong as seen by
+	// the responder.
+	responderStats struct {
+		N          int           `json:"n"`
+		Median     time.Duration `json:"median"`
+		P99        time.Duration `json:"p99"`
+		Collisions uint64        `json:"collisions"`
+	}
+
+	durationStats struct {
+		Sum    time.Duration `json:"sum"`
+		Min    time.Duration `json:"min"`
+		Max    time.Duration `json:"max"`
+		Mean   time.Duration `json:"mean"`
+		Median time.Duration `json:"median"`
+		Stddev time.Duration `json:"stddev"`
+		P90    time.Duration `json:"p90"`
+		P95    time.Duration `json:"p95"`
+		P99    time.Duration `json:"p99"`
+		P999   time.Duration `json:"p999"`
+	}
+
+	// histogramBucket counts the latencies in (previous bound, UpperBound].
+	histogramBucket struct {
+		UpperBound time.Duration `json:"le"`
+		Count      int           `json:"count"`
+	}
+)
+
+func newBenchResults(cfg benchConfig, start time.Time, runs map[string]*run, elapsed time.Duration) benchResults {
+	r := benchResults{
+		Meta: benchMeta{
+			Time:        start,
+			Mode:        benchModeSend,
+			Revision:    buildRevision(),
+			Node:        config.Alias,
+			Transport:   config.Node.Transport,
+			Persistence: config.Node.PersistenceEnabled,
+			AmountPlank: cfg.amount,
+			TxCount:     cfg.txCount,
+			Workers:     cfg.workers,
+			Rate:        cfg.rate,
+			Warmup:      cfg.warmup,
+			Elapsed:     elapsed,
+		},
+	}
+	var all []sample
+	for _, alias := range cfg.peers {
+		r.Meta.Peers = append(r.Meta.Peers, alias)
+		r.Channels = append(r.Channels, newBenchStats(alias, runs[alias].samples, elapsed))
+		all = append(all, runs[alias].samples...)
+	}
+	r.Total = newBenchStats("total", all, elapsed)
+	return r
+}
+
+func newBenchStats(name string, samples []sample, elapsed time.Duration) benchStats {
+	latency := make([]time.Duration, len(samples))
+	sign := make([]time.Duration, len(samples))
+	network := make([]time.Duration, len(samples))
+	for i, s := range samples {
+		latency[i], sign[i], network[i] = s.latency, s.sign, s.network
+	}
+	return benchStats{
+		Peer:      name,
+		N:         len(samples),
+		TxPerSec:  float64(len(samples)) / elapsed.Seconds(),
+		Latency:   newDurationStats(latency),
+		Sign:      newDurationStats(sign),
+		Network:   newDurationStats(network),
+		Histogram: newHistogram(latency),
+		Latencies: latency,
+	}
+}
+
+func newDurationStats(ds []time.Duration) durationStats {
+	if len(ds) == 0 {
+		return durationStats{}
+	}
+	data := make(stats.Float64Data, len(ds))
+	for i, d := range ds {
+		data[i] = float64(d)
+	}
+	stat := func(f func(stats.Float64Data) (float64, error)) time.Duration {
+		v, _ := f(data)
+		return time.Duration(v)
+	}
+	percentile := func(p float64) time.Duration {
+		v, _ := stats.PercentileNearestRank(data, p)
+		return time.Duration(v)
+	}
+	return durationStats{
+		Sum:    stat(stats.Sum),
+		Min:    stat(stats.Min),
+		Max:    stat(stats.Max),
+		Mean:   stat(stats.Mean),
+		Median: stat(stats.Median),
+		Stddev: stat(stats.StdDevP),
+		P90:    percentile(90),
+		P95:    percentile(95),
+		P99:    percentile(99),
+		P999:   percentile(99.9),
+	}
+}
+
+// newHistogram counts the latencies in buckets whose bounds are powers of two
+// microseconds.
+func newHistogram(ds []time.Duration) []histogramBucket {
+	if len(ds) == 0 {
+		return nil
+	}
+	min, max := ds[0], ds[0]
+	for _, d := range ds {
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+	}
+	bound := time.Microsecond
+	for bound < min {
+		bound *= 2
+	}
+	var buckets []histogramBucket
+	for ; len(buckets) == 0 || buckets[len(buckets)-1].UpperBound < max; bound *= 2 {
+		buckets = append(buckets, histogramBucket{UpperBound: bound})
+	}
+	for _, d := range ds {
+		for i := range buckets {
+			if d <= buckets[i].UpperBound {
+				buckets[i].Count++
+				break
+			}
+		}
+	}
+	return buckets
+}
+
+// printBenchResults prints the latencies, the time spent signing and on the
+// network per channel and a histogram of all latencies.
+func printBenchResults(r benchResults) error {
+	rows := r.Channels
+	if len(rows) > 1 {
+		rows = append(rows, r.Total)
+	}
+	w := tabwriter.NewWriter(stdout, 0, 0, 3, ' ', tabwriter.Debug)
+	fmt.Fprintln(w, "Peer\tN\ttx/s\tSum\tMin\tMedian\tp90\tp95\tp99\tp99.9\tMax\tStddev\t")
+	for _, s := range rows {
+		l := s.Latency
+		fmt.Fprintf(w, "%s\t%d\t%.1f\t%s\t\n", s.Peer, s.N, s.TxPerSec,
+			fmtDurations(l.Sum, l.Min, l.Median, l.P90, l.P95, l.P99, l.P999, l.Max, l.Stddev))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Peer\tSign Median\tSign p99\tNetwork Median\tNetwork p99\t")
+	for _, s := range rows {
+		fmt.Fprintf(w, "%s\t%s\t\n", s.Peer, fmtDurations(s.Sign.Median, s.Sign.P99, s.Network.Median, s.Network.P99))
+	}
+	fmt.Fprintln(w)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if r.Total.Collisions > 0 {
+		fmt.Fprintf(stdout, "⚠️  %d update collision(s) with concurrent updates of the peer.\n", r.Total.Collisions)
+	}
+	if resp := r.Responder; resp != nil {
+		fmt.Fprintf(stdout, "Round-trips as seen by the peer: N %d, median %v, p99 %v, collisions %d\n\n",
+			resp.N, resp.Median.Round(time.Microsecond), resp.P99.Round(time.Microsecond), resp.Collisions)
+	}
+
+	fmt.Fprintln(stdout, "Latency histogram:")
+	maxCount := 0
+	for _, b := range r.Total.Histogram {
+		if b.Count > maxCount {
+			maxCount = b.Count
+		}
+	}
+	w = tabwriter.NewWriter(stdout, 0, 0, 1, ' ', tabwriter.AlignRight)
+	for _, b := range r.Total.Histogram {
+		n := b.Count * histogramWidth / maxCount
+		bar := strings.Repeat("█", n) + strings.Repeat(" ", histogramWidth-n)
+		fmt.Fprintf(w, "≤ %v\t |%s| %d\t\n", b.UpperBound, bar, b.Count)
+	}
+	return w.Flush()
+}
+
+// reportBenchResults prints the results and writes them to `out`, if set.
+func (n *node) reportBenchResults(r benchResults, out string) error {
+	if err := printBenchResults(r); err != nil {
+		return err
+	}
+	if out != "" {
+		if err := writeBenchResults(out, r); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "📒 Wrote benchmark results to %s.\n", out)
+	}
+	return nil
+}
+
+// fmtDurations formats durations as tab separated cells.
+func fmtDurations(ds ...time.Duration) string {
+	cells := make([]string, len(ds))
+	for i, d := range ds {
+		cells[i] = d.Round(time.Microsecond).String()
+	}
+	return strings.Join(cells, "\t")
+}
+
+// writeBenchResults writes the results as JSON or CSV, depending on the
+// extension of `path`.
+func writeBenchResults(path string, r benchResults) error {
+	return writeResults(path, r, func(w io.Writer) error { return writeBenchCSV(w, r) })
+}
+
+// writeResults writes `v` as JSON or with `writeCSV`, depending on the
+// extension of `path`.
+func writeResults(path string, v interface{}, writeCSV func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "creating results file")
+	}
+	if filepath.Ext(path) == ".csv" {
+		err = writeCSV(f)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = errors.Wrap(enc.Encode(v), "writing json")
+	}
+	if cerr := f.Close(); err == nil {
+		err = errors.Wrap(cerr, "closing results file")
+	}
+	return err
+}
+
+// writeBenchCSV writes one row per channel and one for the total. Every row
+// repeats the metadata, so that rows of several runs can be collected in one
+// table. Durations are in nanoseconds.
+func writeBenchCSV(w io.Writer, r benchResults) error {
+	cw := csv.NewWriter(w)
+	header := []string{"time", "mode", "revision", "node", "transport", "peer", "workers", "rate", "warmup", "tx_count", "amount_plank", "n", "tx_per_sec", "collisions"}
+	for _, stat := range []string{"latency", "sign", "network"} {
+		for _, col := range []string{"min", "median", "mean", "p90", "p95", "p99", "p999", "max", "stddev"} {
+			header = append(header, stat+"_"+col+"_ns")
+		}
+	}
+	if err := cw.Write(header); err != nil {
+		return errors.Wrap(err, "writing csv")
+	}
+	m := r.Meta
+	for _, s := range append(r.Channels, r.Total) {
+		record := []string{
+			m.Time.Format(time.RFC3339Nano), m.Mode, m.Revision, m.Node, m.Transport, s.Peer,
+			strconv.Itoa(m.Workers), strconv.FormatFloat(m.Rate, 'f', -1, 64), strconv.Itoa(m.Warmup),
+			strconv.Itoa(m.TxCount), m.AmountPlank.String(), strconv.Itoa(s.N), strconv.FormatFloat(s.TxPerSec, 'f', 3, 64),
+			strconv.FormatUint(s.Collisions, 10),
+		}
+		for _, d := range []durationStats{s.Latency, s.Sign, s.Network} {
+			for _, v := range []time.Duration{d.Min, d.Median, d.Mean, d.P90, d.P95, d.P99, d.P999, d.Max, d.Stddev} {
+				record = append(record, strconv.FormatInt(int64(v), 10))
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return errors.Wrap(err, "writing csv")
+		}
+	}
+	cw.Flush()
+	return errors.Wrap(cw.Error(), "writing csv")
+}
+
+// buildRevision returns the revision set at build time or "unknown". The
+// working directory is not consulted, since it need not contain the sources
+// of the binary.
+func buildRevision() string {
+	if revision != "" {
+		return revision
+	}
+	return "unknown"
+}
@@ -0,0 +1,86 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"perun.network/go-perun/log"
+
+	pnode "github.com/perun-network/perun-polkadot-demo/pkg/node"
+)
+
+var certCmd = &cobra.Command{
+	Use:   "cert",
+	Short: "Generate a self-signed TLS certificate",
+	Long: `Generates a self-signed TLS certificate for the Perun ID of the node and writes it
+	to the files configured in 'node.tls'. The printed fingerprint must be pinned by the peers
+	in their network config.`,
+	Run: runCert,
+}
+
+var certForce bool
+
+func init() {
+	certCmd.Flags().BoolVar(&certForce, "force", false, "Overwrite existing certificate files")
+	demoCmd.AddCommand(certCmd)
+}
+
+func runCert(c *cobra.Command, args []string) {
+	if err := generateCert(config.Node.TLS, config.Sk); err != nil {
+		log.WithError(err).Fatalln("Could not generate certificate.")
+	}
+}
+
+func generateCert(cfg tlsConfig, sk string) error {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return errors.New("'node.tls.certFile' and 'node.tls.keyFile' must be set")
+	}
+	if !certForce {
+		for _, file := range []string{cfg.CertFile, cfg.KeyFile} {
+			if _, err := os.Stat(file); err == nil {
+				return errors.Errorf("'%s' exists, use --force to overwrite it", file)
+			}
+		}
+	}
+
+	id, err := pnode.PerunID(sk)
+	if err != nil {
+		return errors.WithMessage(err, "importing secret key")
+	}
+	certPEM, keyPEM, err := genCert(id)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(cfg.KeyFile, keyPEM, 0600); err != nil {
+		return errors.Wrap(err, "writing key")
+	}
+	if err := ioutil.WriteFile(cfg.CertFile, certPEM, 0644); err != nil {
+		return errors.Wrap(err, "writing certificate")
+	}
+
+	cert, err := cfg.load()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Generated certificate for %v.\n", id)
+	fmt.Fprintf(stdout, "Peers must add the following to their network config entry for '%s':\n", config.Alias)
+	fmt.Fprintf(stdout, "  certFingerprint: %s\n", fingerprint(cert.Leaf))
+	return nil
+}
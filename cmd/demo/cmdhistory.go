@@ -0,0 +1,239 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	prompt "github.com/c-bata/go-prompt"
+	"github.com/pkg/errors"
+	"perun.network/go-perun/log"
+)
+
+const (
+	// cmdHistoryDir is the directory in the home directory in which the
+	// command history is stored.
+	cmdHistoryDir = ".perun-demo"
+	// cmdHistorySize is the number of commands that are kept.
+	cmdHistorySize = 1000
+	// cmdHistoryListed is the number of commands that 'history-commands'
+	// lists by default.
+	cmdHistoryListed = 20
+	// replayCmd is the prefix of the commands that are not recorded, so
+	// that replays cannot replay themselves.
+	replayCmd = "history-commands"
+)
+
+// cmdHistory records the entered commands in a file per alias, so that they
+// can be recalled after a restart.
+type cmdHistory struct {
+	mtx   sync.Mutex
+	lines []string
+	// file is nil if the history is not persisted.
+	file *os.File
+
+	// The state of a reverse search with Ctrl-R.
+	query  string
+	result string
+	pos    int
+}
+
+// cmds is the command history of the REPL.
+var cmds = new(cmdHistory)
+
+// loadCmdHistory loads the command history of `alias`. The history is kept
+// in memory only if the file cannot be opened.
+func loadCmdHistory(alias string) *cmdHistory {
+	h := new(cmdHistory)
+	home, err := os.UserHomeDir()
+	if err != nil {
+		log.WithError(err).Warn("Command history is not persisted")
+		return h
+	}
+	dir := filepath.Join(home, cmdHistoryDir)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		log.WithError(err).Warn("Command history is not persisted")
+		return h
+	}
+	path := filepath.Join(dir, alias+"_history")
+	if err := h.load(path); err != nil {
+		log.WithError(err).Warn("Command history is not persisted")
+	}
+	return h
+}
+
+// load reads the history from `path`, truncates it to cmdHistorySize and
+// opens it for appending.
+func (h *cmdHistory) load(path string) error {
+	if f, err := os.Open(path); err == nil {
+		s := bufio.NewScanner(f)
+		for s.Scan() {
+			if line := s.Text(); line != "" {
+				h.lines = append(h.lines, line)
+			}
+		}
+		f.Close()
+		if err := s.Err(); err != nil {
+			return errors.Wrap(err, "reading command history")
+		}
+	} else if !os.IsNotExist(err) {
+		return errors.Wrap(err, "opening command history")
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if len(h.lines) > cmdHistorySize {
+		h.lines = h.lines[len(h.lines)-cmdHistorySize:]
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "opening command history")
+	}
+	if flags&os.O_TRUNC != 0 {
+		for _, line := range h.lines {
+			if _, err := fmt.Fprintln(f, line); err != nil {
+				f.Close()
+				return errors.Wrap(err, "writing command history")
+			}
+		}
+	}
+	h.file = f
+	return nil
+}
+
+// add records an entered command.
+func (h *cmdHistory) add(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, replayCmd) {
+		return
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.lines = append(h.lines, line)
+	if len(h.lines) > cmdHistorySize {
+		h.lines = h.lines[1:]
+	}
+	if h.file != nil {
+		if _, err := fmt.Fprintln(h.file, line); err != nil {
+			log.WithError(err).Warn("Writing command history")
+		}
+	}
+}
+
+// all returns a copy of the recorded commands, the oldest first.
+func (h *cmdHistory) all() []string {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	return append([]string(nil), h.lines...)
+}
+
+// search replaces the input with the next older command that contains the
+// input. Searching again without editing the result continues the search.
+func (h *cmdHistory) search(buf *prompt.Buffer) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if text := buf.Text(); text != h.result || h.result == "" {
+		h.query, h.pos = text, len(h.lines)
+	}
+	for h.pos--; h.pos >= 0; h.pos-- {
+		if line := h.lines[h.pos]; strings.Contains(line, h.query) && line != buf.Text() {
+			h.result = line
+			buf.CursorRight(len([]rune(buf.Document().TextAfterCursor())))
+			buf.DeleteBeforeCursor(len([]rune(buf.Text())))
+			buf.InsertText(line, false, true)
+			return
+		}
+	}
+	h.pos = 0 // No older match, searching again finds nothing.
+}
+
+func (h *cmdHistory) close() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.file != nil {
+		if err := h.file.Close(); err != nil {
+			log.WithError(err).Warn("Closing command history")
+		}
+		h.file = nil
+	}
+}
+
+// ListCmdHistory prints the last recorded commands, optionally only those
+// containing a filter.
+func ListCmdHistory(args []string) error {
+	lines := cmds.all()
+	filter, n := args[0], cmdHistoryListed
+	if filter != "" {
+		n = len(lines)
+	}
+	var listed []int
+	for i := len(lines) - 1; i >= 0 && len(listed) < n; i-- {
+		if strings.Contains(lines[i], filter) {
+			listed = append(listed, i)
+		}
+	}
+	for j := len(listed) - 1; j >= 0; j-- {
+		i := listed[j]
+		fmt.Fprintf(stdout, "%5d  %s\n", i+1, lines[i])
+	}
+	return nil
+}
+
+// ReplayCmdHistory executes the recorded commands with the given number or
+// range of numbers, e.g. 12 or 12-15, in order. It stops at the first error.
+func ReplayCmdHistory(args []string) error {
+	lines := cmds.all()
+	from, to, err := parseCmdRange(args[0], len(lines))
+	if err != nil {
+		return err
+	}
+	for _, line := range lines[from-1 : to] {
+		fmt.Fprintf(stdout, "↪ %s\n", line)
+		if err := Execute(line); err != nil {
+			return errors.WithMessagef(err, "replaying '%s'", line)
+		}
+		cmds.add(line)
+	}
+	return nil
+}
+
+// parseCmdRange parses a command number or range "from-to" of 1-based
+// command numbers.
+func parseCmdRange(arg string, n int) (from, to int, err error) {
+	fromStr, toStr := arg, arg
+	if i := strings.Index(arg, "-"); i > 0 {
+		fromStr, toStr = arg[:i], arg[i+1:]
+	}
+	if from, err = strconv.Atoi(fromStr); err == nil {
+		to, err = strconv.Atoi(toStr)
+	}
+	if err != nil {
+		return 0, 0, errors.Errorf("Invalid command number or range: %s", arg)
+	} else if from < 1 || to < from || to > n {
+		return 0, 0, errors.Errorf("Commands %s out of range, the history has %d", arg, n)
+	}
+	return from, to, nil
+}
+
+func valCmdRange(arg string) error {
+	_, _, err := parseCmdRange(arg, cmdHistorySize)
+	return err
+}
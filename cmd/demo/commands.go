@@ -16,8 +16,11 @@ package demo
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"strings"
+	"sync"
+	"unicode"
 
 	"github.com/pkg/errors"
 	"perun.network/go-perun/log"
@@ -26,10 +29,32 @@ import (
 type argument struct {
 	Name      string
 	Validator func(string) error
+	// Default replaces the argument if it is omitted.
+	Default string
+	// Flag makes the argument a named flag, e.g. --challenge 120s or
+	// --challenge=120s, that can be given anywhere after the command name
+	// and before the variadic argument. Flags are optional.
+	Flag bool
+	// Variadic takes all remaining positional arguments verbatim, including
+	// words that look like flags. Only the last argument of a command can be
+	// variadic.
+	Variadic bool
+	// Help describes a flag in the help of its command.
+	Help string
+	// Suggest returns the completions of the argument, nil for none.
+	Suggest suggester
+}
+
+// isOptional returns whether the argument can be omitted.
+func (a argument) isOptional() bool {
+	return a.Flag || a.Default != "" || a.Validator("") == nil
 }
 
 type command struct {
-	Name     string
+	Name string
+	// Args are the positional arguments and flags. The function receives
+	// their values in this order, with all values of a variadic argument
+	// at the end. Omitted arguments are passed as their default.
 	Args     []argument
 	Help     string
 	Function func([]string) error
@@ -41,24 +66,86 @@ func init() {
 	commands = []command{
 		{
 			"connect",
-			[]argument{{"Peer", valAlias}},
+			[]argument{{Name: "Peer", Validator: valAlias, Suggest: knownAliases}},
 			"Connect to a peer by their alias. The connection allows payment channels to be opened with the given peer.\nExample: connect bob",
 			func(args []string) error { return backend.Connect(args) },
 		}, {
 			"open",
-			[]argument{{"Peer", valAlias}, {"Our Balance", valBal}, {"Their Balance", valBal}},
-			"Open a payment channel with the given peer and balances. The first value is the own balance and the second value is the peers balance. It is only possible to open one channel per peer.\nExample: open alice 10 10",
+			[]argument{
+				{Name: "Peer", Validator: valAlias, Suggest: knownAliases},
+				{Name: "Our Balance", Validator: valBal},
+				{Name: "Their Balance", Validator: valBal},
+				{Name: "challenge", Validator: valChallenge, Flag: true, Help: "Challenge duration of the channel, e.g. 120s. Defaults to channel.challengeDurationSec of the config."},
+				{Name: "nonce", Validator: valNonce, Flag: true, Default: randomNonce, Suggest: options(randomNonce), Help: "Own nonce share of the channel ID, 'random' or 32 hex encoded bytes."},
+				{Name: "timeout", Validator: valTimeout, Flag: true, Help: "Timeout for the proposal and funding. Defaults to channel.fundTimeout of the config."},
+			},
+			"Open a payment channel with the given peer and balances. The first value is the own balance and the second value is the peers balance. It is only possible to open one channel per peer.\nExample: open alice 10 10 --challenge 120s",
 			func(args []string) error { return backend.Open(args) },
 		}, {
 			"send",
-			[]argument{{"Peer", valPeer}, {"Amount", valBal}},
-			"Send a payment with amount to a given peer over the established channel.\nExample: send alice 5",
+			[]argument{
+				{Name: "Peer", Validator: valPeer, Suggest: channelAliases},
+				{Name: "Amount", Validator: valBal},
+				{Name: "Memo", Validator: optional(valMemo), Variadic: true},
+			},
+			"Send a payment with amount to a given peer over the established channel. The optional memo is shown to the peer and stored in the history.\nExample: send alice 5 invoice 42",
 			func(args []string) error { return backend.Send(args) },
 		}, {
 			"close",
-			[]argument{{"Peer", valPeer}},
+			[]argument{
+				{Name: "Peer", Validator: valPeer, Suggest: channelAliases},
+				{Name: "timeout", Validator: valTimeout, Flag: true, Help: "Timeout for the settlement. Defaults to channel.settleTimeout of the config."},
+			},
 			"Close a the channel with the given peer. This will push the latest state to the block chain.\nExample: close alice",
 			func(args []string) error { return backend.Close(args) },
+		}, {
+			"peer add",
+			[]argument{{Name: "Alias", Validator: valString}, {Name: "Perun ID", Validator: valAddress}, {Name: "Host", Validator: valHost}},
+			"Add a peer with the given alias, Perun ID and host:port, relay address or, with the ws transport, WebSocket URL. The peer is saved in the network config file.\nExample: peer add carol 0x90b5ab205c6974c9ea841be688864633dc9ca8a357843eeacf2314649965fe22 127.0.0.1:5752",
+			func(args []string) error { return backend.AddPeer(args) },
+		}, {
+			"peer remove",
+			[]argument{{Name: "Alias", Validator: valAlias, Suggest: knownAliases}},
+			"Remove a peer from the network config file. A channel with the peer must be closed first.\nExample: peer remove carol",
+			func(args []string) error { return backend.RemovePeer(args) },
+		}, {
+			"peer pin",
+			[]argument{{Name: "Alias", Validator: valAlias, Suggest: knownAliases}, {Name: "Fingerprint", Validator: valFingerprint}},
+			"Pin the TLS certificate fingerprint of a peer. Only needed if TLS is enabled.\nExample: peer pin carol 5d41402abc4b2a76b9719d911017c592ae2a5ab3b0e9b6a1c3f1e2d4f5a6b7c8",
+			func(args []string) error { return backend.PinPeer(args) },
+		}, {
+			"peer list",
+			nil,
+			"Print all known peers.",
+			func(args []string) error { return backend.ListPeers(args) },
+		}, {
+			"discover",
+			nil,
+			"Print the peers discovered in the local network. Discovered peers can be used like known peers, e.g. with 'connect'.",
+			func(args []string) error { return backend.Discover(args) },
+		}, {
+			"history export",
+			[]argument{{Name: "Format", Validator: valExportFormat, Suggest: options("--csv", "--json")}, {Name: "File", Validator: optional(valString)}},
+			"Export the whole channel history as CSV or JSON to the given file or stdout. Amounts are in Plank.\nExample: history export --csv history.csv",
+			func(args []string) error { return backend.ExportHistory(args) },
+		}, {
+			"history",
+			[]argument{
+				{Name: "Peer", Validator: optional(valString), Suggest: connectedAliases},
+				{Name: "since", Validator: valSince, Flag: true, Help: "Only entries since a duration like 24h or a date like 2006-01-02."},
+			},
+			"Print the channel history, optionally only with the given peer and since a duration or date.\nExample: history bob --since 24h",
+			func(args []string) error { return backend.History(args) },
+		}, {
+			"history-commands replay",
+			[]argument{{Name: "Number", Validator: valCmdRange}},
+			"Execute the entered commands with the given number or range of numbers again, in order. Stops at the first error.\nExample: history-commands replay 12-15",
+			ReplayCmdHistory,
+		}, {
+			"history-commands",
+			[]argument{{Name: "Filter", Validator: optional(valString)}},
+			"Print the last entered commands with their numbers, optionally all that contain the filter. The commands are kept across restarts. Ctrl-R searches them while typing.\nExample: history-commands open",
+			ListCmdHistory,
 		}, {
 			"config",
 			nil,
@@ -69,94 +156,432 @@ func init() {
 			nil,
 			"Print information about funds, peers, and channels.",
 			func(args []string) error { return backend.Info(args) },
+		}, {
+			"benchmark pingpong accept",
+			[]argument{
+				{Name: "Peer", Validator: valPeer, Suggest: channelAliases},
+				{Name: "txCount", Validator: valUInt},
+			},
+			"Agree to pay back up to txCount payments of the next ping-pong benchmark of the given peer. Without it, a ping-pong of the peer is ignored.\nExample: benchmark pingpong accept bob 100",
+			func(args []string) error { return backend.AcceptPingPong(args) },
+		}, {
+			"benchmark pingpong",
+			[]argument{
+				{Name: "Peer", Validator: valPeer, Suggest: channelAliases},
+				{Name: "Amount", Validator: valBal},
+				{Name: "txCount", Validator: valUInt},
+				benchOutFlag,
+			},
+			"Performs a ping-pong benchmark with the given peer: every payment of amount Dot is paid back by the peer before the next one is sent. The round-trip latencies as seen by both nodes and collisions with concurrent updates of the peer are printed.\nExample: benchmark pingpong alice 0.1 100",
+			func(args []string) error { return backend.PingPong(args) },
+		}, {
+			"benchmark onchain accept",
+			[]argument{
+				{Name: "Peer", Validator: valAlias, Suggest: knownAliases},
+				{Name: "iterations", Validator: valUInt},
+				{Name: "timeout", Validator: valTimeout, Flag: true, Help: "Time in which the proposals are accepted. Defaults to channel.fundTimeout plus channel.settleTimeout of the config per iteration."},
+			},
+			"Agree to accept up to the given number of channel proposals of an on-chain benchmark of the given peer without asking. Only proposals in which both deposit 1 Dot with the configured challenge duration are accepted.\nExample: benchmark onchain accept bob 10",
+			func(args []string) error { return backend.AcceptOnChainBenchmark(args) },
+		}, {
+			"benchmark onchain",
+			[]argument{
+				{Name: "Peer", Validator: valPeer, Suggest: connectedAliases},
+				{Name: "iterations", Validator: valUInt},
+				benchOutFlag,
+			},
+			"Performs an on-chain benchmark with the given peer: a channel in which both deposit 1 Dot is opened, funded, closed and settled the given number of times. The peer accepts the proposals automatically if it agreed with 'benchmark onchain accept'. The duration, the number of blocks waited and the fees paid are printed per phase.\nExample: benchmark onchain alice 10 --out onchain.json",
+			func(args []string) error { return backend.OnChainBenchmark(args) },
+		}, {
+			"benchmark compare",
+			[]argument{
+				{Name: "Baseline", Validator: valString},
+				{Name: "Current", Validator: valString},
+				{Name: "threshold", Validator: valThreshold, Flag: true, Default: fmt.Sprint(defaultCompareConfig().threshold), Help: "Tolerated deterioration in percent."},
+				{Name: "alpha", Validator: valAlpha, Flag: true, Default: fmt.Sprint(defaultCompareConfig().alpha), Help: "Significance level of the Mann-Whitney U test."},
+			},
+			"Compare the throughput and latency percentiles of two benchmark results written with --out FILE.json. A regression is reported if a metric is worse by more than the threshold and the latencies are significantly higher according to a one-sided Mann-Whitney U test.\nExample: benchmark compare baseline.json results.json --threshold 10",
+			CompareBenchmarks,
 		}, {
 			"benchmark",
-			[]argument{{"Peer", valPeer}, {"amount", valUInt}, {"txCount", valUInt}},
-			"Performs a benchmark with the given peer by sending amount Dot in txCount micro transactions. Must have an open channel with the peer.",
+			[]argument{
+				{Name: "Peers", Validator: valBenchPeers, Suggest: benchPeers},
+				{Name: "amount", Validator: valUInt},
+				{Name: "txCount", Validator: valUInt},
+				{Name: "workers", Validator: valWorkers, Flag: true, Help: "Number of concurrent senders. Defaults to one per channel."},
+				{Name: "rate", Validator: valRate, Flag: true, Help: "Target rate in tx/s over all channels. Sends as fast as possible if omitted."},
+				{Name: "warmup", Validator: valUInt, Flag: true, Help: "Number of unrecorded transactions per channel before the measurement."},
+				benchOutFlag,
+			},
+			"Performs a benchmark with the given peers by sending amount Dot in txCount micro transactions over every channel. Peers is a comma separated list of aliases or 'all' for all open channels.\nExample: benchmark bob,carol 10 100 --workers 4 --rate 50 --warmup 10 --out results.json",
 			func(args []string) error { return backend.Benchmark(args) },
 		}, {
 			"help",
-			nil,
-			"Prints all possible commands.",
+			[]argument{{Name: "Command", Validator: optional(valString), Variadic: true}},
+			"Prints all possible commands or the detailed help of the given command. 'COMMAND --help' also prints the detailed help.\nExample: help open",
 			printHelp,
 		}, {
 			"exit",
 			nil,
 			"Exits the program.",
 			func(args []string) error {
-				if err := backend.Exit(args); err != nil {
-					log.Error("err while exiting: ", err)
-				}
-				os.Exit(0)
+				exit(0)
 				return nil
 			},
 		},
 	}
 }
 
-var prompts = make(chan func(string), 1)
+// benchOutFlag is the --out flag of the benchmarks.
+var benchOutFlag = argument{Name: "out", Validator: valBenchOut, Flag: true, Help: "Writes the results to a .json or .csv file."}
+
+var (
+	promptsMtx sync.Mutex
+	// prompts are the open questions, the oldest is answered first.
+	prompts []func(string) bool
+)
 
 // AddInput adds an input to the input command queue.
 func AddInput(in string) {
-	select {
-	case f := <-prompts:
-		f(in)
-	default:
-		if err := Execute(in); err != nil {
-			fmt.Println("\033[0;33m⚡\033[0m", err)
-		}
+	if answerPrompt(in) {
+		return
+	}
+	cmds.add(in)
+	if err := Execute(in); err != nil {
+		fmt.Fprintln(stdout, "\033[0;33m⚡\033[0m", err)
 	}
 }
 
-// Prompt waits for input on the command line and then executes the given
-// function with the input.
-func Prompt(msg string, f func(string)) {
+// Prompt prints the question `msg` and answers it with the next input. The
+// answer function returns false if the question was already answered
+// otherwise, e.g. in the web UI, then the input is passed on.
+func Prompt(msg string, answer func(string) bool) {
+	promptsMtx.Lock()
+	prompts = append(prompts, answer)
+	promptsMtx.Unlock()
 	PrintfAsync(msg)
-	prompts <- f
+}
+
+// answerPrompt answers the oldest open question with `in` and returns
+// whether there was one.
+func answerPrompt(in string) bool {
+	for {
+		promptsMtx.Lock()
+		if len(prompts) == 0 {
+			promptsMtx.Unlock()
+			return false
+		}
+		answer := prompts[0]
+		prompts = prompts[1:]
+		promptsMtx.Unlock()
+		if answer(in) {
+			return true
+		}
+	}
+}
+
+// stdout is the output of the commands and events. The dashboard redirects
+// it to its event log.
+var stdout = &output{w: os.Stdout}
+
+// output is a writer whose destination can be switched while other
+// goroutines write to it.
+type output struct {
+	mtx sync.Mutex
+	w   io.Writer
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	return o.w.Write(p)
+}
+
+// redirect switches the destination to `w` and returns the previous one.
+func (o *output) redirect(w io.Writer) io.Writer {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	prev := o.w
+	o.w = w
+	return prev
 }
 
 // PrintfAsync prints the given message for an asynchronous event. More
 // precisely, the message is prepended with a newline and appended with the
-// command prefix.
+// command prefix. The dashboard shows it in the event log instead. It is also
+// sent to the web UI.
 func PrintfAsync(format string, a ...interface{}) {
-	fmt.Printf("\r"+format+"> ", a...)
+	webEvents.publish(strings.TrimSpace(fmt.Sprintf(format, a...)))
+	if ui.isRunning() {
+		fmt.Fprintf(stdout, format, a...)
+		return
+	}
+	fmt.Fprintf(stdout, "\r"+format+"> ", a...)
 }
 
 // Execute interprets commands entered by the user.
 func Execute(in string) error {
-	in = strings.TrimSpace(in)
-	args := strings.Split(in, " ")
-	command := args[0]
-	args = args[1:]
+	args, err := splitArgs(in)
+	if err != nil {
+		return err
+	} else if len(args) == 0 {
+		return nil
+	}
+
+	log.Tracef("Reading command '%s'\n", args[0])
+	cmd, args, ok := findCommand(args)
+	if !ok {
+		return errors.Errorf("Unknown command: %s. Enter \"help\" for a list of commands.", args[0])
+	}
+	values, err := cmd.parse(args)
+	if errors.Is(err, errHelp) {
+		printCommandHelp(cmd)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return cmd.Function(values)
+}
 
-	log.Tracef("Reading command '%s'\n", command)
+// findCommand returns the command that `args` start with and the remaining
+// arguments. Command names can consist of multiple words, e.g. 'peer add'.
+func findCommand(args []string) (command, []string, bool) {
 	for _, cmd := range commands {
-		if cmd.Name == command {
-			if len(args) != len(cmd.Args) {
-				return errors.Errorf("Invalid number of arguments, expected %d but got %d", len(cmd.Args), len(args))
+		words := strings.Split(cmd.Name, " ")
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.Name {
+			return cmd, args[len(words):], true
+		}
+	}
+	return command{}, args, false
+}
+
+// errHelp is returned by parse if the input asks for the help of the command.
+var errHelp = errors.New("help requested")
+
+// parse assigns the input to the flags and positional arguments of the
+// command and validates them. It returns the values in the order of Args.
+// Flags and --help are only recognized before the variadic argument and
+// before a "--", the remaining input is taken verbatim.
+func (c command) parse(input []string) ([]string, error) {
+	flags := make(map[string]string)
+	var positional []string
+	variadic := c.variadic()
+	for i := 0; i < len(input); i++ {
+		if input[i] == "--" {
+			positional = append(positional, input[i+1:]...)
+			break
+		} else if variadic >= 0 && len(positional) > variadic {
+			positional = append(positional, input[i:]...)
+			break
+		} else if input[i] == "--help" {
+			return nil, errHelp
+		}
+		name, value, hasValue := cutFlag(input[i])
+		flag, ok := c.flag(name)
+		if !ok {
+			positional = append(positional, input[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(input) {
+				return nil, errors.Errorf("Missing value of --%s for '%s'", flag.Name, c.Name)
+			}
+			i++
+			value = input[i]
+		}
+		flags[flag.Name] = value
+	}
+
+	values := make([]string, 0, len(c.Args))
+	for _, a := range c.Args {
+		var given []string
+		switch {
+		case a.Flag:
+			if v, ok := flags[a.Name]; ok {
+				given = []string{v}
+			}
+		case a.Variadic:
+			given, positional = positional, nil
+		case len(positional) > 0:
+			given, positional = positional[:1], positional[1:]
+		}
+		if len(given) == 0 {
+			given = []string{a.Default}
+		}
+		for _, v := range given {
+			if err := c.validate(a, v); err != nil {
+				return nil, err
 			}
-			for i, arg := range args {
-				if err := cmd.Args[i].Validator(arg); err != nil {
-					return errors.WithMessagef(err, "'%s' argument invalid for '%s': %v", cmd.Args[i].Name, command, arg)
-				}
+		}
+		values = append(values, given...)
+	}
+
+	if len(positional) > 0 {
+		for _, arg := range positional {
+			if strings.HasPrefix(arg, "--") {
+				return nil, errors.Errorf("Unknown flag %s for '%s'", arg, c.Name)
 			}
-			return cmd.Function(args)
 		}
+		return nil, errors.Errorf("Invalid number of arguments, expected at most %d but got %d", len(c.positional()), len(input))
+	}
+	return values, nil
+}
+
+// validate validates the value of argument `a`. Empty values are only
+// accepted for optional arguments.
+func (c command) validate(a argument, value string) error {
+	if value == "" {
+		if !a.isOptional() {
+			return errors.Errorf("Missing '%s' argument for '%s'", a.Name, c.Name)
+		}
+		return nil
 	}
-	if len(command) > 0 {
-		return errors.Errorf("Unknown command: %s. Enter \"help\" for a list of commands.", command)
+	if err := a.Validator(value); err != nil {
+		return errors.WithMessagef(err, "'%s' argument invalid for '%s': %v", a.Name, c.Name, value)
 	}
 	return nil
 }
 
+// flag returns the flag with the given name.
+func (c command) flag(name string) (argument, bool) {
+	for _, a := range c.Args {
+		if a.Flag && a.Name == name {
+			return a, true
+		}
+	}
+	return argument{}, false
+}
+
+// positional returns the arguments that are not flags.
+func (c command) positional() []argument {
+	var args []argument
+	for _, a := range c.Args {
+		if !a.Flag {
+			args = append(args, a)
+		}
+	}
+	return args
+}
+
+// variadic returns the index of the variadic argument among the positional
+// arguments or -1 if there is none.
+func (c command) variadic() int {
+	for i, a := range c.positional() {
+		if a.Variadic {
+			return i
+		}
+	}
+	return -1
+}
+
+// cutFlag splits a flag like --name=value or --name into its name and value.
+func cutFlag(arg string) (name, value string, hasValue bool) {
+	if !strings.HasPrefix(arg, "--") {
+		return "", "", false
+	}
+	name = strings.TrimPrefix(arg, "--")
+	if i := strings.Index(name, "="); i >= 0 {
+		return name[:i], name[i+1:], true
+	}
+	return name, "", false
+}
+
+// splitArgs splits the input at whitespace. Double quotes group words into a
+// single argument, e.g. send bob 5 "invoice 42". Inside of quotes, \" is a
+// literal quote.
+func splitArgs(in string) ([]string, error) {
+	var (
+		args    []string
+		arg     strings.Builder
+		inArg   bool // Whether arg holds an argument, possibly empty "".
+		quoted  bool
+		escaped bool
+	)
+	for _, r := range in {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted, inArg = !quoted, true
+		case !quoted && unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("Unterminated quote")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
 func printHelp(args []string) error {
+	if name := strings.Join(args, " "); name != "" {
+		cmd, rest, ok := findCommand(args)
+		if !ok || len(rest) > 0 {
+			return errors.Errorf("Unknown command: %s", name)
+		}
+		printCommandHelp(cmd)
+		return nil
+	}
 	for _, cmd := range commands {
-		fmt.Print(cmd.Name, " ")
-		for _, arg := range cmd.Args {
-			fmt.Printf("<%s> ", arg.Name)
+		fmt.Fprintf(stdout, "%s\n\t%s\n\n", usage(cmd), strings.ReplaceAll(cmd.Help, "\n", "\n\t"))
+	}
+	return nil
+}
+
+// printCommandHelp prints the usage, help and flags of a command.
+func printCommandHelp(cmd command) {
+	fmt.Fprintf(stdout, "Usage: %s\n\n%s\n", usage(cmd), cmd.Help)
+	var flags []argument
+	for _, a := range cmd.Args {
+		if a.Flag {
+			flags = append(flags, a)
 		}
-		fmt.Printf("\n\t%s\n\n", strings.ReplaceAll(cmd.Help, "\n", "\n\t"))
 	}
+	if len(flags) == 0 {
+		return
+	}
+	fmt.Fprintln(stdout, "\nFlags:")
+	for _, f := range flags {
+		help := f.Help
+		if f.Default != "" {
+			help += fmt.Sprintf(" Default: %s.", f.Default)
+		}
+		fmt.Fprintf(stdout, "  --%-12s %s\n", f.Name, help)
+	}
+}
 
-	return nil
+// usage returns the command with its arguments, e.g.
+// "send <Peer> <Amount> [Memo...]".
+func usage(cmd command) string {
+	words := []string{cmd.Name}
+	for _, a := range cmd.Args {
+		words = append(words, argPlaceholder(a))
+	}
+	return strings.Join(words, " ")
+}
+
+func argPlaceholder(a argument) string {
+	name := a.Name
+	if a.Flag {
+		name = "--" + a.Name + " " + strings.ToUpper(a.Name)
+	} else if a.Variadic {
+		name += "..."
+	}
+	if a.isOptional() {
+		return "[" + name + "]"
+	}
+	return "<" + name + ">"
 }
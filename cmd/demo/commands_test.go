@@ -0,0 +1,70 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCommandParse(t *testing.T) {
+	cmd := command{
+		Name: "send",
+		Args: []argument{
+			{Name: "Peer", Validator: valString},
+			{Name: "Amount", Validator: valString},
+			{Name: "timeout", Validator: valString, Flag: true, Default: "10s"},
+			{Name: "Memo", Validator: optional(valString), Variadic: true},
+		},
+	}
+	tests := []struct {
+		name  string
+		input []string
+		want  []string
+		err   error
+	}{
+		{"no memo", []string{"bob", "1"}, []string{"bob", "1", "10s", ""}, nil},
+		{"memo", []string{"bob", "1", "see", "invoice"}, []string{"bob", "1", "10s", "see", "invoice"}, nil},
+		{"flag", []string{"--timeout", "5s", "bob", "1"}, []string{"bob", "1", "5s", ""}, nil},
+		{"flag with value", []string{"bob", "--timeout=5s", "1", "hi"}, []string{"bob", "1", "5s", "hi"}, nil},
+		{"flag before memo", []string{"bob", "1", "--timeout=5s", "hi"}, []string{"bob", "1", "5s", "hi"}, nil},
+		{"flag in memo", []string{"bob", "1", "see", "--timeout=5s"}, []string{"bob", "1", "10s", "see", "--timeout=5s"}, nil},
+		{"unknown flag in memo", []string{"bob", "1", "a", "--b"}, []string{"bob", "1", "10s", "a", "--b"}, nil},
+		{"help in memo", []string{"bob", "1", "see", "--help"}, []string{"bob", "1", "10s", "see", "--help"}, nil},
+		{"help", []string{"bob", "--help"}, nil, errHelp},
+		{"help before memo", []string{"bob", "1", "--help"}, nil, errHelp},
+		{"terminator", []string{"bob", "--", "1", "--help"}, []string{"bob", "1", "10s", "--help"}, nil},
+		{"terminated memo", []string{"bob", "1", "--", "--timeout=5s"}, []string{"bob", "1", "10s", "--timeout=5s"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cmd.parse(tt.input)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("unknown flag", func(t *testing.T) {
+		cmd := command{Name: "connect", Args: []argument{{Name: "Peer", Validator: valString}}}
+		if _, err := cmd.parse([]string{"bob", "--b"}); err == nil {
+			t.Error("Unknown flag accepted")
+		}
+	})
+}
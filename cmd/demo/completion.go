@@ -0,0 +1,211 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"sort"
+	"strings"
+
+	prompt "github.com/c-bata/go-prompt"
+)
+
+// suggester returns the candidates for an argument.
+type suggester func() []string
+
+// completer suggests the command names, the peers and the flags for the
+// input before the cursor, and hints at the next argument.
+func completer(d prompt.Document) []prompt.Suggest {
+	if strings.TrimSpace(d.TextBeforeCursor()) == "" {
+		return nil
+	}
+	args, err := splitArgs(d.TextBeforeCursor())
+	if err != nil {
+		return nil // Inside of a quoted argument.
+	}
+	// The word under the cursor is being completed, it is empty after a
+	// space.
+	word := d.GetWordBeforeCursor()
+	if word == "" {
+		args = append(args, "")
+	}
+	suggests := suggestCommands(args)
+	if cmd, done, ok := findCommand(args[:len(args)-1]); ok {
+		suggests = append(suggests, suggestArgs(cmd, done, word)...)
+	}
+	return suggests
+}
+
+// suggestCommands suggests the next word of the command names that start with
+// the complete words of `args`.
+func suggestCommands(args []string) []prompt.Suggest {
+	done, word := args[:len(args)-1], args[len(args)-1]
+	var (
+		suggests []prompt.Suggest
+		index    = make(map[string]int) // Position of a word in suggests.
+	)
+	for _, cmd := range commands {
+		names := strings.Split(cmd.Name, " ")
+		if len(names) <= len(done) || strings.Join(names[:len(done)], " ") != strings.Join(done, " ") ||
+			!strings.HasPrefix(names[len(done)], word) {
+			continue
+		}
+		next := names[len(done)]
+		desc := usage(cmd)
+		if len(names) > len(done)+1 {
+			desc = strings.Join(names[:len(done)+1], " ") + " …"
+		}
+		if i, ok := index[next]; ok {
+			// A complete command describes the word better than its
+			// sub-commands, e.g. 'benchmark'.
+			if len(names) == len(done)+1 {
+				suggests[i].Description = desc
+			}
+			continue
+		}
+		index[next] = len(suggests)
+		suggests = append(suggests, prompt.Suggest{Text: next, Description: desc})
+	}
+	return suggests
+}
+
+// suggestArgs suggests the values of the argument or flag that `word` is
+// assigned to, given the complete arguments `done` before it.
+func suggestArgs(cmd command, done []string, word string) []prompt.Suggest {
+	// A flag without a value takes the word as value.
+	if n := len(done); n > 0 {
+		if name, _, hasValue := cutFlag(done[n-1]); !hasValue {
+			if f, ok := cmd.flag(name); ok {
+				return suggestValues(f, word)
+			}
+		}
+	}
+
+	i := 0 // Index of the positional argument of the word.
+	for j := 0; j < len(done); j++ {
+		if name, _, hasValue := cutFlag(done[j]); name != "" {
+			if _, ok := cmd.flag(name); ok {
+				if !hasValue {
+					j++ // Skip the value.
+				}
+				continue
+			}
+		}
+		i++
+	}
+	var suggests []prompt.Suggest
+	pos := cmd.positional()
+	if i >= len(pos) && len(pos) > 0 && pos[len(pos)-1].Variadic {
+		i = len(pos) - 1
+	}
+	if i < len(pos) {
+		suggests = suggestValues(pos[i], word)
+	}
+	if strings.HasPrefix(word, "-") || (word == "" && i >= len(pos)) {
+		suggests = append(suggests, suggestFlags(cmd, done, word)...)
+	}
+	return suggests
+}
+
+// suggestValues suggests the candidates of argument `a` that start with
+// `word`. Without candidates, it hints at the name of the argument.
+func suggestValues(a argument, word string) []prompt.Suggest {
+	var candidates []string
+	if a.Suggest != nil {
+		candidates = a.Suggest()
+	}
+	// Lists of peers are completed after the last comma.
+	prefix, listed := "", make(map[string]bool)
+	if j := strings.LastIndex(word, ","); j >= 0 {
+		prefix, word = word[:j+1], word[j+1:]
+		listed["all"] = true // Cannot be combined with peers.
+		for _, alias := range strings.Split(prefix, ",") {
+			listed[alias] = true
+		}
+	}
+	desc := a.Name
+	if a.Flag {
+		desc = a.Help
+	}
+	var suggests []prompt.Suggest
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) && !listed[c] {
+			suggests = append(suggests, prompt.Suggest{Text: prefix + c, Description: desc})
+		}
+	}
+	if len(suggests) == 0 && word == "" && prefix == "" {
+		return []prompt.Suggest{{Text: "<" + a.Name + ">", Description: desc}}
+	}
+	return suggests
+}
+
+// suggestFlags suggests the flags of `cmd` that start with `word` and are not
+// in `done` yet.
+func suggestFlags(cmd command, done []string, word string) []prompt.Suggest {
+	given := make(map[string]bool)
+	for _, arg := range done {
+		name, _, _ := cutFlag(arg)
+		given[name] = true
+	}
+	var suggests []prompt.Suggest
+	for _, a := range cmd.Args {
+		if a.Flag && !given[a.Name] && strings.HasPrefix("--"+a.Name, word) {
+			suggests = append(suggests, prompt.Suggest{Text: "--" + a.Name, Description: a.Help})
+		}
+	}
+	return suggests
+}
+
+func options(opts ...string) suggester {
+	return func() []string { return opts }
+}
+
+// knownAliases returns the aliases of the network config and the discovered
+// peers, without the own alias.
+func knownAliases() []string {
+	peersMtx.RLock()
+	defer peersMtx.RUnlock()
+	var aliases []string
+	for alias := range config.Peers {
+		if alias != config.Alias {
+			aliases = append(aliases, alias)
+		}
+	}
+	for alias := range discoveredPeers {
+		if _, ok := config.Peers[alias]; !ok {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// channelAliases returns the aliases of the peers with an open channel.
+func channelAliases() []string {
+	return backend.channelAliases()
+}
+
+// connectedAliases returns the aliases of the peers known to the node.
+func connectedAliases() []string {
+	peers := backend.core.Peers()
+	aliases := make([]string, len(peers))
+	for i, p := range peers {
+		aliases[i] = p.Alias
+	}
+	return aliases
+}
+
+func benchPeers() []string {
+	return append([]string{"all"}, channelAliases()...)
+}
@@ -16,10 +16,14 @@ package demo
 
 import (
 	"log"
+	"strconv"
 	"time"
 
+	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
 	"github.com/spf13/viper"
 	"perun.network/go-perun/wire"
+
+	"github.com/perun-network/perun-polkadot-demo/cmd/relay"
 )
 
 // Config contains all configuration read from config.yaml and network.yaml
@@ -30,6 +34,9 @@ type (
 		Channel channelConfig
 		Node    nodeConfig
 		Chain   chainConfig
+		Limits  limitsConfig
+		// Hooks are executed on channel events.
+		Hooks []hookConfig
 		// Read from the network.yaml. The key is the alias.
 		Peers map[string]*netConfigEntry
 	}
@@ -47,21 +54,67 @@ type (
 		DialTimeout      time.Duration
 		HandleTimeout    time.Duration
 		ReconnectTimeout time.Duration
+		// PingInterval is the interval in which connected peers are pinged.
+		// Zero disables the pings.
+		PingInterval time.Duration
 
 		PersistencePath    string
 		PersistenceEnabled bool
+		// HistoryPath is the file in which the channel history is recorded.
+		// Empty disables the history.
+		HistoryPath string
+		// Shutdown is either persist (default) to keep channels open on exit
+		// or close to close them cooperatively.
+		Shutdown string
+
+		// Transport is the wire transport, either tcp or ws. Defaults to tcp.
+		Transport string
+		// Relay is the host:port of a relay at which the node registers to
+		// be reachable behind a NAT. Optional.
+		Relay string
+		// TLS secures the wire connections if set.
+		TLS tlsConfig
+		// Discovery announces the node in the local network.
+		Discovery discoveryConfig
+		// Metrics is the host:port on which Prometheus metrics are served
+		// under /metrics. Empty disables the metrics.
+		Metrics string
+		// Web is the host:port on which the web UI is served. Empty disables
+		// the web UI.
+		Web string
+	}
+
+	chainConfig struct {
+		NodeUrl         string        `json:"node_url"`
+		NetworkId       dot.NetworkID `json:"network_id"`
+		TxTimeoutSec    uint32        `json:"tx_timeout_sec"`
+		BlockQueryDepth uint32        `json:"block_query_depth"` // Actually of type types.BlockNumber.
 	}
 
 	netConfigEntry struct {
-		PerunID  string
+		PerunID  string `yaml:"perunID"`
 		perunID  wire.Address
-		Hostname string
-		Port     uint16
+		Hostname string `yaml:"hostname"`
+		Port     uint16 `yaml:"port"`
+		// CertFingerprint pins the peer's TLS certificate.
+		CertFingerprint string `yaml:"certFingerprint,omitempty"`
+		// URL overrides hostname and port. It is either a relay address
+		// relay://<host>:<port>/<perunID> or, for the ws transport, a
+		// WebSocket URL, e.g. when the peer is behind a reverse proxy.
+		URL string `yaml:"url,omitempty"`
 	}
 )
 
 var config Config
 
+// address returns the address at which the peer is dialed.
+func (e *netConfigEntry) address() string {
+	if relay.IsURL(e.URL) || (e.URL != "" && config.Node.Transport == transportWS) {
+		return e.URL
+	}
+	return e.Hostname + ":" + strconv.Itoa(int(e.Port))
+}
+
 // GetConfig returns a pointer to the current `Config`.
 // This is needed to make viper and cobra work together.
 func GetConfig() *Config {
@@ -15,9 +15,15 @@
 package demo
 
 import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
 	prompt "github.com/c-bata/go-prompt"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"perun.network/go-perun/log"
 )
 
 var demoCmd = &cobra.Command{
@@ -27,13 +33,15 @@ var demoCmd = &cobra.Command{
 	The channels are funded and settled on an Polkadot blockchain, leaving out the dispute case.
 
 	It illustrates what Perun is capable of.`,
-	Run: runDemo,
+	PersistentPreRun: loadConfig,
+	Run:              runDemo,
 }
 
 // CommandLineFlags contains the command line flags.
 type CommandLineFlags struct {
 	cfgFile    string
 	cfgNetFile string
+	tui        bool
 }
 
 var flags CommandLineFlags
@@ -42,7 +50,9 @@ func init() {
 	demoCmd.PersistentFlags().StringVar(&flags.cfgFile, "config", "config.yaml", "General config file")
 	demoCmd.PersistentFlags().StringVar(&flags.cfgNetFile, "network", "network.yaml", "Network config file")
 	demoCmd.PersistentFlags().BoolVar(&GetConfig().Node.PersistenceEnabled, "persistence", false, "Enables the persistence")
+	demoCmd.PersistentFlags().BoolVar(&GetConfig().Node.Discovery.Enabled, "discovery", false, "Enables the local peer discovery")
 	demoCmd.PersistentFlags().StringVar(&GetConfig().Sk, "secretkey", "", "Hex secret key 0x…")
+	demoCmd.Flags().BoolVar(&flags.tui, "tui", false, "Shows a full-screen dashboard instead of the prompt")
 	err := viper.BindPFlag("secretkey", demoCmd.PersistentFlags().Lookup("secretkey"))
 	if err != nil {
 		panic(err)
@@ -54,20 +64,103 @@ func GetDemoCmd() *cobra.Command {
 	return demoCmd
 }
 
+// loadConfig parses the config files before running the pre-run of the root
+// command, so that it can apply the log section of the config.
+func loadConfig(c *cobra.Command, args []string) {
+	SetConfig(flags.cfgFile, flags.cfgNetFile)
+	if root := c.Root(); root != c && root.PersistentPreRun != nil {
+		root.PersistentPreRun(c, args)
+	}
+}
+
 // runDemo is executed everytime the program is started with the `demo` sub-command.
+// Ctrl-C, Ctrl-D, SIGINT and SIGTERM shut the node down gracefully. The
+// command history is loaded from the previous runs and Ctrl-R searches it.
+// With --tui, the full-screen dashboard replaces the prompt.
 func runDemo(c *cobra.Command, args []string) {
 	Setup()
+	cmds = loadCmdHistory(config.Alias)
+	if flags.tui {
+		runTUI()
+		exit(0)
+	}
+	var quit int32
+	in := &inputParser{ConsoleParser: prompt.NewStandardInputParser()}
 	p := prompt.New(
 		executor,
 		completer,
 		prompt.OptionPrefix("> "),
 		prompt.OptionTitle("perun"),
+		prompt.OptionParser(in),
+		prompt.OptionHistory(cmds.all()),
+		prompt.OptionAddKeyBind(prompt.KeyBind{
+			Key: prompt.ControlR,
+			Fn:  cmds.search,
+		}),
+		prompt.OptionAddKeyBind(prompt.KeyBind{
+			Key: prompt.ControlC,
+			Fn:  func(*prompt.Buffer) { atomic.StoreInt32(&quit, 1) },
+		}),
+		prompt.OptionSetExitCheckerOnInput(func(string, bool) bool {
+			return atomic.LoadInt32(&quit) == 1
+		}),
 	)
-	p.Run()
+	go handleSignals(in)
+
+	for {
+		line := p.Input()
+		if atomic.LoadInt32(&quit) == 1 || in.eof() {
+			break
+		}
+		executor(line)
+	}
+	exit(0)
+}
+
+// handleSignals shuts the node down on SIGINT or SIGTERM.
+func handleSignals(in prompt.ConsoleParser) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	sig := <-sigs
+	if err := in.TearDown(); err != nil {
+		log.Error("err while restoring terminal: ", err)
+	}
+	log.Infof("Received %v", sig)
+	exit(1)
+}
+
+// exit shuts the node down and exits the program. The dashboard is closed
+// first, so that the shutdown is printed to the terminal.
+func exit(code int) {
+	ui.close()
+	if err := backend.Exit(nil); err != nil {
+		log.Error("err while exiting: ", err)
+	}
+	cmds.close()
+	os.Exit(code)
+}
+
+// inputParser records whether the last input was Ctrl-D, which prompt.Input
+// does not distinguish from an empty line.
+type inputParser struct {
+	prompt.ConsoleParser
+	lastEOF int32
+}
+
+func (p *inputParser) Read() ([]byte, error) {
+	b, err := p.ConsoleParser.Read()
+	if err == nil && len(b) > 0 && !(len(b) == 1 && b[0] == 0) { // 0 means no input
+		var eof int32
+		if len(b) == 1 && b[0] == 0x4 {
+			eof = 1
+		}
+		atomic.StoreInt32(&p.lastEOF, eof)
+	}
+	return b, err
 }
 
-func completer(prompt.Document) []prompt.Suggest {
-	return []prompt.Suggest{}
+func (p *inputParser) eof() bool {
+	return atomic.LoadInt32(&p.lastEOF) == 1
 }
 
 // executor wraps the demo executor to print error messages.
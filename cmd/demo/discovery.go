@@ -0,0 +1,260 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"perun.network/go-perun/wire"
+
+	"github.com/perun-network/perun-polkadot-demo/cmd/relay"
+	pnode "github.com/perun-network/perun-polkadot-demo/pkg/node"
+)
+
+const (
+	defaultDiscoveryPort     = 5770
+	defaultDiscoveryInterval = 5 * time.Second
+	// discoveryMissed is the number of missed announcements after which a
+	// discovered peer is forgotten.
+	discoveryMissed = 3
+)
+
+type (
+	discoveryConfig struct {
+		Enabled bool
+		// Port is the UDP port on which announcements are sent and received.
+		Port uint16
+		// Interval is the interval in which the node announces itself.
+		Interval time.Duration
+	}
+
+	// announcement is broadcast by nodes with enabled discovery.
+	announcement struct {
+		Alias   string `json:"alias"`
+		PerunID string `json:"perunID"`
+		Port    uint16 `json:"port"`
+		// URL is the relay address of the node, if any.
+		URL string `json:"url,omitempty"`
+		// Fingerprint is the TLS certificate fingerprint of the node, if TLS
+		// is enabled. It is only shown and must be pinned by the user.
+		Fingerprint string `json:"fingerprint,omitempty"`
+	}
+
+	discoveredPeer struct {
+		*netConfigEntry
+		// fingerprint is the announced certificate fingerprint.
+		fingerprint string
+		lastSeen    time.Time
+	}
+)
+
+// discoveredPeers contains the peers found by the discovery, indexed by
+// alias. Protected by peersMtx.
+var discoveredPeers = make(map[string]*discoveredPeer)
+
+// startDiscovery starts announcing the node and listening for announcements of
+// other nodes in the local network.
+func (n *node) startDiscovery(cfg discoveryConfig) error {
+	if cfg.Port == 0 {
+		cfg.Port = defaultDiscoveryPort
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultDiscoveryInterval
+	}
+
+	// Multiple nodes on one host need to receive the announcements.
+	lc := net.ListenConfig{Control: reuseAddr}
+	pc, err := lc.ListenPacket(n.ctx, "udp4", ":"+strconv.Itoa(int(cfg.Port)))
+	if err != nil {
+		return errors.Wrap(err, "listening for announcements")
+	}
+	conn := pc.(*net.UDPConn)
+	go func() {
+		<-n.ctx.Done()
+		conn.Close() // nolint:errcheck,gosec
+	}()
+
+	ann := announcement{
+		Alias:   config.Alias,
+		PerunID: n.core.Address().String(),
+		Port:    config.Node.Port,
+	}
+	if config.Node.Relay != "" {
+		ann.URL = relay.URL(config.Node.Relay, ann.PerunID)
+	}
+	if n.tlsCert != nil {
+		ann.Fingerprint = fingerprint(n.tlsCert.Leaf)
+	}
+	data, err := json.Marshal(ann)
+	if err != nil {
+		return errors.Wrap(err, "encoding announcement")
+	}
+
+	go n.announce(conn, data, &net.UDPAddr{IP: net.IPv4bcast, Port: int(cfg.Port)}, cfg.Interval)
+	go n.receiveAnnouncements(conn)
+	n.log.WithField("port", cfg.Port).Info("Discovery started")
+	return nil
+}
+
+func (n *node) announce(conn *net.UDPConn, data []byte, addr *net.UDPAddr, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := conn.WriteToUDP(data, addr); err != nil {
+			n.log.WithError(err).Debug("Sending announcement")
+		}
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		n.expireDiscovered(time.Now().Add(-discoveryMissed * interval))
+	}
+}
+
+// expireDiscovered forgets the discovered peers that were last seen before
+// `deadline`, unless they are connected.
+func (n *node) expireDiscovered(deadline time.Time) {
+	peers := n.core.Peers()
+	peersMtx.Lock()
+	defer peersMtx.Unlock()
+	for alias, p := range discoveredPeers {
+		if !p.lastSeen.Before(deadline) || connected(peers, p.perunID) {
+			continue
+		}
+		delete(discoveredPeers, alias)
+		PrintfAsync("🔎 Lost %s.\n", alias)
+	}
+}
+
+func connected(peers []pnode.PeerInfo, id wire.Address) bool {
+	for _, p := range peers {
+		if p.PerunID.Equals(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *node) receiveAnnouncements(conn *net.UDPConn) {
+	buf := make([]byte, 1024)
+	for {
+		size, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-n.ctx.Done():
+				return
+			default:
+			}
+			n.log.WithError(err).Warn("Receiving announcement")
+			continue
+		}
+		var ann announcement
+		if err := json.Unmarshal(buf[:size], &ann); err != nil {
+			n.log.WithError(err).WithField("from", src).Debug("Invalid announcement")
+			continue
+		}
+		n.handleAnnouncement(ann, src.IP)
+	}
+}
+
+func (n *node) handleAnnouncement(ann announcement, ip net.IP) {
+	id, err := strToAddress(ann.PerunID)
+	if err != nil || id.Equals(n.core.Address()) || ann.Alias == "" {
+		return
+	}
+
+	peersMtx.Lock()
+	defer peersMtx.Unlock()
+	if p, ok := discoveredPeers[ann.Alias]; ok && p.perunID.Equals(id) {
+		// Announcements are not authenticated, so a known peer can not be
+		// moved to another host or certificate. It needs to expire first.
+		if p.Hostname != ip.String() || p.Port != ann.Port || p.URL != ann.URL ||
+			p.fingerprint != ann.Fingerprint {
+			n.log.WithField("alias", ann.Alias).WithField("from", ip).Warn("Ignoring changed announcement")
+			return
+		}
+		p.lastSeen = time.Now()
+		return
+	}
+	for alias, e := range config.Peers {
+		if e.perunID.Equals(id) || alias == ann.Alias {
+			// Configured peers take precedence.
+			return
+		}
+	}
+	if _, ok := discoveredPeers[ann.Alias]; ok {
+		n.log.WithField("alias", ann.Alias).Warn("Ignoring announcement with taken alias")
+		return
+	}
+
+	p := &discoveredPeer{
+		netConfigEntry: &netConfigEntry{
+			PerunID:  ann.PerunID,
+			perunID:  id,
+			Hostname: ip.String(),
+			Port:     ann.Port,
+			URL:      ann.URL,
+		},
+		fingerprint: ann.Fingerprint,
+		lastSeen:    time.Now(),
+	}
+	discoveredPeers[ann.Alias] = p
+	if ann.Fingerprint == "" {
+		PrintfAsync("🔎 Discovered %s at %s.\n", ann.Alias, p.address())
+		return
+	}
+	PrintfAsync("🔎 Discovered %s at %s with TLS fingerprint %s.\n"+
+		"Confirm it with %s and use 'peer pin %s <fingerprint>' to trust it.\n",
+		ann.Alias, p.address(), ann.Fingerprint, ann.Alias, ann.Alias)
+}
+
+// Discover prints the peers found in the local network.
+func (n *node) Discover([]string) error {
+	if !config.Node.Discovery.Enabled {
+		return errors.New("Discovery disabled, enable it with 'node.discovery.enabled' or --discovery")
+	}
+
+	peersMtx.RLock()
+	defer peersMtx.RUnlock()
+	if len(discoveredPeers) == 0 {
+		fmt.Fprintln(stdout, "No peers discovered yet.")
+		return nil
+	}
+	w := tabwriter.NewWriter(stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	fmt.Fprintf(w, "Alias\tPerun ID\tAddress\tFingerprint\tLast seen\n")
+	aliases := make([]string, 0, len(discoveredPeers))
+	for alias := range discoveredPeers {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		p := discoveredPeers[alias]
+		fp := p.fingerprint
+		if p.CertFingerprint != "" {
+			fp += " (pinned)"
+		}
+		fmt.Fprintf(w, "%s\t%v\t%s\t%s\t%s\n", alias, p.PerunID, p.address(), fp,
+			time.Since(p.lastSeen).Round(time.Second))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,254 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/pkg/errors"
+	"perun.network/go-perun/log"
+
+	pnode "github.com/perun-network/perun-polkadot-demo/pkg/node"
+)
+
+// Events of the history.
+const (
+	eventOpen     = "open"
+	eventSent     = "sent"
+	eventReceived = "received"
+	eventClose    = "close"
+	eventSettle   = "settle"
+)
+
+type (
+	// historyEntry is a single event of the channel activity. All amounts
+	// are in Plank and from the perspective of this node.
+	historyEntry struct {
+		Time        time.Time `json:"time"`
+		Event       string    `json:"event"`
+		Channel     string    `json:"channel"`
+		Version     uint64    `json:"version"`
+		Peer        string    `json:"peer"`
+		PeerID      string    `json:"peerID"`
+		Delta       *big.Int  `json:"delta"`
+		MyBalance   *big.Int  `json:"myBalance"`
+		PeerBalance *big.Int  `json:"peerBalance"`
+		Memo        string    `json:"memo,omitempty"`
+	}
+
+	// ledger is an append-only JSON lines file of history entries.
+	ledger struct {
+		mtx  sync.Mutex
+		path string
+		file *os.File
+	}
+
+	historyFilter struct {
+		peer  string    // Empty matches all peers.
+		since time.Time // Zero matches all times.
+	}
+)
+
+func newLedger(path string) (*ledger, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening history")
+	}
+	return &ledger{path: path, file: f}, nil
+}
+
+// record appends an entry to the ledger. It does nothing if the ledger is
+// nil, i.e. the history is disabled.
+func (l *ledger) record(e historyEntry) {
+	if l == nil {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.WithError(err).Error("Encoding history entry")
+		return
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		log.WithError(err).Error("Writing history entry")
+	}
+}
+
+// entries returns all entries that match the filter in the order in which they
+// were recorded.
+func (l *ledger) entries(filter historyFilter) ([]historyEntry, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening history")
+	}
+	defer f.Close() // nolint:errcheck
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var e historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, errors.Wrapf(err, "parsing history line %d", line)
+		}
+		if (filter.peer == "" || filter.peer == e.Peer) && !e.Time.Before(filter.since) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, errors.Wrap(scanner.Err(), "reading history")
+}
+
+func (l *ledger) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// newHistoryEntry creates an entry for the channel event `e`.
+func newHistoryEntry(event string, e pnode.Event, delta *big.Int) historyEntry {
+	return historyEntry{
+		Time:        e.Time,
+		Event:       event,
+		Channel:     fmt.Sprintf("0x%x", e.Channel),
+		Version:     e.Version,
+		Peer:        e.Peer,
+		PeerID:      e.PeerID.String(),
+		Delta:       delta,
+		MyBalance:   e.MyBalance,
+		PeerBalance: e.PeerBalance,
+	}
+}
+
+// parseSince parses a duration like 24h or a date like 2021-10-01 or
+// 2021-10-01T12:00:00Z.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("invalid time '%s', expected a duration like 24h or a date like 2006-01-02", s)
+}
+
+// History prints the recorded channel activity.
+func (n *node) History(args []string) error {
+	if n.history == nil {
+		return errors.New("History disabled, enable it with 'node.historyPath'")
+	}
+	filter := historyFilter{peer: args[0]}
+	if args[1] != "" {
+		var err error
+		if filter.since, err = parseSince(args[1]); err != nil {
+			return err
+		}
+	}
+	entries, err := n.history.entries(filter)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(stdout, "No history entries.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.Debug)
+	fmt.Fprintf(w, "Time\tPeer\tEvent\tVersion\tDelta\tMy D\tPeer D\tMemo\t\n")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%v\t%v\t%v\t%s\t\n",
+			e.Time.Format("2006-01-02 15:04:05"), e.Peer, e.Event, e.Version,
+			dot.NewDotFromPlank(e.Delta), dot.NewDotFromPlank(e.MyBalance), dot.NewDotFromPlank(e.PeerBalance), e.Memo)
+	}
+	return w.Flush()
+}
+
+// ExportHistory writes the whole history as CSV or JSON to stdout or the
+// given file.
+func (n *node) ExportHistory(args []string) error {
+	if n.history == nil {
+		return errors.New("History disabled, enable it with 'node.historyPath'")
+	}
+	entries, err := n.history.entries(historyFilter{})
+	if err != nil {
+		return err
+	}
+
+	out := io.Writer(stdout)
+	if len(args) > 1 && args[1] != "" {
+		f, err := os.Create(args[1])
+		if err != nil {
+			return errors.Wrap(err, "creating export file")
+		}
+		defer f.Close() // nolint:errcheck,gosec
+		out = f
+	}
+	if args[0] == "--csv" {
+		err = exportCSV(out, entries)
+	} else {
+		err = exportJSON(out, entries)
+	}
+	if err != nil {
+		return err
+	}
+	if out != stdout {
+		fmt.Fprintf(stdout, "📒 Exported %d history entries to %s.\n", len(entries), args[1])
+	}
+	return nil
+}
+
+func exportCSV(w io.Writer, entries []historyEntry) error {
+	cw := csv.NewWriter(w)
+	header := []string{"time", "event", "channel", "version", "peer", "peer_id", "delta_plank", "my_balance_plank", "peer_balance_plank", "memo"}
+	if err := cw.Write(header); err != nil {
+		return errors.Wrap(err, "writing csv")
+	}
+	for _, e := range entries {
+		record := []string{
+			e.Time.Format(time.RFC3339Nano), e.Event, e.Channel, strconv.FormatUint(e.Version, 10),
+			e.Peer, e.PeerID, e.Delta.String(), e.MyBalance.String(), e.PeerBalance.String(), e.Memo,
+		}
+		if err := cw.Write(record); err != nil {
+			return errors.Wrap(err, "writing csv")
+		}
+	}
+	cw.Flush()
+	return errors.Wrap(cw.Error(), "writing csv")
+}
+
+func exportJSON(w io.Writer, entries []historyEntry) error {
+	if entries == nil {
+		entries = []historyEntry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(entries), "writing json")
+}
@@ -0,0 +1,370 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+
+	pnode "github.com/perun-network/perun-polkadot-demo/pkg/node"
+)
+
+// Events that trigger hooks.
+const (
+	hookProposal  = "proposal_received"
+	hookOpened    = "channel_opened"
+	hookPayment   = "payment_received"
+	hookConcluded = "concluded"
+	hookSettled   = "settled"
+	hookError     = "error"
+)
+
+var hookEvents = []string{hookProposal, hookOpened, hookPayment, hookConcluded, hookSettled, hookError}
+
+const (
+	defaultHookRetries = 3
+	defaultHookTimeout = 10 * time.Second
+	// hookQueueSize is the number of events that wait for a hook. Further
+	// events are dropped.
+	hookQueueSize = 256
+	// maxHookBackoff is the longest wait between two webhook requests.
+	maxHookBackoff = 30 * time.Second
+)
+
+type (
+	// hookConfig configures a hook that executes a command or sends a
+	// webhook on events.
+	hookConfig struct {
+		// Events that trigger the hook, see hookEvents. Empty matches all.
+		Events []string
+		// MinAmount is the minimal amount in Dot of received payments that
+		// trigger the hook.
+		MinAmount float64
+		// Command is executed with the event as JSON on stdin. Either
+		// Command or URL must be set.
+		Command []string
+		// URL receives the event as JSON in a POST request.
+		URL string
+		// Secret signs the webhook requests with HMAC-SHA256. Optional.
+		Secret string
+		// Retries of a failed webhook request. Defaults to 3.
+		Retries int
+		// Timeout of the command or a single webhook request. Defaults to
+		// 10s.
+		Timeout time.Duration
+	}
+
+	// hookEvent is passed to the hooks. All amounts are in Plank and from
+	// the perspective of this node.
+	hookEvent struct {
+		// ID is unique per event, so that receivers can detect retries.
+		ID          string    `json:"id"`
+		Event       string    `json:"event"`
+		Time        time.Time `json:"time"`
+		Alias       string    `json:"alias"`
+		Peer        string    `json:"peer,omitempty"`
+		PeerID      string    `json:"peerID,omitempty"`
+		Channel     string    `json:"channel,omitempty"`
+		Version     uint64    `json:"version,omitempty"`
+		Amount      *big.Int  `json:"amount,omitempty"`
+		MyBalance   *big.Int  `json:"myBalance,omitempty"`
+		PeerBalance *big.Int  `json:"peerBalance,omitempty"`
+		Memo        string    `json:"memo,omitempty"`
+		Error       string    `json:"error,omitempty"`
+	}
+
+	// hooks deliver the events to the configured hooks. Every hook has its
+	// own queue, so that a slow hook does not delay the others and the
+	// events of a hook are delivered in order.
+	hooks struct {
+		hooks  []*hook
+		client *http.Client
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+
+		// Protects closed, events that are fired after closing are dropped.
+		mtx    sync.RWMutex
+		closed bool
+	}
+
+	hook struct {
+		hookConfig
+		events    map[string]bool // nil matches all.
+		minAmount *big.Int
+		queue     chan hookEvent
+	}
+)
+
+// newHooks starts the hooks. It returns nil if no hooks are configured.
+func newHooks(cfgs []hookConfig) (*hooks, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &hooks{client: new(http.Client), ctx: ctx, cancel: cancel}
+	for i, cfg := range cfgs {
+		hk, err := newHook(cfg)
+		if err != nil {
+			cancel()
+			return nil, errors.WithMessagef(err, "hook %d", i+1)
+		}
+		h.hooks = append(h.hooks, hk)
+	}
+	for _, hk := range h.hooks {
+		h.wg.Add(1)
+		go h.run(hk)
+	}
+	return h, nil
+}
+
+func newHook(cfg hookConfig) (*hook, error) {
+	if (len(cfg.Command) == 0) == (cfg.URL == "") {
+		return nil, errors.New("either command or url must be set")
+	}
+	if cfg.URL != "" {
+		if u, err := url.Parse(cfg.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, errors.Errorf("invalid url '%s', expected http:// or https://", cfg.URL)
+		}
+	}
+	if cfg.MinAmount < 0 {
+		return nil, errors.New("minAmount must not be negative")
+	}
+	if cfg.Retries <= 0 {
+		cfg.Retries = defaultHookRetries
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultHookTimeout
+	}
+	hk := &hook{
+		hookConfig: cfg,
+		minAmount:  dotToPlank(big.NewFloat(cfg.MinAmount))[0],
+		queue:      make(chan hookEvent, hookQueueSize),
+	}
+	for _, e := range cfg.Events {
+		if !isHookEvent(e) {
+			return nil, errors.Errorf("unknown event '%s', expected one of %s", e, strings.Join(hookEvents, ", "))
+		}
+		if hk.events == nil {
+			hk.events = make(map[string]bool)
+		}
+		hk.events[e] = true
+	}
+	return hk, nil
+}
+
+func isHookEvent(e string) bool {
+	for _, known := range hookEvents {
+		if e == known {
+			return true
+		}
+	}
+	return false
+}
+
+// newHookEvent returns the hook event of the node event `e`. Fields that do
+// not apply to `e` are omitted.
+func newHookEvent(event string, e pnode.Event) hookEvent {
+	he := hookEvent{
+		Event:       event,
+		Peer:        e.Peer,
+		Version:     e.Version,
+		Amount:      e.Amount,
+		MyBalance:   e.MyBalance,
+		PeerBalance: e.PeerBalance,
+		Memo:        e.Memo,
+	}
+	if e.PeerID != nil {
+		he.PeerID = e.PeerID.String()
+	}
+	if e.Channel != (channel.ID{}) {
+		he.Channel = fmt.Sprintf("0x%x", e.Channel)
+	}
+	return he
+}
+
+// fire passes the event to the hooks that match it. It does not block and
+// does nothing if `h` is nil, i.e. no hooks are configured.
+func (h *hooks) fire(e hookEvent) {
+	if h == nil {
+		return
+	}
+	e.ID, e.Time, e.Alias = newEventID(), time.Now(), config.Alias
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	if h.closed {
+		return
+	}
+	for _, hk := range h.hooks {
+		if !hk.matches(e) {
+			continue
+		}
+		select {
+		case hk.queue <- e:
+		default:
+			log.WithFields(log.Fields{"event": e.Event, "id": e.ID}).Warn("Hook queue full, dropping event")
+		}
+	}
+}
+
+// fireError fires an error event for a failed operation.
+func (h *hooks) fireError(peer string, err error) {
+	h.fire(hookEvent{Event: hookError, Peer: peer, Error: err.Error()})
+}
+
+func (hk *hook) matches(e hookEvent) bool {
+	if hk.events != nil && !hk.events[e.Event] {
+		return false
+	}
+	return e.Event != hookPayment || e.Amount.Cmp(hk.minAmount) >= 0
+}
+
+// close delivers the queued events and stops the hooks. Events that are not
+// delivered within `timeout` are dropped.
+func (h *hooks) close(timeout time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mtx.Lock()
+	h.closed = true
+	for _, hk := range h.hooks {
+		close(hk.queue)
+	}
+	h.mtx.Unlock()
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn("Stopping hooks with undelivered events")
+	}
+	h.cancel()
+}
+
+func (h *hooks) run(hk *hook) {
+	defer h.wg.Done()
+	for e := range hk.queue {
+		data, err := json.Marshal(e)
+		if err != nil {
+			log.WithError(err).Error("Encoding hook event")
+			continue
+		}
+		if len(hk.Command) > 0 {
+			err = h.execute(hk, e, data)
+		} else {
+			err = h.post(hk, e, data)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"event": e.Event, "id": e.ID}).WithError(err).Warn("Hook failed, dropping event")
+		}
+	}
+}
+
+// execute runs the command of the hook with the event on stdin.
+func (h *hooks) execute(hk *hook, e hookEvent, data []byte) error {
+	ctx, cancel := context.WithTimeout(h.ctx, hk.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, hk.Command[0], hk.Command[1:]...) // nolint:gosec
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(), "PERUN_EVENT="+e.Event, "PERUN_EVENT_ID="+e.ID)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "executing %s: %s", hk.Command[0], bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// post sends the event to the webhook. Failed requests are retried with an
+// exponential backoff, except if the webhook rejected the event with a 4xx
+// status.
+func (h *hooks) post(hk *hook, e hookEvent, data []byte) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := h.send(hk, e, data)
+		if err == nil || !retry || attempt == hk.Retries {
+			return err
+		}
+		log.WithField("event", e.Event).WithError(err).Debugf("Retrying webhook in %v", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-h.ctx.Done():
+			return errors.WithMessage(err, "stopped retrying")
+		}
+		if backoff *= 2; backoff > maxHookBackoff {
+			backoff = maxHookBackoff
+		}
+	}
+}
+
+// send sends a single webhook request and returns whether it can be retried
+// on failure.
+func (h *hooks) send(hk *hook, e hookEvent, data []byte) (retry bool, err error) {
+	ctx, cancel := context.WithTimeout(h.ctx, hk.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hk.URL, bytes.NewReader(data))
+	if err != nil {
+		return false, errors.Wrap(err, "creating webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "perun-polkadot-demo")
+	req.Header.Set("X-Perun-Event", e.Event)
+	req.Header.Set("X-Perun-Event-ID", e.ID)
+	if hk.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hk.Secret))
+		mac.Write(data) // nolint:errcheck,gosec
+		req.Header.Set("X-Perun-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return true, errors.Wrap(err, "sending webhook")
+	}
+	defer res.Body.Close()                               // nolint:errcheck
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16)) // nolint:errcheck,gosec
+	if res.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry = res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+	return retry, errors.Errorf("webhook responded %s", res.Status)
+}
+
+func newEventID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		log.WithError(err).Error("Generating event ID")
+	}
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"math/big"
+	"time"
+
+	pnode "github.com/perun-network/perun-polkadot-demo/pkg/node"
+)
+
+type (
+	// limitsConfig limits the payments of the node. The global limits apply to
+	// all peers together, the limits in Peers additionally to single peers.
+	// Zero values disable a limit. Amounts are in Dot.
+	limitsConfig struct {
+		paymentLimits `mapstructure:",squash"`
+		// Window is the time window of MaxPerWindow. Defaults to 1m.
+		Window time.Duration
+		// Peers contains the limits per peer alias.
+		Peers map[string]paymentLimits
+	}
+
+	paymentLimits struct {
+		// MaxPayment is the maximal amount of a single outgoing payment.
+		MaxPayment float64
+		// MaxPerWindow is the maximal amount of outgoing payments per Window.
+		MaxPerWindow float64
+		// MaxUpdateRate is the maximal number of updates per second that a
+		// peer may send. The global value applies to every peer.
+		MaxUpdateRate float64
+		// MinBalance is the own balance that must remain in a channel.
+		MinBalance float64
+	}
+)
+
+// plank returns the limits of the core node, which are in Plank.
+func (c limitsConfig) plank() pnode.Limits {
+	limits := pnode.Limits{
+		PaymentLimits: c.paymentLimits.plank(),
+		Window:        c.Window,
+		Peers:         make(map[string]pnode.PaymentLimits, len(c.Peers)),
+	}
+	for alias, l := range c.Peers {
+		limits.Peers[alias] = l.plank()
+	}
+	return limits
+}
+
+func (l paymentLimits) plank() pnode.PaymentLimits {
+	return pnode.PaymentLimits{
+		MaxPayment:    dotLimitToPlank(l.MaxPayment),
+		MaxPerWindow:  dotLimitToPlank(l.MaxPerWindow),
+		MaxUpdateRate: l.MaxUpdateRate,
+		MinBalance:    dotLimitToPlank(l.MinBalance),
+	}
+}
+
+// dotLimitToPlank converts a limit in Dot to Plank. Zero disables the limit
+// and is returned as nil.
+func dotLimitToPlank(limit float64) *big.Int {
+	if limit <= 0 {
+		return nil
+	}
+	return dotToPlank(big.NewFloat(limit))[0]
+}
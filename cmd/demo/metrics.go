@@ -0,0 +1,94 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"math/big"
+	"net"
+	"net/http"
+
+	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	pnode "github.com/perun-network/perun-polkadot-demo/pkg/node"
+)
+
+const metricsNamespace = "perun_demo"
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+	// nodeMetrics are recorded by the core node if metrics are enabled.
+	nodeMetrics = pnode.NewMetrics(metricsNamespace)
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		nodeMetrics,
+	)
+}
+
+// registerNodeMetrics registers the metrics that are read from the node's
+// state on every scrape.
+func (n *node) registerNodeMetrics() {
+	metricsRegistry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "open_channels",
+			Help:      "Number of open channels.",
+		}, func() float64 {
+			return float64(len(n.core.Channels()))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "locked_funds",
+			Help:        "Own funds locked in open channels in Dot.",
+			ConstLabels: prometheus.Labels{"asset": "DOT"},
+		}, func() float64 {
+			sum := new(big.Int)
+			for _, ch := range n.core.Channels() {
+				sum.Add(sum, ch.MyBalance)
+			}
+			f, _ := new(big.Float).Quo(new(big.Float).SetInt(sum), big.NewFloat(dot.PlankPerDot)).Float64()
+			return f
+		}),
+	)
+}
+
+// serveMetrics serves the metrics on `addr` under /metrics.
+func (n *node) serveMetrics(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "listening for metrics")
+	}
+	n.registerNodeMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: config.Node.HandleTimeout}
+	go func() {
+		<-n.ctx.Done()
+		srv.Close() // nolint:errcheck,gosec
+	}()
+	go func() {
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			n.log.WithError(err).Error("Metrics server stopped")
+		}
+	}()
+	n.log.WithField("addr", l.Addr()).Info("Serving metrics")
+	return nil
+}
@@ -16,400 +16,394 @@ package demo
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"math/big"
-	"os"
-	"strconv"
+	"strings"
 	"sync"
 	"text/tabwriter"
 	"time"
 
 	"github.com/pkg/errors"
 
-	dotchannel "github.com/perun-network/perun-polkadot-backend/channel"
 	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
-	dotwallet "github.com/perun-network/perun-polkadot-backend/wallet/sr25519"
 	"perun.network/go-perun/channel"
 	"perun.network/go-perun/client"
 	"perun.network/go-perun/log"
-	"perun.network/go-perun/wallet"
 	"perun.network/go-perun/wire"
-	wirenet "perun.network/go-perun/wire/net"
-	"perun.network/go-perun/wire/net/simple"
-)
 
-type peer struct {
-	alias   string
-	perunID wire.Address
-	ch      *paymentChannel
-	log     log.Logger
-}
+	pnode "github.com/perun-network/perun-polkadot-demo/pkg/node"
+)
 
 type node struct {
 	log log.Logger
-
-	bus    *wirenet.Bus
-	client *client.Client
-	dialer *simple.Dialer
-	api    *dot.API
-
-	// Account for signing on-chain TX. Currently also the Perun-ID.
-	onChain *dotwallet.Account
-	// Account for signing off-chain TX. Currently one Account for all
-	// state channels, later one we want one Account per Channel.
-	offChain wallet.Account
-	wallet   *dotwallet.Wallet
-
-	adjudicator channel.Adjudicator
-	funder      channel.Funder
-
-	// Protects peers
-	mtx   sync.Mutex
-	peers map[string]*peer
-}
-
-func (n *node) getOnChainBal(ctx context.Context, addrs ...wallet.Address) ([]*big.Int, error) {
-	bals := make([]*big.Int, len(addrs))
-	for i, addr := range addrs {
-		accInfo, err := n.api.AccountInfo(dotwallet.AsAddr(addr).AccountID())
-		if err != nil {
-			return nil, errors.Wrap(err, "querying on-chain balance")
-		}
-		bals[i] = accInfo.Free.Int
-	}
-	return bals, nil
+	// ctx is cancelled when the node exits.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// core is the Perun node that manages the peers and channels.
+	core   *pnode.Node
+	dialer dialer
+	// Certificate for TLS connections, nil if TLS is disabled.
+	tlsCert *tls.Certificate
+	// history records the channel activity, nil if disabled.
+	history *ledger
+	// pingPong tracks the ping-pong benchmarks.
+	pingPong *pingPongs
+	// autoAccept are the peers whose on-chain benchmark is accepted.
+	autoAccept *autoAccepts
+	// hooks are nil if none are configured.
+	hooks *hooks
+	// webToken authorizes the requests of the web UI, empty if it is
+	// disabled.
+	webToken string
+	// webBalance is the on-chain balance shown by the web UI, nil if it is
+	// disabled.
+	webBalance *webBalance
+
+	// Protects closing
+	mtx sync.Mutex
+	// closing are the peers whose channel is closed by a command, which
+	// reports the settlement itself.
+	closing map[string]bool
+
+	shutdownOnce sync.Once
+	shutdownErr  error
 }
 
 func (n *node) Connect(args []string) error {
-	n.mtx.Lock()
-	defer n.mtx.Unlock()
 	return n.connect(args[0])
 }
 
 func (n *node) connect(alias string) error {
-	n.log.Traceln("Connecting...")
-	if n.peers[alias] != nil {
-		return errors.New("Peer already connected")
-	}
-	peerCfg, ok := config.Peers[alias]
-	if !ok {
-		return errors.Errorf("Alias '%s' unknown. Add it to 'network.yaml'.", alias)
-	}
-
-	n.dialer.Register(peerCfg.perunID, peerCfg.Hostname+":"+strconv.Itoa(int(peerCfg.Port)))
-
-	n.peers[alias] = &peer{
-		alias:   alias,
-		perunID: peerCfg.perunID,
-		log:     log.WithField("peer", peerCfg.perunID),
-	}
-
-	fmt.Printf("📡 Connected to %v. Ready to open channel.\n", alias)
-
-	return nil
-}
-
-// peer returns the peer with the address `addr` or nil if not found.
-func (n *node) peer(addr wire.Address) *peer {
-	for _, peer := range n.peers {
-		if peer.perunID.Equals(addr) {
-			return peer
-		}
+	p, err := n.core.Connect(n.ctx, alias)
+	if err != nil {
+		return err
 	}
+	fmt.Fprintf(stdout, "📡 Connected to %v (%v). Ready to open channel.\n", alias, p.RTT.Round(time.Microsecond))
 	return nil
 }
 
-func (n *node) channelPeer(ch *client.Channel) *peer {
-	perunID := ch.Peers()[1-ch.Idx()] // assumes two-party channel
-	return n.peer(perunID)
+// hasChannel returns whether a channel with the peer `alias` is open.
+func (n *node) hasChannel(alias string) bool {
+	p, ok := n.core.Peer(alias)
+	return ok && p.Channel != nil
 }
 
-func (n *node) setupChannel(ch *client.Channel) {
-	if len(ch.Peers()) != 2 {
-		log.Fatal("Only channels with two participants are currently supported")
+// channelAliases returns the aliases of the peers with an open channel sorted
+// by alias.
+func (n *node) channelAliases() []string {
+	var aliases []string
+	for _, ch := range n.core.Channels() {
+		aliases = append(aliases, ch.Peer)
 	}
+	return aliases
+}
 
-	perunID := ch.Peers()[1-ch.Idx()] // assumes two-party channel
-	p := n.peer(perunID)
-
-	if p == nil {
-		log.WithField("peer", perunID).Warn("Opened channel to unknown peer")
-		return
-	} else if p.ch != nil {
-		p.log.Warn("Peer tried to open more than one channel")
-		return
+// peerByID returns the connected peer with the Perun ID `id`.
+func (n *node) peerByID(id wire.Address) (pnode.PeerInfo, bool) {
+	for _, p := range n.core.Peers() {
+		if p.PerunID.Equals(id) {
+			return p, true
+		}
 	}
-
-	p.ch = newPaymentChannel(ch)
-
-	// Start watching.
-	go func() {
-		l := log.WithField("channel", ch.ID())
-		l.Debug("Watcher started")
-		err := ch.Watch(n)
-		l.WithError(err).Debug("Watcher stopped")
-	}()
-
-	bals := dot.NewDotsFromPlanks(ch.State().Balances[0]...)
-	fmt.Printf("🆕 Channel established with %s. Initial balance: [My: %v, Peer: %v]\n",
-		p.alias, bals[ch.Idx()], bals[1-ch.Idx()]) // assumes two-party channel
+	return pnode.PeerInfo{}, false
 }
 
-func (n *node) HandleAdjudicatorEvent(e channel.AdjudicatorEvent) {
-	if _, ok := e.(*channel.ConcludedEvent); ok {
+// handleEvent reports the events of the core node on the command line, in the
+// history and to the hooks. It must not block.
+func (n *node) handleEvent(e pnode.Event) {
+	// Funding and settling change the on-chain balance.
+	n.webBalance.refresh()
+	switch e.Type {
+	case pnode.EventProposalReceived:
+		n.hooks.fire(newHookEvent(hookProposal, e))
+		n.handleProposal(e.Proposal)
+	case pnode.EventProposalExpired:
+		PrintfAsync("⌛ Channel proposal from %s expired.\n", e.Peer)
+	case pnode.EventChannelOpened:
+		n.history.record(newHistoryEntry(eventOpen, e, new(big.Int)))
+		n.hooks.fire(newHookEvent(hookOpened, e))
+		fmt.Fprintf(stdout, "🆕 Channel established with %s. Initial balance: [My: %v, Peer: %v]\n",
+			e.Peer, dot.NewDotFromPlank(e.MyBalance), dot.NewDotFromPlank(e.PeerBalance))
+	case pnode.EventPaymentSent:
+		entry := newHistoryEntry(eventSent, e, new(big.Int).Neg(e.Amount))
+		entry.Memo = e.Memo
+		n.history.record(entry)
+		fmt.Fprintf(stdout, "💰 Sent payment%s. New balance: [My: %v, Peer: %v]\n",
+			quoteMemo(e.Memo), dot.NewDotFromPlank(e.MyBalance), dot.NewDotFromPlank(e.PeerBalance))
+	case pnode.EventPaymentReceived:
+		entry := newHistoryEntry(eventReceived, e, e.Amount)
+		entry.Memo = e.Memo
+		n.history.record(entry)
+		if e.Amount.Sign() > 0 {
+			n.hooks.fire(newHookEvent(hookPayment, e))
+		}
+		if e.Amount.Sign() != 0 {
+			PrintfAsync("💰 Received payment%s. New balance: [My: %v, Peer: %v]\n",
+				quoteMemo(e.Memo), dot.NewDotFromPlank(e.MyBalance), dot.NewDotFromPlank(e.PeerBalance))
+		}
+		n.pingPongReceived(e)
+	case pnode.EventChannelFinalized:
+		amount := e.Amount
+		if amount == nil {
+			amount = new(big.Int)
+		}
+		n.history.record(newHistoryEntry(eventClose, e, amount))
+	case pnode.EventChannelConcluded:
 		PrintfAsync("🎭 Received concluded event\n")
-		func() {
-			n.mtx.Lock()
-			defer n.mtx.Unlock()
-			ch := n.channel(e.ID())
-			if ch == nil {
-				// If we initiated the channel closing, then the channel should
-				// already be removed and we return.
-				return
-			}
-			peer := n.channelPeer(ch.Channel)
-			if err := n.settle(peer); err != nil {
-				PrintfAsync("🎭 error while settling: %v\n", err)
-			}
-			PrintfAsync("🏁 Settled channel with %s.\n", peer.alias)
-		}()
+		n.hooks.fire(newHookEvent(hookConcluded, e))
+	case pnode.EventChannelSettled:
+		n.history.record(newHistoryEntry(eventSettle, e, new(big.Int)))
+		n.hooks.fire(newHookEvent(hookSettled, e))
+		if !n.isClosing(e.Peer) {
+			PrintfAsync("🏁 Settled channel with %s.\n", e.Peer)
+		}
+	case pnode.EventPeerOffline:
+		PrintfAsync("📴 %s went offline.\n", e.Peer)
+	case pnode.EventPeerOnline:
+		PrintfAsync("📶 %s is online again (%v).\n", e.Peer, e.RTT.Round(time.Microsecond))
+	case pnode.EventError:
+		n.hooks.fireError(e.Peer, e.Err)
+		PrintfAsync("⚠️  %v\n", e.Err)
 	}
 }
 
-type balTuple struct {
-	My, Other *big.Int
+// quoteMemo formats a memo for the payment messages.
+func quoteMemo(memo string) string {
+	if memo == "" {
+		return ""
+	}
+	return fmt.Sprintf(" %q", memo)
 }
 
-func (n *node) GetBals() map[string]balTuple {
-	n.mtx.Lock()
-	defer n.mtx.Unlock()
+type (
+	// peerState is a snapshot of a peer and its channel.
+	peerState struct {
+		Alias  string `json:"alias"`
+		Status string `json:"status"`
+		Online bool   `json:"online"`
+		// Channel is nil if no channel is open.
+		Channel *channelState `json:"channel,omitempty"`
+	}
+
+	channelState struct {
+		ID          string `json:"id"`
+		Phase       string `json:"phase"`
+		Version     uint64 `json:"version"`
+		MyBalance   string `json:"myBalance"`
+		PeerBalance string `json:"peerBalance"`
+	}
+)
 
-	bals := make(map[string]balTuple)
-	for alias, peer := range n.peers {
-		if peer.ch != nil {
-			my, other := peer.ch.GetBalances()
-			bals[alias] = balTuple{my, other}
+// peerStates returns a snapshot of the peers sorted by alias.
+func (n *node) peerStates() []peerState {
+	peers := n.core.Peers()
+	states := make([]peerState, len(peers))
+	for i, p := range peers {
+		states[i] = peerState{Alias: p.Alias, Status: status(p), Online: p.Online}
+		if ch := p.Channel; ch != nil {
+			states[i].Channel = &channelState{
+				ID:          fmt.Sprintf("0x%x", ch.ID),
+				Phase:       ch.Phase.String(),
+				Version:     ch.Version,
+				MyBalance:   dot.NewDotFromPlank(ch.MyBalance).String(),
+				PeerBalance: dot.NewDotFromPlank(ch.PeerBalance).String(),
+			}
 		}
 	}
-	return bals
+	return states
 }
 
-func findConfig(id wallet.Address) (string, *netConfigEntry) {
-	for alias, e := range config.Peers {
-		if e.perunID.String() == id.String() {
-			return alias, e
+// status returns a human readable description of the peer's liveness.
+func status(p pnode.PeerInfo) string {
+	if !p.Online {
+		if p.LastSeen.IsZero() {
+			return "Offline"
 		}
+		return "Offline, last seen " + p.LastSeen.Format(time.Kitchen)
 	}
-	return "", nil
+	return "Online " + p.RTT.Round(time.Microsecond).String()
 }
 
-func (n *node) HandleUpdate(old *channel.State, update client.ChannelUpdate, resp *client.UpdateResponder) {
-	n.mtx.Lock()
-	defer n.mtx.Unlock()
-	log := n.log.WithField("channel", update.State.ID)
-	log.Debug("Channel update")
-
-	ch := n.channel(update.State.ID)
-	if ch == nil {
-		log.Error("Channel for ID not found")
+// handleProposal asks the user to accept or reject a channel proposal. The
+// on-chain benchmark proposals that the user agreed to with 'benchmark
+// onchain accept' are accepted without asking.
+func (n *node) handleProposal(p *pnode.Proposal) {
+	myBal, peerBal := dot.NewDotFromPlank(p.MyBalance), dot.NewDotFromPlank(p.PeerBalance)
+	msg := fmt.Sprintf("🔁 Incoming channel proposal from %v with funding [My: %v, Peer: %v].\nAccept (y/n)? ", p.Peer, myBal, peerBal)
+	if p.Unknown {
+		msg = fmt.Sprintf("🔁 Incoming channel proposal from unknown identity %v with funding [My: %v, Peer: %v].\nAccept (y/n)? ", p.PeerID, myBal, peerBal)
+	}
+	if n.autoAccept.take(*p) {
+		PrintfAsync("🤖 Accepting channel proposal from %s for the on-chain benchmark.\n", p.Peer)
+		go n.acceptOnChainBench(*p)
 		return
 	}
-	ch.Handle(old, update, resp)
+	id := p.ID
+	Prompt(msg, func(userInput string) bool {
+		return n.decide(id, userInput == "y")
+	})
 }
 
-func (n *node) channel(id channel.ID) *paymentChannel {
-	for _, p := range n.peers {
-		if p.ch != nil && p.ch.ID() == id {
-			return p.ch
+// pendingProposal returns the pending proposal with `id`.
+func (n *node) pendingProposal(id uint64) (pnode.Proposal, bool) {
+	for _, p := range n.core.Proposals() {
+		if p.ID == id {
+			return p, true
 		}
 	}
-	return nil
+	return pnode.Proposal{}, false
 }
 
-func (n *node) HandleProposal(prop client.ChannelProposal, res *client.ProposalResponder) {
-	req, ok := prop.(*client.LedgerChannelProposal)
+// decide accepts or rejects the pending proposal with `id`. Accepting waits
+// until the channel is funded. It returns false if the proposal was already
+// decided.
+func (n *node) decide(id uint64, accept bool) bool {
+	p, ok := n.pendingProposal(id)
 	if !ok {
-		log.Fatal("Can handle only ledger channel proposals.")
+		return false
 	}
-
-	if len(req.Peers) != 2 {
-		log.Fatal("Only channels with two participants are currently supported")
+	if !accept {
+		fmt.Fprintf(stdout, "❌ Channel proposal rejected\n")
+		err := n.core.Reject(n.ctx, id, "rejected by user")
+		if errors.Cause(err) == pnode.ErrUnknownProposal {
+			return false
+		} else if err != nil {
+			n.log.Error(err)
+		}
+		return true
 	}
 
-	n.mtx.Lock()
-	defer n.mtx.Unlock()
-	id := req.Peers[0]
-	n.log.Debug("Received channel proposal")
-
-	// Find the peer by its perunID and create it if not present
-	p := n.peer(id)
-	alias, cfg := findConfig(id)
-
-	if p == nil {
-		if cfg == nil {
-			ctx, cancel := context.WithTimeout(context.Background(), config.Node.HandleTimeout)
-			defer cancel()
-			if err := res.Reject(ctx, "Unknown identity"); err != nil {
-				n.log.WithError(err).Warn("rejecting")
-			}
-			return
-		}
-		p = &peer{
-			alias:   alias,
-			perunID: id,
-			log:     log.WithField("peer", id),
-		}
-		n.peers[alias] = p
-		n.log.WithField("channel", id).WithField("alias", alias).Debug("New peer")
+	if p.Unknown {
+		fmt.Fprintf(stdout, "👤 Added unknown identity as %s. Use 'peer add' to save it.\n", p.Peer)
 	}
-	n.log.WithField("peer", id).Debug("Channel proposal")
-
-	bals := dot.NewDotsFromPlanks(req.InitBals.Balances[0]...)
-	theirBal := bals[0] // proposer has index 0
-	ourBal := bals[1]   // proposal receiver has index 1
-	msg := fmt.Sprintf("🔁 Incoming channel proposal from %v with funding [My: %v, Peer: %v].\nAccept (y/n)? ", alias, ourBal, theirBal)
-	Prompt(msg, func(userInput string) {
-		ctx, cancel := context.WithTimeout(context.Background(), config.Node.HandleTimeout)
-		defer cancel()
-
-		if userInput == "y" {
-			fmt.Printf("✅ Channel proposal accepted. Opening channel...\n")
-			a := req.Accept(n.offChain.Address(), client.WithRandomNonce())
-			if _, err := res.Accept(ctx, a); err != nil {
-				n.log.Error(errors.WithMessage(err, "accepting channel proposal"))
-				return
-			}
-		} else {
-			fmt.Printf("❌ Channel proposal rejected\n")
-			if err := res.Reject(ctx, "rejected by user"); err != nil {
-				n.log.Error(errors.WithMessage(err, "rejecting channel proposal"))
-				return
-			}
-		}
-	})
+	fmt.Fprintf(stdout, "✅ Channel proposal accepted. Opening channel...\n")
+	_, err := n.core.Accept(n.ctx, id)
+	if errors.Cause(err) == pnode.ErrUnknownProposal {
+		return false
+	} else if err != nil {
+		n.log.Error(err)
+		n.hooks.fireError(p.Peer, err)
+	}
+	return true
 }
 
 func (n *node) Open(args []string) error {
-	n.mtx.Lock()
-	defer n.mtx.Unlock()
-	peerName := args[0]
-	peer := n.peers[peerName]
-	if peer == nil {
-		// try to connect to peer
-		if err := n.connect(peerName); err != nil {
-			return err
-		}
-		peer = n.peers[peerName]
-	}
 	myBalDot, _ := new(big.Float).SetString(args[1]) // Input was already validated by command parser.
 	peerBalDot, _ := new(big.Float).SetString(args[2])
-
-	initBals := &channel.Allocation{
-		Assets:   []channel.Asset{dotchannel.Asset},
-		Balances: [][]*big.Int{dotToPlank(myBalDot, peerBalDot)},
+	var opts pnode.OpenOptions
+	if args[3] != "" {
+		opts.ChallengeDuration, _ = time.ParseDuration(args[3])
 	}
-
-	prop, err := client.NewLedgerChannelProposal(
-		config.Channel.ChallengeDurationSec,
-		n.offChain.Address(),
-		initBals,
-		[]wire.Address{n.onChain.Address(), peer.perunID},
-		client.WithRandomNonce(),
-	)
-	if err != nil {
-		return errors.WithMessage(err, "creating channel proposal")
+	opts.Nonce, _ = parseNonce(args[4])
+	timeout := config.Channel.FundTimeout
+	if args[5] != "" {
+		timeout, _ = time.ParseDuration(args[5])
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return n.openChannel(ctx, args[0], dotToPlank(myBalDot, peerBalDot), opts)
+}
 
-	fmt.Printf("💭 Proposing channel to %v...\n", peerName)
+// randomNonce is the default of the nonce flag of 'open'.
+const randomNonce = "random"
 
-	ctx, cancel := context.WithTimeout(context.Background(), config.Channel.FundTimeout)
-	defer cancel()
-	n.log.Debug("Proposing channel")
-	ch, err := n.client.ProposeChannel(ctx, prop)
-	if err != nil {
-		return errors.WithMessage(err, "proposing channel failed")
+// parseNonce parses "random" or a hex encoded nonce share. It returns nil for
+// "random".
+func parseNonce(arg string) (*client.NonceShare, error) {
+	if arg == randomNonce {
+		return nil, nil
 	}
-	if n.channel(ch.ID()) == nil {
-		return errors.New("OnNewChannel handler could not setup channel")
+	var share client.NonceShare
+	b, err := hex.DecodeString(strings.TrimPrefix(arg, "0x"))
+	if err != nil || len(b) != len(share) {
+		return nil, errors.Errorf("Expected '%s' or %d hex encoded bytes", randomNonce, len(share))
 	}
-	return nil
+	copy(share[:], b)
+	return &share, nil
 }
 
-func (n *node) Send(args []string) error {
-	n.mtx.Lock()
-	defer n.mtx.Unlock()
-	n.log.Traceln("Sending...")
-
-	peer := n.peers[args[0]]
-	if peer == nil {
-		return errors.Errorf("peer not found %s", args[0])
-	} else if peer.ch == nil {
-		return errors.Errorf("connect to peer first")
+// openChannel proposes a channel with the balances `bals` to the peer with
+// `alias` and waits until it is funded. The peer is connected first if
+// necessary.
+func (n *node) openChannel(ctx context.Context, alias string, bals []*big.Int, opts pnode.OpenOptions) error {
+	if _, ok := n.core.Peer(alias); !ok {
+		if err := n.connect(alias); err != nil {
+			return err
+		}
 	}
+	fmt.Fprintf(stdout, "💭 Proposing channel to %v...\n", alias)
+	_, err := n.core.Open(ctx, alias, bals[0], bals[1], opts)
+	return err
+}
+
+func (n *node) Send(args []string) error {
 	amountDot, _ := new(big.Float).SetString(args[1]) // Input was already validated by command parser.
-	return peer.ch.sendMoney(dotToPlank(amountDot)[0])
+	memo := strings.Join(args[2:], " ")
+	_, err := n.core.Send(context.Background(), args[0], dotToPlank(amountDot)[0], memo)
+	return err
 }
 
 func (n *node) Close(args []string) error {
-	n.mtx.Lock()
-	defer n.mtx.Unlock()
-	n.log.Traceln("Closing...")
-
-	alias := args[0]
-	peer := n.peers[alias]
-	if peer == nil {
-		return errors.Errorf("Unknown peer: %s", alias)
-	}
-	if err := peer.ch.sendFinal(); err != nil {
-		return errors.WithMessage(err, "sending final state for state closing")
+	ctx, cancel := context.WithCancel(context.Background())
+	if args[1] != "" {
+		timeout, _ := time.ParseDuration(args[1]) // Input was already validated by command parser.
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
 	}
+	defer cancel()
+	return n.closeChannel(ctx, args[0])
+}
 
-	if err := n.settle(peer); err != nil {
-		return errors.WithMessage(err, "settling")
+// closeChannel finalizes and settles the channel with the peer with `alias`.
+func (n *node) closeChannel(ctx context.Context, alias string) error {
+	n.setClosing(alias, true)
+	defer n.setClosing(alias, false)
+	if _, err := n.core.CloseChannel(ctx, alias); err != nil {
+		n.hooks.fireError(alias, err)
+		return err
 	}
-	fmt.Printf("\r🏁 Settled channel with %s.\n", peer.alias)
+	fmt.Fprintf(stdout, "\r🏁 Settled channel with %s.\n", alias)
 	return nil
 }
 
-func (n *node) settle(p *peer) error {
-	p.ch.log.Debug("Settling")
-	ctx, cancel := context.WithTimeout(context.Background(), config.Channel.SettleTimeout)
-	defer cancel()
-
-	if err := p.ch.Settle(ctx, false); err != nil {
-		return errors.WithMessage(err, "settling the channel")
+func (n *node) setClosing(alias string, closing bool) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if closing {
+		n.closing[alias] = true
+	} else {
+		delete(n.closing, alias)
 	}
+}
 
-	if err := p.ch.Close(); err != nil {
-		return errors.WithMessage(err, "channel closing")
-	}
-	p.ch.log.Debug("Removing channel")
-	p.ch = nil
-	return nil
+func (n *node) isClosing(alias string) bool {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.closing[alias]
 }
 
 // Info prints the phase of all channels.
 func (n *node) Info(args []string) error {
-	n.mtx.Lock()
-	defer n.mtx.Unlock()
 	n.log.Traceln("Info...")
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Chain.TxTimeoutSec)*time.Second)
 	defer cancel()
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.Debug)
-	fmt.Fprintf(w, "Peer\tPhase\tVersion\tMy D\tPeer D\tMy On-Chain D\tPeer On-Chain D\t\n")
-	for alias, peer := range n.peers {
-		onChainBals, err := n.getOnChainBal(ctx, n.onChain.Address(), peer.perunID)
+	w := tabwriter.NewWriter(stdout, 0, 0, 3, ' ', tabwriter.Debug)
+	fmt.Fprintf(w, "Peer\tStatus\tPhase\tVersion\tMy D\tPeer D\tMy On-Chain D\tPeer On-Chain D\t\n")
+	for _, p := range n.core.Peers() {
+		onChainBals, err := n.core.OnChainBalance(ctx, n.core.Address(), p.PerunID)
 		if err != nil {
 			return err
 		}
 		onChainBalsDot := dot.NewDotsFromPlanks(onChainBals...)
-		if peer.ch == nil {
-			fmt.Fprintf(w, "%s\t%s\t \t \t \t%v\t%v\t\n", alias, "Connected", onChainBalsDot[0], onChainBalsDot[1])
+		if ch := p.Channel; ch == nil {
+			fmt.Fprintf(w, "%s\t%s\t%s\t \t \t \t%v\t%v\t\n", p.Alias, status(p), "Connected", onChainBalsDot[0], onChainBalsDot[1])
 		} else {
-			bals := dot.NewDotsFromPlanks(peer.ch.GetBalances())
-			fmt.Fprintf(w, "%s\t%v\t%v\t%v\t%v\t%v\t%v\t\n",
-				alias, peer.ch.Phase(), peer.ch.State().Version, bals[0], bals[1], onChainBalsDot[0], onChainBalsDot[1])
+			bals := dot.NewDotsFromPlanks(ch.MyBalance, ch.PeerBalance)
+			fmt.Fprintf(w, "%s\t%s\t%v\t%v\t%v\t%v\t%v\t%v\t\n",
+				p.Alias, status(p), ch.Phase, ch.Version, bals[0], bals[1], onChainBalsDot[0], onChainBalsDot[1])
 		}
 	}
 	fmt.Fprintln(w)
@@ -418,17 +412,13 @@ func (n *node) Info(args []string) error {
 	return nil
 }
 
-func (n *node) Exit([]string) error {
-	n.mtx.Lock()
-	defer n.mtx.Unlock()
-	n.log.Traceln("Exiting...")
-
-	return n.client.Close()
-}
-
 func (n *node) ExistsPeer(alias string) bool {
-	n.mtx.Lock()
-	defer n.mtx.Unlock()
+	_, ok := n.core.Peer(alias)
+	return ok
+}
 
-	return n.peers[alias] != nil
+// channelByID returns the alias and the Perun ID of the peer of the channel
+// `id` and its collision count. It may be called from message handlers.
+func (n *node) channelByID(id channel.ID) (alias string, peerID wire.Address, collisions uint64, ok bool) {
+	return n.core.ChannelPeer(id)
 }
@@ -16,22 +16,18 @@ package demo
 
 import (
 	"context"
-	"crypto/rand"
+	"crypto/tls"
 	"fmt"
-	"os"
 	"strconv"
-	"text/tabwriter"
+	"time"
 
+	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
 	"github.com/pkg/errors"
-
-	sr25519 "github.com/perun-network/perun-polkadot-backend/pkg/sr25519"
-	dotwallet "github.com/perun-network/perun-polkadot-backend/wallet/sr25519"
-	"perun.network/go-perun/channel/persistence/keyvalue"
-	"perun.network/go-perun/client"
 	"perun.network/go-perun/log"
-	"perun.network/go-perun/pkg/sortedkv/leveldb"
 	wirenet "perun.network/go-perun/wire/net"
-	"perun.network/go-perun/wire/net/simple"
+
+	"github.com/perun-network/perun-polkadot-demo/cmd/relay"
+	pnode "github.com/perun-network/perun-polkadot-demo/pkg/node"
 )
 
 var backend *node
@@ -39,8 +35,6 @@ var backend *node
 // Setup initializes the node, can not be done in init() since it needs the
 // configuration from viper.
 func Setup() {
-	SetConfig(flags.cfgFile, flags.cfgNetFile)
-
 	var err error
 	if backend, err = newNode(); err != nil {
 		log.WithError(err).Fatalln("Could not initialize node.")
@@ -48,104 +42,167 @@ func Setup() {
 }
 
 func newNode() (*node, error) {
-	wallet, acc, err := setupWallet(config.Sk)
-	if err != nil {
-		return nil, errors.WithMessage(err, "importing mnemonic")
+	if err := validateTransport(config.Node.Transport); err != nil {
+		return nil, err
+	}
+	if err := validateShutdown(config.Node.Shutdown); err != nil {
+		return nil, err
+	}
+	if transport() == transportWS {
+		for alias, peer := range config.Peers {
+			if err := checkWSURL(peer.URL, config.Node.TLS.enabled(), peer.CertFingerprint); err != nil {
+				return nil, errors.WithMessagef(err, "peer %s", alias)
+			}
+		}
 	}
-	dot, err := newDotSetup(acc, config.Chain)
+	for alias, peer := range config.Peers {
+		if err := checkRelayPeer(peer.URL, peer.CertFingerprint, true); err != nil {
+			return nil, errors.WithMessagef(err, "peer %s", alias)
+		}
+	}
+	if config.Node.Relay != "" && !config.Node.TLS.enabled() {
+		return nil, errors.WithMessage(errRelayWithoutTLS, "node.relay")
+	}
+	var cert *tls.Certificate
+	var err error
+	if config.Node.TLS.enabled() {
+		if cert, err = config.Node.TLS.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	var history *ledger
+	if config.Node.HistoryPath != "" {
+		if history, err = newLedger(config.Node.HistoryPath); err != nil {
+			return nil, err
+		}
+	}
+	hooks, err := newHooks(config.Hooks)
 	if err != nil {
-		return nil, errors.WithMessage(err, "creating dot setup")
+		return nil, errors.WithMessage(err, "configuring hooks")
+	}
+	var webBal *webBalance
+	if config.Node.Web != "" {
+		webBal = newWebBalance()
 	}
-	dialer := simple.NewTCPDialer(config.Node.DialTimeout)
 
+	ctx, cancel := context.WithCancel(context.Background())
 	n := &node{
-		log:         log.Get(),
-		onChain:     acc,
-		wallet:      wallet,
-		api:         dot.Api,
-		adjudicator: dot.Adjudicator,
-		funder:      dot.Funder,
-		dialer:      dialer,
-		peers:       make(map[string]*peer),
+		log:        log.Get(),
+		ctx:        ctx,
+		cancel:     cancel,
+		tlsCert:    cert,
+		history:    history,
+		hooks:      hooks,
+		webBalance: webBal,
+		dialer:     newDialer(config.Node.Transport, cert),
+		pingPong:   newPingPongs(),
+		autoAccept: newAutoAccepts(),
+		closing:    make(map[string]bool),
 	}
 	return n, n.setup()
 }
 
 func (n *node) setup() error {
-	var err error
-
-	sk, err := sr25519.NewSKFromRng(rand.Reader)
+	opts, err := n.nodeOptions()
 	if err != nil {
-		return errors.WithMessage(err, "generating off-chain account")
+		return err
 	}
-	n.offChain = n.wallet.ImportSK(sk)
-	n.log.WithField("off-chain", n.offChain.Address()).Info("Generated account")
-	n.bus = wirenet.NewBus(n.onChain, n.dialer)
-
-	if n.client, err = client.New(n.onChain.Address(), n.bus, n.funder, n.adjudicator, n.wallet); err != nil {
-		return errors.WithMessage(err, "creating client")
+	if n.core, err = pnode.New(opts); err != nil {
+		return err
 	}
-
-	host := config.Node.IP + ":" + strconv.Itoa(int(config.Node.Port))
-	n.log.WithField("host", host).Trace("Listening for connections")
-	listener, err := simple.NewTCPListener(host)
-	if err != nil {
-		return errors.WithMessage(err, "could not start tcp listener")
+	n.core.Subscribe(n.handleEvent)
+	for _, ch := range n.core.Channels() {
+		fmt.Fprintf(stdout, "💾 Restored channel with %s. Balance: [My: %v, Peer: %v]\n",
+			ch.Peer, dot.NewDotFromPlank(ch.MyBalance), dot.NewDotFromPlank(ch.PeerBalance))
 	}
+	n.core.HandleMessage(benchCtrlType, n.handleBenchCtrl)
 
-	n.client.OnNewChannel(n.setupChannel)
-	if err := n.setupPersistence(); err != nil {
-		return errors.WithMessage(err, "setting up persistence")
-	}
-	go n.client.Handle(n, n)
-	go n.bus.Listen(listener)
-	return n.PrintConfig()
-}
-
-func (n *node) setupPersistence() error {
-	if config.Node.PersistenceEnabled {
-		n.log.Info("Starting persistence")
-		db, err := leveldb.LoadDatabase(config.Node.PersistencePath)
+	if config.Node.Relay != "" {
+		rl, err := newRelayListener(config.Node.Relay, config.Sk, n.tlsCert)
 		if err != nil {
-			return errors.WithMessage(err, "creating/loading database")
+			return errors.WithMessage(err, "registering at relay")
 		}
-		persister := keyvalue.NewPersistRestorer(db)
-		n.client.EnablePersistence(persister)
-
-		ctx, cancel := context.WithTimeout(context.Background(), config.Node.ReconnectTimeout)
-		defer cancel()
-		if err := n.client.Restore(ctx); err != nil {
-			n.log.WithError(err).Warn("Could not restore client")
-			// return the error.
+		n.core.Listen(rl)
+	}
+	if config.Node.Discovery.Enabled {
+		if err := n.startDiscovery(config.Node.Discovery); err != nil {
+			return errors.WithMessage(err, "starting discovery")
+		}
+	}
+	if config.Node.Metrics != "" {
+		if err := n.serveMetrics(config.Node.Metrics); err != nil {
+			return errors.WithMessage(err, "starting metrics")
 		}
-	} else {
-		n.log.Info("Persistence disabled")
 	}
-	return nil
+	if config.Node.Web != "" {
+		if err := n.serveWeb(config.Node.Web); err != nil {
+			return errors.WithMessage(err, "starting web UI")
+		}
+	}
+	return n.PrintConfig()
 }
 
-func setupWallet(hexSk string) (*dotwallet.Wallet, *dotwallet.Account, error) {
-	wallet := dotwallet.NewWallet()
-	sk, err := sr25519.NewSKFromHex(hexSk)
+// nodeOptions returns the options of the core node from the config.
+func (n *node) nodeOptions() (pnode.Options, error) {
+	host := config.Node.IP + ":" + strconv.Itoa(int(config.Node.Port))
+	n.log.WithField("host", host).Trace("Listening for connections")
+	listener, err := newListener(config.Node.Transport, host, n.tlsCert)
 	if err != nil {
-		return nil, nil, errors.WithMessage(err, "creating hdwallet")
+		return pnode.Options{}, errors.WithMessage(err, "could not start listener")
+	}
+	opts := pnode.Options{
+		SecretKey: config.Sk,
+		Chain: pnode.ChainOptions{
+			NodeURL:         config.Chain.NodeUrl,
+			NetworkID:       config.Chain.NetworkId,
+			TxTimeout:       time.Duration(config.Chain.TxTimeoutSec) * time.Second,
+			BlockQueryDepth: config.Chain.BlockQueryDepth,
+		},
+		Listeners:         []wirenet.Listener{listener},
+		Dialer:            n.dialer,
+		Directory:         netDirectory{},
+		DialTimeout:       config.Node.DialTimeout,
+		HandleTimeout:     config.Node.HandleTimeout,
+		ReconnectTimeout:  config.Node.ReconnectTimeout,
+		UpdateTimeout:     config.Channel.Timeout,
+		FundTimeout:       config.Channel.FundTimeout,
+		SettleTimeout:     config.Channel.SettleTimeout,
+		ChallengeDuration: time.Duration(config.Channel.ChallengeDurationSec) * time.Second,
+		PingInterval:      config.Node.PingInterval,
+		CloseOnShutdown:   config.Node.Shutdown == shutdownClose,
+		Limits:            config.Limits.plank(),
+	}
+	if config.Node.PersistenceEnabled {
+		opts.PersistencePath = config.Node.PersistencePath
+	}
+	if config.Node.Metrics != "" {
+		opts.Metrics = nodeMetrics
 	}
-	return wallet, wallet.ImportSK(sk), nil
+	return opts, nil
 }
 
 func (n *node) PrintConfig() error {
-	fmt.Printf(
+	fmt.Fprintf(stdout,
 		"Alias: %s\n"+
-			"Listening: %s:%d\n"+
+			"Listening: %s:%d (%s)\n"+
 			"Node RPC URL: %s\n"+
 			"Perun ID: %s\n"+
 			"OffChain: %s\n"+
-			"", config.Alias, config.Node.IP, config.Node.Port, config.Chain.NodeUrl, n.onChain.Address().String(), n.offChain.Address().String())
-
-	fmt.Println("Known peers:")
-	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
-	for alias, peer := range config.Peers {
-		fmt.Fprintf(w, "%s\t%v\t%s:%d\n", alias, peer.PerunID, peer.Hostname, peer.Port)
+			"", config.Alias, config.Node.IP, config.Node.Port, transport(), config.Chain.NodeUrl, n.core.Address().String(), n.core.OffChainAddress().String())
+	if config.Node.Relay != "" {
+		fmt.Fprintf(stdout, "Relay: %s\n", relay.URL(config.Node.Relay, n.core.Address().String()))
+	}
+	if config.Node.Metrics != "" {
+		fmt.Fprintf(stdout, "Metrics: http://%s/metrics\n", config.Node.Metrics)
 	}
-	return w.Flush()
+	if config.Node.Web != "" {
+		fmt.Fprintf(stdout, "Web UI: %s\n", n.webURL())
+	}
+	if n.tlsCert != nil {
+		fmt.Fprintf(stdout, "TLS Fingerprint: %s\n", fingerprint(n.tlsCert.Leaf))
+	}
+
+	fmt.Fprintln(stdout, "Known peers:")
+	return printPeers(stdout)
 }
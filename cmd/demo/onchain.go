@@ -0,0 +1,437 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/pkg/errors"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+
+	pnode "github.com/perun-network/perun-polkadot-demo/pkg/node"
+)
+
+// onChainBenchDeposit is the deposit in Dot of both participants of the
+// channels that are opened by the on-chain benchmark.
+const onChainBenchDeposit = 1
+
+// Phases of a channel in the on-chain benchmark.
+const (
+	// phaseFund proposes the channel and waits until both deposits are
+	// on-chain.
+	phaseFund = "fund"
+	// phaseClose finalizes the channel off-chain.
+	phaseClose = "close"
+	// phaseSettle concludes the channel and withdraws the own balance.
+	phaseSettle = "settle"
+)
+
+var onChainPhases = []string{phaseFund, phaseClose, phaseSettle}
+
+type (
+	// onChainResults are the results of an on-chain benchmark. Durations
+	// are in nanoseconds and fees in Plank.
+	onChainResults struct {
+		Meta   benchMeta    `json:"meta"`
+		Phases []phaseStats `json:"phases"`
+		// Total is the sum of all phases per iteration.
+		Total phaseStats `json:"total"`
+	}
+
+	phaseStats struct {
+		Phase      string        `json:"phase"`
+		Duration   durationStats `json:"duration"`
+		MeanBlocks float64       `json:"meanBlocks"`
+		MaxBlocks  uint64        `json:"maxBlocks"`
+		MeanFee    *big.Int      `json:"meanFeePlank"`
+		TotalFee   *big.Int      `json:"totalFeePlank"`
+		// Samples are the raw measurements per iteration.
+		Samples []phaseSample `json:"samples"`
+	}
+
+	// phaseSample is the measurement of a phase in one iteration.
+	phaseSample struct {
+		Duration time.Duration `json:"duration"`
+		// Blocks is the number of blocks produced during the phase.
+		Blocks uint64 `json:"blocks"`
+		// Fee is the decrease of the own on-chain balance that is not
+		// explained by the deposit or withdrawal.
+		Fee *big.Int `json:"feePlank"`
+	}
+
+	// autoAccepts are the peers whose on-chain benchmark proposals the user
+	// agreed to accept without asking.
+	autoAccepts struct {
+		mtx   sync.Mutex
+		peers map[wallet.AddrKey]*autoAccept
+	}
+
+	autoAccept struct {
+		// remaining is the number of proposals that are still accepted.
+		remaining int
+		// deadline after which no proposals are accepted anymore.
+		deadline time.Time
+	}
+)
+
+func newAutoAccepts() *autoAccepts {
+	return &autoAccepts{peers: make(map[wallet.AddrKey]*autoAccept)}
+}
+
+// AcceptOnChainBenchmark agrees to accept up to `iterations` channel
+// proposals of an on-chain benchmark of a peer without asking. Only
+// proposals with the deposits of the benchmark and the configured challenge
+// duration are accepted.
+func (n *node) AcceptOnChainBenchmark(args []string) error {
+	alias := args[0]
+	cfg, ok := lookupPeerCfg(alias)
+	if !ok {
+		return errors.Errorf("Alias '%s' unknown. Add it with 'peer add'.", alias)
+	}
+	iterations, _ := strconv.Atoi(args[1]) // Input was already validated by command parser.
+	if iterations < 1 {
+		return errors.New("Number of iterations cant be less than 1")
+	}
+	timeout := time.Duration(iterations) * (config.Channel.FundTimeout + config.Channel.SettleTimeout)
+	if args[2] != "" {
+		timeout, _ = time.ParseDuration(args[2])
+	}
+	n.autoAccept.allow(cfg.perunID, iterations, time.Now().Add(timeout))
+	fmt.Fprintf(stdout, "⛓  Accepting up to %d on-chain benchmark proposals of %s within %v.\n", iterations, alias, timeout)
+	return nil
+}
+
+// OnChainBenchmark repeatedly opens, funds, closes and settles a channel with
+// a peer. It measures the duration, the blocks waited and the fees paid in
+// every phase.
+func (n *node) OnChainBenchmark(args []string) error {
+	alias := args[0]
+	p, ok := n.core.Peer(alias)
+	if !ok {
+		return errors.New("Peer not found")
+	} else if p.Channel != nil {
+		return errors.Errorf("Close the channel with %s first", alias)
+	}
+	iterations, _ := strconv.Atoi(args[1])
+	if iterations < 1 {
+		return errors.New("Number of iterations cant be less than 1")
+	}
+	cfg := benchConfig{
+		peers:   []string{alias},
+		amount:  dotToPlank(big.NewFloat(onChainBenchDeposit))[0],
+		txCount: iterations,
+		workers: 1,
+		out:     args[2],
+	}
+
+	if err := n.sendBenchCtrl(p.PerunID, &benchCtrlMsg{Op: benchCtrlAcceptStart}); err != nil {
+		return err
+	}
+	defer func() {
+		if err := n.sendBenchCtrl(p.PerunID, &benchCtrlMsg{Op: benchCtrlAcceptStop}); err != nil {
+			n.log.WithField("peer", alias).WithError(err).Warn("Stopping on-chain benchmark")
+		}
+	}()
+	fmt.Fprintf(stdout, "⛓  Benchmarking %d channel lifecycle(s) with %s...\n", iterations, alias)
+
+	samples := make(map[string][]phaseSample)
+	start := time.Now()
+	for i := 0; i < iterations; i++ {
+		iter, err := n.onChainIteration(alias, cfg.amount)
+		if err != nil {
+			return errors.WithMessagef(err, "iteration %d", i+1)
+		}
+		for _, phase := range onChainPhases {
+			samples[phase] = append(samples[phase], iter[phase])
+		}
+		fmt.Fprintf(stdout, "⛓  %d/%d: fund %v, settle %v, fees %v\n", i+1, iterations,
+			iter[phaseFund].Duration.Round(time.Millisecond), iter[phaseSettle].Duration.Round(time.Millisecond),
+			dot.NewDotFromPlank(new(big.Int).Add(iter[phaseFund].Fee, iter[phaseSettle].Fee)))
+	}
+
+	res := newOnChainResults(cfg, start, samples, time.Since(start))
+	if err := printOnChainResults(res); err != nil {
+		return err
+	}
+	if cfg.out != "" {
+		if err := writeResults(cfg.out, res, func(w io.Writer) error { return writeOnChainCSV(w, res) }); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "📒 Wrote benchmark results to %s.\n", cfg.out)
+	}
+	return nil
+}
+
+// onChainIteration opens a channel with the peer with `alias` in which both
+// deposit `deposit`, then closes and settles it.
+func (n *node) onChainIteration(alias string, deposit *big.Int) (map[string]phaseSample, error) {
+	samples := make(map[string]phaseSample)
+	var err error
+	samples[phaseFund], err = n.measurePhase(new(big.Int).Neg(deposit), func() error {
+		ctx, cancel := context.WithTimeout(n.ctx, config.Channel.FundTimeout)
+		defer cancel()
+		return n.openChannel(ctx, alias, []*big.Int{deposit, deposit}, pnode.OpenOptions{})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var final pnode.ChannelInfo
+	samples[phaseClose], err = n.measurePhase(new(big.Int), func() (err error) {
+		final, err = n.core.Finalize(n.ctx, alias)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	samples[phaseSettle], err = n.measurePhase(final.MyBalance, func() error {
+		_, err := n.core.Settle(n.ctx, alias)
+		return err
+	})
+	return samples, errors.WithMessage(err, "settling")
+}
+
+// measurePhase runs `phase` and measures its duration, the blocks produced
+// meanwhile and the fees paid. `delta` is the expected change of the own
+// on-chain balance without fees.
+func (n *node) measurePhase(delta *big.Int, phase func() error) (phaseSample, error) {
+	blockBefore, balBefore, err := n.chainState()
+	if err != nil {
+		return phaseSample{}, err
+	}
+	start := time.Now()
+	if err := phase(); err != nil {
+		return phaseSample{}, err
+	}
+	duration := time.Since(start)
+	blockAfter, balAfter, err := n.chainState()
+	if err != nil {
+		return phaseSample{}, err
+	}
+	fee := new(big.Int).Add(balBefore, delta)
+	return phaseSample{
+		Duration: duration,
+		Blocks:   blockAfter - blockBefore,
+		Fee:      fee.Sub(fee, balAfter),
+	}, nil
+}
+
+// chainState returns the latest block number and the own on-chain balance.
+func (n *node) chainState() (uint64, *big.Int, error) {
+	ctx, cancel := context.WithTimeout(n.ctx, config.Channel.Timeout)
+	defer cancel()
+	block, err := n.core.BlockNumber()
+	if err != nil {
+		return 0, nil, err
+	}
+	bals, err := n.core.OnChainBalance(ctx, n.core.Address())
+	if err != nil {
+		return 0, nil, err
+	}
+	return block, bals[0], nil
+}
+
+// handleAutoAccept handles the start and the end of an on-chain benchmark of
+// `sender`. The proposals are only accepted automatically if the user agreed
+// to it with 'benchmark onchain accept', the end revokes that.
+func (n *node) handleAutoAccept(sender wire.Address, start bool) {
+	p, ok := n.peerByID(sender)
+	if !ok {
+		n.log.WithField("peer", sender).Warn("Dropping on-chain benchmark request of unknown peer")
+		return
+	}
+	switch {
+	case start && n.autoAccept.has(sender):
+		PrintfAsync("⛓  %s started an on-chain benchmark, its proposals are accepted automatically.\n", p.Alias)
+	case start:
+		PrintfAsync("⛓  %s started an on-chain benchmark. Enter 'benchmark onchain accept %s <iterations>' to accept its proposals automatically.\n", p.Alias, p.Alias)
+	case n.autoAccept.revoke(sender):
+		PrintfAsync("⛓  On-chain benchmark of %s finished.\n", p.Alias)
+	}
+}
+
+// acceptOnChainBench accepts a proposal of an on-chain benchmark. The peer
+// starts the next iteration as soon as its settlement is done, so wait until
+// the previous channel is settled here too.
+func (n *node) acceptOnChainBench(p pnode.Proposal) {
+	deadline := time.Now().Add(config.Channel.SettleTimeout)
+	for n.hasChannel(p.Peer) {
+		if time.Now().After(deadline) {
+			if err := n.core.Reject(n.ctx, p.ID, "previous channel not settled"); err != nil {
+				n.log.Error(errors.WithMessage(err, "rejecting channel proposal"))
+			}
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if _, err := n.core.Accept(n.ctx, p.ID); err != nil {
+		n.log.Error(err)
+		n.hooks.fireError(p.Peer, err)
+	}
+}
+
+// allow accepts `count` proposals of `addr` until `deadline`.
+func (a *autoAccepts) allow(addr wire.Address, count int, deadline time.Time) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.peers[wallet.Key(addr)] = &autoAccept{remaining: count, deadline: deadline}
+}
+
+// revoke stops accepting the proposals of `addr` and returns whether they
+// were accepted.
+func (a *autoAccepts) revoke(addr wire.Address) bool {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	_, ok := a.peers[wallet.Key(addr)]
+	delete(a.peers, wallet.Key(addr))
+	return ok
+}
+
+// has returns whether proposals of `addr` are accepted automatically.
+func (a *autoAccepts) has(addr wire.Address) bool {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	acc, ok := a.peers[wallet.Key(addr)]
+	return ok && time.Now().Before(acc.deadline)
+}
+
+// take returns whether the proposal `p` is accepted automatically and counts
+// it. Only proposals of the on-chain benchmark qualify: both deposit
+// onChainBenchDeposit and the challenge duration is the configured one.
+func (a *autoAccepts) take(p pnode.Proposal) bool {
+	deposit := dotToPlank(big.NewFloat(onChainBenchDeposit))[0]
+	challenge := time.Duration(config.Channel.ChallengeDurationSec) * time.Second
+	if p.Unknown || p.MyBalance.Cmp(deposit) != 0 || p.PeerBalance.Cmp(deposit) != 0 || p.ChallengeDuration != challenge {
+		return false
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	key := wallet.Key(p.PeerID)
+	acc, ok := a.peers[key]
+	if !ok {
+		return false
+	} else if time.Now().After(acc.deadline) {
+		delete(a.peers, key)
+		return false
+	}
+	if acc.remaining--; acc.remaining == 0 {
+		delete(a.peers, key)
+	}
+	return true
+}
+
+func newOnChainResults(cfg benchConfig, start time.Time, samples map[string][]phaseSample, elapsed time.Duration) onChainResults {
+	r := onChainResults{
+		Meta: benchMeta{
+			Time:        start,
+			Mode:        benchModeOnChain,
+			Revision:    buildRevision(),
+			Node:        config.Alias,
+			Transport:   config.Node.Transport,
+			Persistence: config.Node.PersistenceEnabled,
+			Peers:       cfg.peers,
+			AmountPlank: cfg.amount,
+			TxCount:     cfg.txCount,
+			Workers:     cfg.workers,
+			Elapsed:     elapsed,
+		},
+	}
+	total := make([]phaseSample, cfg.txCount)
+	for i := range total {
+		total[i].Fee = new(big.Int)
+	}
+	for _, phase := range onChainPhases {
+		r.Phases = append(r.Phases, newPhaseStats(phase, samples[phase]))
+		for i, s := range samples[phase] {
+			total[i].Duration += s.Duration
+			total[i].Blocks += s.Blocks
+			total[i].Fee.Add(total[i].Fee, s.Fee)
+		}
+	}
+	r.Total = newPhaseStats("total", total)
+	return r
+}
+
+func newPhaseStats(phase string, samples []phaseSample) phaseStats {
+	s := phaseStats{Phase: phase, TotalFee: new(big.Int), Samples: samples}
+	durations := make([]time.Duration, len(samples))
+	var blocks uint64
+	for i, sample := range samples {
+		durations[i] = sample.Duration
+		blocks += sample.Blocks
+		if sample.Blocks > s.MaxBlocks {
+			s.MaxBlocks = sample.Blocks
+		}
+		s.TotalFee.Add(s.TotalFee, sample.Fee)
+	}
+	s.Duration = newDurationStats(durations)
+	s.MeanBlocks = float64(blocks) / float64(len(samples))
+	s.MeanFee = new(big.Int).Div(s.TotalFee, big.NewInt(int64(len(samples))))
+	return s
+}
+
+func printOnChainResults(r onChainResults) error {
+	w := tabwriter.NewWriter(stdout, 0, 0, 3, ' ', tabwriter.Debug)
+	fmt.Fprintln(w, "Phase\tN\tMin\tMedian\tMean\tMax\tBlocks Mean\tBlocks Max\tFee Mean\tFee Total\t")
+	for _, s := range append(r.Phases, r.Total) {
+		d := s.Duration
+		fmt.Fprintf(w, "%s\t%d\t%s\t%.1f\t%d\t%v\t%v\t\n", s.Phase, len(s.Samples),
+			fmtDurations(d.Min, d.Median, d.Mean, d.Max), s.MeanBlocks, s.MaxBlocks,
+			dot.NewDotFromPlank(s.MeanFee), dot.NewDotFromPlank(s.TotalFee))
+	}
+	fmt.Fprintln(w)
+	return w.Flush()
+}
+
+// writeOnChainCSV writes one row per phase and one for the total, like
+// writeBenchCSV. Durations are in nanoseconds and fees in Plank.
+func writeOnChainCSV(w io.Writer, r onChainResults) error {
+	cw := csv.NewWriter(w)
+	header := []string{"time", "mode", "revision", "node", "transport", "peer", "iterations", "deposit_plank", "phase",
+		"duration_min_ns", "duration_median_ns", "duration_mean_ns", "duration_max_ns", "duration_stddev_ns",
+		"blocks_mean", "blocks_max", "fee_mean_plank", "fee_total_plank"}
+	if err := cw.Write(header); err != nil {
+		return errors.Wrap(err, "writing csv")
+	}
+	m := r.Meta
+	for _, s := range append(r.Phases, r.Total) {
+		record := []string{
+			m.Time.Format(time.RFC3339Nano), m.Mode, m.Revision, m.Node, m.Transport, m.Peers[0],
+			strconv.Itoa(m.TxCount), m.AmountPlank.String(), s.Phase,
+		}
+		d := s.Duration
+		for _, v := range []time.Duration{d.Min, d.Median, d.Mean, d.Max, d.Stddev} {
+			record = append(record, strconv.FormatInt(int64(v), 10))
+		}
+		record = append(record, strconv.FormatFloat(s.MeanBlocks, 'f', 3, 64), strconv.FormatUint(s.MaxBlocks, 10),
+			s.MeanFee.String(), s.TotalFee.String())
+		if err := cw.Write(record); err != nil {
+			return errors.Wrap(err, "writing csv")
+		}
+	}
+	cw.Flush()
+	return errors.Wrap(cw.Error(), "writing csv")
+}
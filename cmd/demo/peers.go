@@ -0,0 +1,287 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+
+	"github.com/perun-network/perun-polkadot-demo/cmd/relay"
+)
+
+// peersMtx protects config.Peers, which can be modified at runtime by the
+// `peer` commands while incoming proposals are looked up concurrently.
+var peersMtx sync.RWMutex
+
+// lookupPeerCfg returns the network config entry for the given alias. Falls
+// back to discovered peers.
+func lookupPeerCfg(alias string) (*netConfigEntry, bool) {
+	peersMtx.RLock()
+	defer peersMtx.RUnlock()
+	if e, ok := config.Peers[alias]; ok {
+		return e, true
+	}
+	if p, ok := discoveredPeers[alias]; ok {
+		return p.netConfigEntry, true
+	}
+	return nil, false
+}
+
+// findConfig returns the alias and network config entry for the given Perun
+// ID or an empty alias and nil if the ID is unknown. Falls back to discovered
+// peers.
+func findConfig(id wallet.Address) (string, *netConfigEntry) {
+	peersMtx.RLock()
+	defer peersMtx.RUnlock()
+	for alias, e := range config.Peers {
+		if e.perunID.Equals(id) {
+			return alias, e
+		}
+	}
+	for alias, p := range discoveredPeers {
+		if p.perunID.Equals(id) {
+			return alias, p.netConfigEntry
+		}
+	}
+	return "", nil
+}
+
+// netDirectory resolves the peers of the core node from the network config
+// and the discovered peers.
+type netDirectory struct{}
+
+func (netDirectory) Lookup(alias string) (wire.Address, string, bool) {
+	cfg, ok := lookupPeerCfg(alias)
+	if !ok {
+		return nil, "", false
+	}
+	return cfg.perunID, cfg.address(), true
+}
+
+func (netDirectory) Alias(id wire.Address) (string, bool) {
+	alias, cfg := findConfig(id)
+	return alias, cfg != nil
+}
+
+// parseHostPort splits `host:port` into hostname and port.
+func parseHostPort(addr string) (string, uint16, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "parsing host")
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "parsing port")
+	}
+	return host, uint16(port), nil
+}
+
+// AddPeer adds a peer to the known peers, registers it with the dialer and
+// persists it in the network config file.
+func (n *node) AddPeer(args []string) error {
+	alias := args[0]
+	id, err := strToAddress(args[1])
+	if err != nil {
+		return errors.WithMessage(err, "parsing Perun ID")
+	}
+	entry := &netConfigEntry{PerunID: args[1], perunID: id}
+	if isWSURL(args[2]) {
+		if err := checkWSURL(args[2], config.Node.TLS.enabled(), ""); err != nil {
+			return err
+		}
+		entry.URL = args[2]
+	} else if relay.IsURL(args[2]) {
+		// The certificate is pinned with 'peer pin' afterwards.
+		if err := checkRelayPeer(args[2], "", false); err != nil {
+			return err
+		}
+		entry.URL = args[2]
+	} else if entry.Hostname, entry.Port, err = parseHostPort(args[2]); err != nil {
+		return err
+	}
+
+	peersMtx.Lock()
+	if _, ok := config.Peers[alias]; ok {
+		peersMtx.Unlock()
+		return errors.Errorf("Alias '%s' already known", alias)
+	}
+	for other, e := range config.Peers {
+		if e.perunID.Equals(id) {
+			peersMtx.Unlock()
+			return errors.Errorf("Perun ID already known as '%s'", other)
+		}
+	}
+	if config.Peers == nil {
+		config.Peers = make(map[string]*netConfigEntry)
+	}
+	config.Peers[alias] = entry
+	peersMtx.Unlock()
+
+	n.dialer.Register(id, args[2])
+	// Rename a temporary peer that was accepted as unknown identity.
+	n.core.RenamePeer(id, alias)
+
+	if err := savePeers(flags.cfgNetFile); err != nil {
+		return errors.WithMessage(err, "saving network config")
+	}
+	fmt.Fprintf(stdout, "👤 Added peer %s.\n", alias)
+	return nil
+}
+
+// RemovePeer removes a peer without an open channel from the known peers, the
+// dialer and the network config file.
+func (n *node) RemovePeer(args []string) error {
+	alias := args[0]
+	if err := n.core.RemovePeer(alias); err != nil {
+		return err
+	}
+
+	peersMtx.Lock()
+	entry, ok := config.Peers[alias]
+	delete(config.Peers, alias)
+	peersMtx.Unlock()
+	if ok {
+		n.dialer.Unregister(entry.perunID)
+	}
+
+	if err := savePeers(flags.cfgNetFile); err != nil {
+		return errors.WithMessage(err, "saving network config")
+	}
+	fmt.Fprintf(stdout, "👤 Removed peer %s.\n", alias)
+	return nil
+}
+
+// PinPeer pins the TLS certificate fingerprint of a peer and persists it in
+// the network config file.
+func (n *node) PinPeer(args []string) error {
+	peersMtx.Lock()
+	cfg, ok := config.Peers[args[0]]
+	if !ok {
+		defer peersMtx.Unlock()
+		// Discovered peers are not saved, so their pin is kept until they
+		// expire.
+		p, ok := discoveredPeers[args[0]]
+		if !ok {
+			return errors.Errorf("Unknown alias: %s", args[0])
+		}
+		p.CertFingerprint = strings.ToLower(args[1])
+		fmt.Fprintf(stdout, "📌 Pinned certificate of discovered peer %s.\n", args[0])
+		return nil
+	}
+	if transport() == transportWS {
+		if err := checkWSURL(cfg.URL, false, args[1]); err != nil {
+			peersMtx.Unlock()
+			return err
+		}
+	}
+	cfg.CertFingerprint = strings.ToLower(args[1])
+	peersMtx.Unlock()
+
+	if err := savePeers(flags.cfgNetFile); err != nil {
+		return errors.WithMessage(err, "saving network config")
+	}
+	fmt.Fprintf(stdout, "📌 Pinned certificate of %s.\n", args[0])
+	return nil
+}
+
+// ListPeers prints all known peers.
+func (n *node) ListPeers([]string) error {
+	return printPeers(stdout)
+}
+
+func printPeers(out io.Writer) error {
+	peersMtx.RLock()
+	defer peersMtx.RUnlock()
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', tabwriter.TabIndent)
+	for _, alias := range sortedKeys(config.Peers) {
+		peer := config.Peers[alias]
+		fmt.Fprintf(w, "%s\t%v\t%s\n", alias, peer.PerunID, peer.address())
+	}
+	return w.Flush()
+}
+
+func sortedKeys(peers map[string]*netConfigEntry) []string {
+	keys := make([]string, 0, len(peers))
+	for k := range peers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// savePeers writes the known peers to the network config file at `path`. Only
+// the `peers` key is replaced, the other keys and the comments are kept.
+func savePeers(path string) error {
+	var doc yaml.Node
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "reading file")
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return errors.Wrap(err, "parsing file")
+	}
+	if doc.Kind == 0 { // Empty or missing file.
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return errors.New("parsing file: expected a mapping")
+	}
+
+	var peers yaml.Node
+	peersMtx.RLock()
+	err = peers.Encode(config.Peers)
+	peersMtx.RUnlock()
+	if err != nil {
+		return errors.Wrap(err, "encoding peers")
+	}
+	setKey(root, "peers", &peers)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return errors.Wrap(err, "encoding file")
+	}
+	return errors.Wrap(ioutil.WriteFile(path, buf.Bytes(), 0644), "writing file")
+}
+
+// setKey sets `key` of the mapping node `m` to `value`. The comments of an
+// existing key are kept.
+func setKey(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			old := m.Content[i+1]
+			value.HeadComment, value.LineComment, value.FootComment = old.HeadComment, old.LineComment, old.FootComment
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
@@ -0,0 +1,78 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSavePeersKeepsOtherKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network.yaml")
+	in := `# Network of the demo.
+relay: 127.0.0.1:5800 # Other keys stay.
+# The known peers.
+peers:
+  alice:
+    perunID: 0x01
+    hostname: 127.0.0.1
+    port: 5750
+`
+	if err := ioutil.WriteFile(path, []byte(in), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := config.Peers
+	defer func() { config.Peers = old }()
+	config.Peers = map[string]*netConfigEntry{
+		"bob": {PerunID: "0x02", Hostname: "127.0.0.1", Port: 5751},
+	}
+
+	if err := savePeers(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	for _, want := range []string{"# Network of the demo.", "relay: 127.0.0.1:5800 # Other keys stay.", "# The known peers.", "  bob:\n    perunID: \"0x02\""} {
+		if !strings.Contains(out, want) {
+			t.Errorf("saved file misses %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "alice") {
+		t.Errorf("saved file still contains the removed peer:\n%s", out)
+	}
+}
+
+func TestSavePeersNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "network.yaml")
+	old := config.Peers
+	defer func() { config.Peers = old }()
+	config.Peers = map[string]*netConfigEntry{"bob": {PerunID: "0x02", Hostname: "127.0.0.1", Port: 5751}}
+
+	if err := savePeers(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "peers:\n  bob:\n") {
+		t.Errorf("unexpected file:\n%s", data)
+	}
+}
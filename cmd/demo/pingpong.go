@@ -0,0 +1,376 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+
+	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/pkg/errors"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+	perunio "perun.network/go-perun/pkg/io"
+	"perun.network/go-perun/wire"
+
+	pnode "github.com/perun-network/perun-polkadot-demo/pkg/node"
+)
+
+// benchCtrlType is the wire type of benchmark control messages. It is outside
+// of the range of the Perun wire protocol.
+const benchCtrlType = wire.LastType + 2
+
+// Operations of benchmark control messages.
+const (
+	// benchCtrlStart asks the peer to pay back the received payments. The
+	// peer must have agreed with 'benchmark pingpong accept'.
+	benchCtrlStart uint8 = iota + 1
+	// benchCtrlStop ends the ping-pong, the peer replies with its results.
+	benchCtrlStop
+	// benchCtrlResult carries the results of the responder.
+	benchCtrlResult
+	// benchCtrlAcceptStart announces an on-chain benchmark. The peer
+	// accepts its proposals automatically if it agreed with 'benchmark
+	// onchain accept'.
+	benchCtrlAcceptStart
+	// benchCtrlAcceptStop ends the on-chain benchmark.
+	benchCtrlAcceptStop
+)
+
+func init() {
+	wire.RegisterExternalDecoder(benchCtrlType, decodeBenchCtrlMsg, "BenchmarkControl")
+}
+
+type (
+	// benchCtrlMsg coordinates a benchmark with the peer.
+	benchCtrlMsg struct {
+		// Channel is zero for on-chain benchmarks.
+		Channel channel.ID
+		Op      uint8
+		// Results of the responder, only set for benchCtrlResult. Durations
+		// are in nanoseconds.
+		N, Median, P99, Collisions uint64
+	}
+
+	// pingPongs tracks the ping-pong benchmarks of the node, as initiator
+	// and as responder.
+	pingPongs struct {
+		mtx sync.Mutex
+		// pongs signals the received payments to an initiator.
+		pongs map[channel.ID]chan struct{}
+		// results passes the results of a responder to the initiator.
+		results map[channel.ID]chan *benchCtrlMsg
+		// allowed are the number of payments that the user agreed to pay
+		// back per channel, until the peer starts the ping-pong.
+		allowed map[channel.ID]int
+		// responders are the channels whose payments are paid back.
+		responders map[channel.ID]*pongResponder
+	}
+
+	pongResponder struct {
+		// remaining is the number of payments that are still paid back.
+		remaining int
+		// lastPing is the time at which the last ping was received.
+		lastPing time.Time
+		// rtts are the times between two consecutive pings, each one
+		// round-trip as seen by the responder.
+		rtts       []time.Duration
+		collisions uint64 // Collision count of the channel at the start.
+	}
+)
+
+func (*benchCtrlMsg) Type() wire.Type {
+	return benchCtrlType
+}
+
+func (m *benchCtrlMsg) Encode(w io.Writer) error {
+	return perunio.Encode(w, m.Channel, m.Op, m.N, m.Median, m.P99, m.Collisions)
+}
+
+func decodeBenchCtrlMsg(r io.Reader) (wire.Msg, error) {
+	var m benchCtrlMsg
+	if err := perunio.Decode(r, &m.Channel, &m.Op, &m.N, &m.Median, &m.P99, &m.Collisions); err != nil {
+		return nil, err
+	}
+	if m.Op < benchCtrlStart || m.Op > benchCtrlAcceptStop {
+		return nil, errors.Errorf("unknown benchmark control operation %d", m.Op)
+	}
+	return &m, nil
+}
+
+func newPingPongs() *pingPongs {
+	return &pingPongs{
+		pongs:      make(map[channel.ID]chan struct{}),
+		results:    make(map[channel.ID]chan *benchCtrlMsg),
+		allowed:    make(map[channel.ID]int),
+		responders: make(map[channel.ID]*pongResponder),
+	}
+}
+
+// AcceptPingPong agrees to pay back up to `txCount` payments of the next
+// ping-pong benchmark of a peer.
+func (n *node) AcceptPingPong(args []string) error {
+	alias := args[0]
+	p, ok := n.core.Peer(alias)
+	if !ok {
+		return errors.New("Peer not found")
+	} else if p.Channel == nil {
+		return errors.New("Open a state channel first")
+	}
+	count, _ := strconv.Atoi(args[1]) // Input was already validated by command parser.
+	if count < 1 {
+		return errors.New("Number of runs cant be less than 1")
+	}
+	n.pingPong.allow(p.Channel.ID, count)
+	fmt.Fprintf(stdout, "🏓 Paying back up to %d payments of the next ping-pong of %s.\n", count, alias)
+	return nil
+}
+
+// PingPong sends `count` payments of `amount` to a peer, which pays each
+// back. It measures the round-trip latency as seen by both nodes.
+func (n *node) PingPong(args []string) error {
+	alias := args[0]
+	p, ok := n.core.Peer(alias)
+	if !ok {
+		return errors.New("Peer not found")
+	}
+	if p.Channel == nil {
+		return errors.New("Open a state channel first")
+	}
+	amountDot, _ := new(big.Float).SetString(args[1]) // Input was already validated by command parser.
+	count, _ := strconv.Atoi(args[2])
+	if count < 1 {
+		return errors.New("Number of runs cant be less than 1")
+	}
+	cfg := benchConfig{peers: []string{alias}, amount: dotToPlank(amountDot)[0], txCount: count, workers: 1, out: args[3]}
+
+	id := p.Channel.ID
+	pongs, results := n.pingPong.expect(id)
+	defer n.pingPong.unexpect(id)
+	if err := n.sendBenchCtrl(p.PerunID, &benchCtrlMsg{Channel: id, Op: benchCtrlStart}); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "🏓 Ping-pong with %s: %d payments of %v...\n", alias, count, dot.NewDotFromPlank(cfg.amount))
+
+	collisions := p.Channel.Collisions
+	var r run
+	start := time.Now()
+	runErr := func() error {
+		for i := 0; i < count; i++ {
+			sent := time.Now()
+			s, err := n.benchSend(alias, cfg.amount)
+			if err != nil {
+				return errors.WithMessage(err, "sending ping")
+			}
+			select {
+			case <-pongs:
+			case <-time.After(config.Channel.Timeout):
+				return errors.Errorf("No pong from %s", alias)
+			}
+			s.latency = time.Since(sent)
+			r.add(s)
+		}
+		return nil
+	}()
+	elapsed := time.Since(start)
+
+	// Also stop the peer if the run failed.
+	if err := n.sendBenchCtrl(p.PerunID, &benchCtrlMsg{Channel: id, Op: benchCtrlStop}); err != nil {
+		return err
+	} else if runErr != nil {
+		return runErr
+	}
+
+	res := newBenchResults(cfg, start, map[string]*run{alias: &r}, elapsed)
+	res.Meta.Mode = benchModePingPong
+	res.Channels[0].Collisions = n.collisions(alias) - collisions
+	res.Total.Collisions = res.Channels[0].Collisions
+	select {
+	case m := <-results:
+		res.Responder = &responderStats{
+			N:          int(m.N),
+			Median:     time.Duration(m.Median),
+			P99:        time.Duration(m.P99),
+			Collisions: m.Collisions,
+		}
+	case <-time.After(config.Channel.Timeout):
+		fmt.Fprintf(stdout, "⚠️  No results from %s.\n", alias)
+	}
+	return n.reportBenchResults(res, cfg.out)
+}
+
+// sendBenchCtrl sends a benchmark control message to `peer`.
+func (n *node) sendBenchCtrl(peer wire.Address, m *benchCtrlMsg) error {
+	ctx, cancel := context.WithTimeout(n.ctx, config.Channel.Timeout)
+	defer cancel()
+	err := n.core.Publish(ctx, peer, m)
+	return errors.WithMessage(err, "sending benchmark control message")
+}
+
+// handleBenchCtrl handles the benchmark control messages of the peers. It
+// runs in the receive loop of the bus and must not block.
+func (n *node) handleBenchCtrl(e *wire.Envelope) {
+	m := e.Msg.(*benchCtrlMsg)
+	if m.Op == benchCtrlAcceptStart || m.Op == benchCtrlAcceptStop {
+		// On-chain benchmarks are not bound to a channel.
+		n.handleAutoAccept(e.Sender, m.Op == benchCtrlAcceptStart)
+		return
+	}
+	alias, peerID, collisions, ok := n.channelByID(m.Channel)
+	if !ok || !peerID.Equals(e.Sender) {
+		log.WithField("peer", e.Sender).WithField("channel", m.Channel).Warn("Dropping benchmark control message for unknown channel")
+		return
+	}
+
+	switch m.Op {
+	case benchCtrlStart:
+		count, ok := n.pingPong.respond(m.Channel, collisions)
+		if !ok {
+			PrintfAsync("🏓 %s wants to run a ping-pong benchmark. Enter 'benchmark pingpong accept %s <txCount>' to pay back its payments.\n", alias, alias)
+			return
+		}
+		PrintfAsync("🏓 %s started a ping-pong benchmark, up to %d payments are paid back.\n", alias, count)
+	case benchCtrlStop:
+		result := n.pingPong.stopResponding(m.Channel, collisions)
+		if result == nil {
+			return
+		}
+		PrintfAsync("🏓 Ping-pong with %s finished: %d round-trips, median %v, p99 %v.\n",
+			alias, result.N, time.Duration(result.Median).Round(time.Microsecond), time.Duration(result.P99).Round(time.Microsecond))
+		go func() {
+			if err := n.sendBenchCtrl(e.Sender, result); err != nil {
+				log.WithField("peer", alias).WithError(err).Warn("Sending ping-pong results")
+			}
+		}()
+	case benchCtrlResult:
+		n.pingPong.result(m)
+	}
+}
+
+// pingPongReceived is called for every received payment. It signals pongs to
+// an initiator and pays back pings as responder.
+func (n *node) pingPongReceived(e pnode.Event) {
+	n.pingPong.pong(e.Channel)
+	if e.Amount.Sign() <= 0 || !n.pingPong.ping(e.Channel) {
+		return
+	}
+	// The update handler still holds the channel, so pay back concurrently.
+	received := e.Amount
+	go func() {
+		if _, err := n.benchSend(e.Peer, received); err != nil {
+			log.WithField("peer", e.Peer).WithError(err).Warn("Sending pong")
+		}
+	}()
+}
+
+// expect registers an initiator of a ping-pong on channel `id`.
+func (pp *pingPongs) expect(id channel.ID) (pongs <-chan struct{}, results <-chan *benchCtrlMsg) {
+	pp.mtx.Lock()
+	defer pp.mtx.Unlock()
+	pp.pongs[id] = make(chan struct{}, 1)
+	pp.results[id] = make(chan *benchCtrlMsg, 1)
+	return pp.pongs[id], pp.results[id]
+}
+
+func (pp *pingPongs) unexpect(id channel.ID) {
+	pp.mtx.Lock()
+	defer pp.mtx.Unlock()
+	delete(pp.pongs, id)
+	delete(pp.results, id)
+}
+
+// pong signals a received payment to the initiator, if any.
+func (pp *pingPongs) pong(id channel.ID) {
+	pp.mtx.Lock()
+	defer pp.mtx.Unlock()
+	select {
+	case pp.pongs[id] <- struct{}{}:
+	default:
+	}
+}
+
+func (pp *pingPongs) result(m *benchCtrlMsg) {
+	pp.mtx.Lock()
+	defer pp.mtx.Unlock()
+	select {
+	case pp.results[m.Channel] <- m:
+	default:
+	}
+}
+
+// allow agrees to pay back `count` payments of the next ping-pong on channel
+// `id`.
+func (pp *pingPongs) allow(id channel.ID, count int) {
+	pp.mtx.Lock()
+	defer pp.mtx.Unlock()
+	pp.allowed[id] = count
+}
+
+// respond starts paying back the payments on channel `id` if the user agreed
+// to it and returns their number.
+func (pp *pingPongs) respond(id channel.ID, collisions uint64) (int, bool) {
+	pp.mtx.Lock()
+	defer pp.mtx.Unlock()
+	count, ok := pp.allowed[id]
+	if !ok {
+		return 0, false
+	}
+	delete(pp.allowed, id)
+	pp.responders[id] = &pongResponder{remaining: count, collisions: collisions}
+	return count, true
+}
+
+// ping records a received ping and returns whether it must be paid back.
+func (pp *pingPongs) ping(id channel.ID) bool {
+	pp.mtx.Lock()
+	defer pp.mtx.Unlock()
+	r, ok := pp.responders[id]
+	if !ok || r.remaining == 0 {
+		return false
+	}
+	r.remaining--
+	now := time.Now()
+	if !r.lastPing.IsZero() {
+		r.rtts = append(r.rtts, now.Sub(r.lastPing))
+	}
+	r.lastPing = now
+	return true
+}
+
+// stopResponding stops paying back the payments on channel `id` and returns
+// the results, nil if it was not responding.
+func (pp *pingPongs) stopResponding(id channel.ID, collisions uint64) *benchCtrlMsg {
+	pp.mtx.Lock()
+	defer pp.mtx.Unlock()
+	r, ok := pp.responders[id]
+	if !ok {
+		return nil
+	}
+	delete(pp.responders, id)
+	stats := newDurationStats(r.rtts)
+	return &benchCtrlMsg{
+		Channel:    id,
+		Op:         benchCtrlResult,
+		N:          uint64(len(r.rtts)),
+		Median:     uint64(stats.Median),
+		P99:        uint64(stats.P99),
+		Collisions: collisions - r.collisions,
+	}
+}
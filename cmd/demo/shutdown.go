@@ -0,0 +1,76 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"fmt"
+
+	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/pkg/errors"
+
+	pnode "github.com/perun-network/perun-polkadot-demo/pkg/node"
+)
+
+// Shutdown modes, see nodeConfig.Shutdown.
+const (
+	shutdownPersist = "persist"
+	shutdownClose   = "close"
+)
+
+func validateShutdown(mode string) error {
+	switch mode {
+	case "", shutdownPersist, shutdownClose:
+		return nil
+	default:
+		return errors.Errorf("unknown shutdown mode '%s', expected %s or %s", mode, shutdownPersist, shutdownClose)
+	}
+}
+
+// Exit shuts the node down. Open channels are closed or kept open depending
+// on 'node.shutdown'. Channels that stay open are reported. Exit is
+// idempotent.
+func (n *node) Exit([]string) error {
+	n.shutdownOnce.Do(func() { n.shutdownErr = n.shutdown() })
+	return n.shutdownErr
+}
+
+func (n *node) shutdown() error {
+	fmt.Fprintln(stdout, "🛑 Shutting down...")
+	// Closes the channels in close mode, see nodeOptions.
+	open, err := n.core.Shutdown()
+	reportOpen(open)
+
+	// Stops discovery and the metrics and web server.
+	n.cancel()
+	if herr := n.history.Close(); herr != nil && err == nil {
+		err = errors.WithMessage(herr, "closing history")
+	}
+	// Delivers the events of the shutdown, e.g. the settlements.
+	n.hooks.close(config.Node.HandleTimeout)
+	return err
+}
+
+// reportOpen prints the channels that stay open and whether their funds are
+// at risk.
+func reportOpen(open []pnode.ChannelInfo) {
+	for _, ch := range open {
+		my := dot.NewDotFromPlank(ch.MyBalance)
+		if config.Node.PersistenceEnabled {
+			fmt.Fprintf(stdout, "💾 Channel with %s stays open with %v, restart with persistence to use it.\n", ch.Peer, my)
+		} else {
+			fmt.Fprintf(stdout, "🚨 Channel with %s stays open with %v at risk: persistence is disabled, so it cannot be restored.\n", ch.Peer, my)
+		}
+	}
+}
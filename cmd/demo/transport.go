@@ -0,0 +1,156 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+	pkgsync "perun.network/go-perun/pkg/sync"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+	wirenet "perun.network/go-perun/wire/net"
+	"perun.network/go-perun/wire/net/simple"
+)
+
+// dialer is a wire dialer that dials peers at their registered hosts.
+type dialer interface {
+	wirenet.Dialer
+	Register(addr wire.Address, host string)
+	Unregister(addr wire.Address)
+}
+
+// Supported wire transports.
+const (
+	transportTCP = "tcp"
+	transportWS  = "ws"
+)
+
+// validateTransport checks that the configured transport is supported.
+func validateTransport(transport string) error {
+	switch transport {
+	case "", transportTCP, transportWS:
+		return nil
+	default:
+		return errors.Errorf("unknown transport '%s', expected %s or %s", transport, transportTCP, transportWS)
+	}
+}
+
+// transport returns the configured transport.
+func transport() string {
+	if config.Node.Transport == "" {
+		return transportTCP
+	}
+	return config.Node.Transport
+}
+
+// newDialer returns the dialer for the configured transport. Peers with a
+// relay address are dialed through their relay, which requires TLS. The
+// connections are secured with TLS if `cert` is not nil.
+func newDialer(transport string, cert *tls.Certificate) dialer {
+	return newRelayDialer(newTransportDialer(transport, cert), cert)
+}
+
+func newTransportDialer(transport string, cert *tls.Certificate) dialer {
+	switch {
+	case transport == transportWS:
+		return newWSDialer(cert)
+	case cert != nil:
+		return newTLSDialer(*cert)
+	default:
+		return newTCPDialer()
+	}
+}
+
+// newTCPDialer returns a dialer for plain TCP connections.
+func newTCPDialer() *hostDialer {
+	return newHostDialer(func(ctx context.Context, host string, _ wire.Address) (wirenet.Conn, error) {
+		d := net.Dialer{Timeout: config.Node.DialTimeout}
+		conn, err := d.DialContext(ctx, "tcp", host)
+		if err != nil {
+			return nil, errors.Wrap(err, "dialing")
+		}
+		return wirenet.NewIoConn(conn), nil
+	})
+}
+
+// newListener returns the listener for the configured transport.
+func newListener(transport, host string, cert *tls.Certificate) (wirenet.Listener, error) {
+	switch {
+	case transport == transportWS:
+		return newWSListener(host, cert)
+	case cert != nil:
+		return newTLSListener(host, *cert)
+	default:
+		return simple.NewTCPListener(host)
+	}
+}
+
+// hostDialer dials peers at their registered hosts with a transport specific
+// dial function.
+type hostDialer struct {
+	mtx   sync.RWMutex              // Protects peers.
+	peers map[wallet.AddrKey]string // Known peer hosts.
+	dial  func(ctx context.Context, host string, peer wire.Address) (wirenet.Conn, error)
+
+	pkgsync.Closer
+}
+
+func newHostDialer(dial func(context.Context, string, wire.Address) (wirenet.Conn, error)) *hostDialer {
+	return &hostDialer{
+		peers: make(map[wallet.AddrKey]string),
+		dial:  dial,
+	}
+}
+
+// Register sets the host of a peer.
+func (d *hostDialer) Register(addr wire.Address, host string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.peers[wallet.Key(addr)] = host
+}
+
+// Unregister forgets the host of a peer.
+func (d *hostDialer) Unregister(addr wire.Address) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	delete(d.peers, wallet.Key(addr))
+}
+
+// Dial dials the registered host of `addr`. It is aborted when the dialer is
+// closed.
+func (d *hostDialer) Dial(ctx context.Context, addr wire.Address) (wirenet.Conn, error) {
+	d.mtx.RLock()
+	host, ok := d.peers[wallet.Key(addr)]
+	d.mtx.RUnlock()
+	if !ok {
+		return nil, errors.New("peer not found")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-d.Closed():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	conn, err := d.dial(ctx, host, addr)
+	return conn, errors.WithMessage(err, "failed to dial peer")
+}
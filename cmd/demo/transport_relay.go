@@ -0,0 +1,164 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+
+	sr25519 "github.com/perun-network/perun-polkadot-backend/pkg/sr25519"
+	dotwallet "github.com/perun-network/perun-polkadot-backend/wallet/sr25519"
+	"github.com/pkg/errors"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+	wirenet "perun.network/go-perun/wire/net"
+
+	"github.com/perun-network/perun-polkadot-demo/cmd/relay"
+)
+
+// relayDialer dials peers with a relay address through their relay and all
+// other peers with the wrapped dialer.
+type relayDialer struct {
+	dialer
+	relayed *hostDialer
+
+	mtx     sync.RWMutex                // Protects isRelay.
+	isRelay map[wallet.AddrKey]struct{} // Peers with relay address.
+}
+
+func newRelayDialer(d dialer, cert *tls.Certificate) *relayDialer {
+	return &relayDialer{
+		dialer:  d,
+		relayed: newHostDialer(relayDial(cert)),
+		isRelay: make(map[wallet.AddrKey]struct{}),
+	}
+}
+
+func relayDial(cert *tls.Certificate) func(context.Context, string, wire.Address) (wirenet.Conn, error) {
+	return func(ctx context.Context, addr string, peer wire.Address) (wirenet.Conn, error) {
+		host, id, err := relay.ParseURL(addr)
+		if err != nil {
+			return nil, err
+		}
+		if cert == nil {
+			return nil, errRelayWithoutTLS
+		}
+		conn, err := relay.Dial(ctx, host, id)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, clientTLSConfig(*cert, peer))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close() // nolint:errcheck,gosec
+			return nil, errors.Wrap(err, "TLS handshake")
+		}
+		return newAuthConn(tlsConn, peer), nil
+	}
+}
+
+// Register registers the address of a peer with the responsible dialer.
+func (d *relayDialer) Register(addr wire.Address, host string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if relay.IsURL(host) {
+		d.isRelay[wallet.Key(addr)] = struct{}{}
+		d.relayed.Register(addr, host)
+	} else {
+		delete(d.isRelay, wallet.Key(addr))
+		d.dialer.Register(addr, host)
+	}
+}
+
+// Unregister forgets the address of a peer.
+func (d *relayDialer) Unregister(addr wire.Address) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	delete(d.isRelay, wallet.Key(addr))
+	d.relayed.Unregister(addr)
+	d.dialer.Unregister(addr)
+}
+
+func (d *relayDialer) Dial(ctx context.Context, addr wire.Address) (wirenet.Conn, error) {
+	d.mtx.RLock()
+	_, ok := d.isRelay[wallet.Key(addr)]
+	d.mtx.RUnlock()
+	if ok {
+		return d.relayed.Dial(ctx, addr)
+	}
+	return d.dialer.Dial(ctx, addr)
+}
+
+func (d *relayDialer) Close() error {
+	err := d.dialer.Close()
+	if rerr := d.relayed.Close(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// relayListener accepts the wire connections that a relay forwards.
+type relayListener struct {
+	*relay.Listener
+	cfg *tls.Config
+}
+
+// errRelayWithoutTLS rejects relays without TLS. The wire handshake is not
+// signed, so the relay could impersonate the peers to each other otherwise.
+var errRelayWithoutTLS = errors.New("relays require TLS, enable node.tls, since the relay could impersonate the peers otherwise")
+
+// checkRelayPeer rejects the relay address `url` of a peer unless TLS is
+// enabled and, if `pinned` is set, the certificate of the peer is pinned.
+func checkRelayPeer(url, fingerprint string, pinned bool) error {
+	switch {
+	case !relay.IsURL(url):
+		return nil
+	case !config.Node.TLS.enabled():
+		return errRelayWithoutTLS
+	case pinned && fingerprint == "":
+		return errors.New("relay address requires a pinned certificate, set certFingerprint")
+	}
+	return nil
+}
+
+// newRelayListener registers at the relay `host` with the Perun key `sk`. The
+// relayed connections are secured with `cert`, which is required.
+func newRelayListener(host, sk string, cert *tls.Certificate) (*relayListener, error) {
+	if cert == nil {
+		return nil, errRelayWithoutTLS
+	}
+	key, err := sr25519.NewSKFromHex(sk)
+	if err != nil {
+		return nil, errors.WithMessage(err, "importing secret key")
+	}
+	l, err := relay.Listen(host, dotwallet.NewWallet().ImportSK(key), config.Node.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &relayListener{Listener: l, cfg: serverTLSConfig(*cert)}, nil
+}
+
+func (l *relayListener) Accept() (wirenet.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, errors.WithMessage(err, "accept failed")
+	}
+	return l.wrap(conn), nil
+}
+
+func (l *relayListener) wrap(conn net.Conn) wirenet.Conn {
+	return newAuthConn(tls.Server(conn, l.cfg), nil)
+}
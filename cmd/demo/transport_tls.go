@@ -0,0 +1,257 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"perun.network/go-perun/wire"
+	wirenet "perun.network/go-perun/wire/net"
+)
+
+type tlsConfig struct {
+	CertFile string
+	KeyFile  string
+}
+
+// enabled returns whether TLS is configured.
+func (c tlsConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// load loads the configured certificate and key.
+func (c tlsConfig) load() (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading TLS certificate")
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, errors.Wrap(err, "parsing TLS certificate")
+	}
+	return &cert, nil
+}
+
+// fingerprint returns the hex encoded SHA-256 hash of the certificate.
+func fingerprint(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(h[:])
+}
+
+// genCert generates a self-signed certificate for the given Perun ID and
+// returns the PEM encoded certificate and key.
+func genCert(perunID wire.Address) (certPEM, keyPEM []byte, err error) {
+	pub, sk, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "generating key")
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "generating serial number")
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		// The certificate is bound to the Perun ID of the node.
+		Subject:     pkix.Name{CommonName: perunID.String()},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().AddDate(10, 0, 0),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, sk)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "creating certificate")
+	}
+	skDer, err := x509.MarshalPKCS8PrivateKey(sk)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "encoding key")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: skDer}), nil
+}
+
+// verifyPeerCert checks that the certificate is pinned for the Perun ID that
+// it was issued to and returns that ID. If `expected` is not nil, the
+// certificate must be issued to it.
+func verifyPeerCert(rawCerts [][]byte, expected wire.Address) (wire.Address, error) {
+	if len(rawCerts) == 0 {
+		return nil, errors.New("no peer certificate")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing peer certificate")
+	}
+	id, err := strToAddress(cert.Subject.CommonName)
+	if err != nil {
+		return nil, errors.WithMessage(err, "certificate not issued to a Perun ID")
+	}
+	if expected != nil && !id.Equals(expected) {
+		return nil, errors.Errorf("certificate issued to %v, expected %v", id, expected)
+	}
+	alias, cfg := findConfig(id)
+	if cfg == nil {
+		return nil, errors.Errorf("unknown Perun ID %v", id)
+	}
+	if cfg.CertFingerprint == "" {
+		return nil, errors.Errorf("no certificate pinned for '%s'", alias)
+	}
+	if fp := fingerprint(cert); !strings.EqualFold(fp, cfg.CertFingerprint) {
+		return nil, errors.Errorf("certificate fingerprint %s does not match pinned one of '%s'", fp, alias)
+	}
+	return id, nil
+}
+
+// clientTLSConfig returns the TLS config for dialing `peer`.
+func clientTLSConfig(cert tls.Certificate, peer wire.Address) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// The chain is not verified against CAs since the peers use
+		// self-signed certificates. They are pinned instead.
+		InsecureSkipVerify: true, // nolint:gosec
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			_, err := verifyPeerCert(rawCerts, peer)
+			return err
+		},
+		MinVersion: tls.VersionTLS13,
+	}
+}
+
+// serverTLSConfig returns the TLS config for accepting peers.
+func serverTLSConfig(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			_, err := verifyPeerCert(rawCerts, nil)
+			return err
+		},
+		MinVersion: tls.VersionTLS13,
+	}
+}
+
+func newTLSDialer(cert tls.Certificate) *hostDialer {
+	return newHostDialer(func(ctx context.Context, host string, peer wire.Address) (wirenet.Conn, error) {
+		d := net.Dialer{Timeout: config.Node.DialTimeout}
+		raw, err := d.DialContext(ctx, "tcp", host)
+		if err != nil {
+			return nil, errors.Wrap(err, "dialing")
+		}
+		conn := tls.Client(raw, clientTLSConfig(cert, peer))
+		if err := conn.HandshakeContext(ctx); err != nil {
+			raw.Close() // nolint:errcheck,gosec
+			return nil, errors.Wrap(err, "TLS handshake")
+		}
+		return newAuthConn(conn, peer), nil
+	})
+}
+
+// tlsListener accepts TLS connections from peers with pinned certificates.
+type tlsListener struct {
+	net.Listener
+	cfg *tls.Config
+}
+
+func newTLSListener(host string, cert tls.Certificate) (*tlsListener, error) {
+	l, err := net.Listen("tcp", host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create listener for '%s'", host)
+	}
+	return &tlsListener{Listener: l, cfg: serverTLSConfig(cert)}, nil
+}
+
+// Accept accepts a connection. The TLS handshake is done by the first Send or
+// Recv call to not block the listener.
+func (l *tlsListener) Accept() (wirenet.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, errors.Wrap(err, "accept failed")
+	}
+	return newAuthConn(tls.Server(conn, l.cfg), nil), nil
+}
+
+// authConn is a wire connection over TLS which only accepts envelopes sent by
+// the Perun ID that the peer's certificate was issued to.
+type authConn struct {
+	wirenet.Conn
+	tls *tls.Conn
+
+	handshake sync.Once
+	err       error
+	peer      wire.Address
+}
+
+// newAuthConn wraps a TLS connection. `peer` can be nil if it is not known
+// yet, then it is read from the certificate after the handshake.
+func newAuthConn(conn *tls.Conn, peer wire.Address) *authConn {
+	return &authConn{
+		Conn: wirenet.NewIoConn(conn),
+		tls:  conn,
+		peer: peer,
+	}
+}
+
+func (c *authConn) doHandshake() error {
+	c.handshake.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), config.Node.HandleTimeout)
+		defer cancel()
+		if err := c.tls.HandshakeContext(ctx); err != nil {
+			c.err = errors.Wrap(err, "TLS handshake")
+			c.Conn.Close() // nolint:errcheck,gosec
+			return
+		}
+		if c.peer == nil {
+			// The certificate is verified during the handshake, so this only
+			// extracts the Perun ID.
+			raw := c.tls.ConnectionState().PeerCertificates[0].Raw
+			c.peer, c.err = verifyPeerCert([][]byte{raw}, nil)
+		}
+	})
+	return c.err
+}
+
+func (c *authConn) Send(e *wire.Envelope) error {
+	if err := c.doHandshake(); err != nil {
+		return err
+	}
+	return c.Conn.Send(e)
+}
+
+func (c *authConn) Recv() (*wire.Envelope, error) {
+	if err := c.doHandshake(); err != nil {
+		return nil, err
+	}
+	e, err := c.Conn.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if !e.Sender.Equals(c.peer) {
+		c.Conn.Close() // nolint:errcheck,gosec
+		return nil, errors.Errorf("envelope sender %v does not match certificate %v", e.Sender, c.peer)
+	}
+	return e, nil
+}
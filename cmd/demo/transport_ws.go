@@ -0,0 +1,223 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"perun.network/go-perun/log"
+	pkgsync "perun.network/go-perun/pkg/sync"
+	"perun.network/go-perun/wire"
+	wirenet "perun.network/go-perun/wire/net"
+)
+
+// wsConn is a wire connection over a WebSocket. Every envelope is sent as one
+// binary message.
+type wsConn struct {
+	ws *websocket.Conn
+	// peer is the Perun ID of the peer's TLS certificate or nil if TLS is
+	// disabled.
+	peer wire.Address
+
+	sendMtx sync.Mutex
+	closed  pkgsync.Closer
+}
+
+func newWSConn(ws *websocket.Conn, peer wire.Address) *wsConn {
+	return &wsConn{ws: ws, peer: peer}
+}
+
+func (c *wsConn) Send(e *wire.Envelope) error {
+	var buf bytes.Buffer
+	if err := e.Encode(&buf); err != nil {
+		return errors.WithMessage(err, "encoding envelope")
+	}
+	c.sendMtx.Lock()
+	defer c.sendMtx.Unlock()
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, buf.Bytes()); err != nil {
+		c.Close() // nolint:errcheck,gosec
+		return errors.Wrap(err, "writing message")
+	}
+	return nil
+}
+
+func (c *wsConn) Recv() (*wire.Envelope, error) {
+	typ, data, err := c.ws.ReadMessage()
+	if err != nil {
+		c.Close() // nolint:errcheck,gosec
+		return nil, errors.Wrap(err, "reading message")
+	} else if typ != websocket.BinaryMessage {
+		c.Close() // nolint:errcheck,gosec
+		return nil, errors.Errorf("unexpected message type %d", typ)
+	}
+
+	var e wire.Envelope
+	if err := e.Decode(bytes.NewReader(data)); err != nil {
+		c.Close() // nolint:errcheck,gosec
+		return nil, errors.WithMessage(err, "decoding envelope")
+	}
+	if c.peer != nil && !e.Sender.Equals(c.peer) {
+		c.Close() // nolint:errcheck,gosec
+		return nil, errors.Errorf("envelope sender %v does not match certificate %v", e.Sender, c.peer)
+	}
+	return &e, nil
+}
+
+func (c *wsConn) Close() error {
+	if err := c.closed.Close(); err != nil {
+		return err
+	}
+	return c.ws.Close()
+}
+
+// wsURL returns the URL of a peer. Hosts without scheme are completed to a
+// ws:// or wss:// URL depending on whether TLS is used.
+func wsURL(host string, secure bool) string {
+	if isWSURL(host) {
+		return host
+	}
+	if secure {
+		return "wss://" + host + "/"
+	}
+	return "ws://" + host + "/"
+}
+
+// isWSURL returns whether `addr` is a WebSocket URL instead of host:port.
+func isWSURL(addr string) bool {
+	return strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://")
+}
+
+// checkWSURL rejects a plain ws:// URL for a peer whose connection must be
+// secured because TLS is enabled or its certificate is pinned. Dialing it
+// would silently downgrade the connection.
+func checkWSURL(url string, secure bool, fingerprint string) error {
+	if !strings.HasPrefix(url, "ws://") {
+		return nil
+	}
+	if secure {
+		return errors.Errorf("URL %s is not secured although TLS is enabled, use wss://", url)
+	}
+	if fingerprint != "" {
+		return errors.Errorf("URL %s is not secured although the certificate is pinned, use wss://", url)
+	}
+	return nil
+}
+
+func newWSDialer(cert *tls.Certificate) *hostDialer {
+	return newHostDialer(func(ctx context.Context, host string, peer wire.Address) (wirenet.Conn, error) {
+		if err := checkWSURL(host, cert != nil, ""); err != nil {
+			return nil, err
+		}
+		d := websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: config.Node.DialTimeout,
+		}
+		var pinned wire.Address
+		if cert != nil {
+			d.TLSClientConfig = clientTLSConfig(*cert, peer)
+			pinned = peer
+		}
+		ws, _, err := d.DialContext(ctx, wsURL(host, cert != nil), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "dialing websocket")
+		}
+		return newWSConn(ws, pinned), nil
+	})
+}
+
+// wsListener accepts wire connections over WebSockets on any path.
+type wsListener struct {
+	srv   *http.Server
+	conns chan *wsConn
+
+	pkgsync.Closer
+}
+
+func newWSListener(host string, cert *tls.Certificate) (*wsListener, error) {
+	l, err := net.Listen("tcp", host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create listener for '%s'", host)
+	}
+	wl := &wsListener{conns: make(chan *wsConn)}
+	upgrader := websocket.Upgrader{
+		// Nodes are not accessed from websites, so the origin is irrelevant.
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	wl.srv = &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var peer wire.Address
+			if r.TLS != nil {
+				var err error
+				// The certificate was verified during the TLS handshake.
+				if peer, err = verifyPeerCert([][]byte{r.TLS.PeerCertificates[0].Raw}, nil); err != nil {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
+			}
+			ws, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				log.WithError(err).Debug("Upgrading websocket")
+				return
+			}
+			select {
+			case wl.conns <- newWSConn(ws, peer):
+			case <-wl.Closed():
+				ws.Close() // nolint:errcheck,gosec
+			}
+		}),
+		ReadHeaderTimeout: config.Node.HandleTimeout,
+	}
+
+	if cert != nil {
+		wl.srv.TLSConfig = serverTLSConfig(*cert)
+	}
+
+	go func() {
+		var err error
+		if cert != nil {
+			err = wl.srv.ServeTLS(l, "", "")
+		} else {
+			err = wl.srv.Serve(l)
+		}
+		if err != http.ErrServerClosed {
+			log.WithError(err).Error("Websocket listener stopped")
+		}
+	}()
+	return wl, nil
+}
+
+func (l *wsListener) Accept() (wirenet.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.Closed():
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *wsListener) Close() error {
+	if err := l.Closer.Close(); err != nil {
+		return err
+	}
+	return l.srv.Close()
+}
@@ -0,0 +1,558 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	prompt "github.com/c-bata/go-prompt"
+	runewidth "github.com/mattn/go-runewidth"
+	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"perun.network/go-perun/log"
+	plogrus "perun.network/go-perun/log/logrus"
+)
+
+const (
+	// tuiEvents is the number of lines that the event log keeps.
+	tuiEvents = 1000
+	// tuiRefresh is the interval in which the dashboard is redrawn without
+	// events, e.g. for the liveness of the peers.
+	tuiRefresh = time.Second
+	// tuiChainRefresh is the interval in which the on-chain balance is
+	// queried without events.
+	tuiChainRefresh = 10 * time.Second
+)
+
+// ui is the full-screen dashboard. It is only running with --tui.
+var ui = new(tui)
+
+// tui is a full-screen dashboard with panes for the peers and channels, the
+// event log, the on-chain balance and a command line. The output of the
+// commands, the asynchronous events and the log are shown in the event log.
+type tui struct {
+	in  prompt.ConsoleParser
+	out prompt.ConsoleWriter
+
+	// stdout is the output before it was redirected to the event log.
+	stdout io.Writer
+	logOut io.Writer // Output of the logger before it was redirected.
+
+	redraw  chan struct{}
+	queryCh chan struct{}
+	done    chan struct{}
+
+	mtx     sync.Mutex
+	running bool
+	events  []string
+	pending []byte // Incomplete last line of the output, e.g. a question.
+	scroll  int    // Number of events scrolled back.
+	buf     *prompt.Buffer
+	hint    string // Completions or the help of the keys.
+	onChain string
+	// The state of browsing the command history with the arrow keys.
+	histPos int
+	draft   string
+}
+
+var tuiKeysHelp = "Tab complete · ↑↓ history · Ctrl-R search · PgUp/PgDn scroll · Ctrl-D exit"
+
+// runTUI shows the dashboard and executes the entered commands until the
+// user exits with Ctrl-C or Ctrl-D.
+func runTUI() {
+	t := ui
+	t.in, t.out = prompt.NewStandardInputParser(), prompt.NewStdoutWriter()
+	t.redraw = make(chan struct{}, 1)
+	t.queryCh = make(chan struct{}, 1)
+	t.done = make(chan struct{})
+	t.buf, t.hint, t.onChain = prompt.NewBuffer(), tuiKeysHelp, "…"
+	if err := t.start(); err != nil {
+		log.WithError(err).Fatal("Could not start the terminal UI")
+	}
+	go handleSignals(t.in)
+	go t.render()
+	go t.queryOnChain()
+
+	for {
+		b, err := t.in.Read()
+		if err != nil || len(b) == 0 || (len(b) == 1 && b[0] == 0) { // 0 means no input
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if line, ok, quit := t.handleKey(b); quit {
+			return
+		} else if ok {
+			t.echo(line)
+			executor(line)
+		}
+		t.update()
+	}
+}
+
+// start switches the terminal to the alternate screen and redirects the
+// standard output and the log to the event log.
+func (t *tui) start() error {
+	if err := t.in.Setup(); err != nil {
+		return err
+	}
+	t.stdout = stdout.redirect(&tuiWriter{t: t, stdout: true})
+	// The logger is only redirected if it writes to the terminal.
+	if l, ok := log.Get().(*plogrus.Logger); ok && l.Logger.Out == os.Stderr {
+		t.logOut = l.Logger.Out
+		l.Logger.SetOutput(&tuiWriter{t: t})
+	}
+
+	t.out.WriteRawStr("\x1b[?1049h") // Alternate screen.
+	t.out.SetTitle("perun")
+	t.mtx.Lock()
+	t.running = true
+	t.mtx.Unlock()
+	t.update()
+	return t.out.Flush()
+}
+
+// close restores the terminal and the output. It is called on exit.
+func (t *tui) close() {
+	t.mtx.Lock()
+	running := t.running
+	t.running = false
+	t.mtx.Unlock()
+	if !running {
+		return
+	}
+	close(t.done)
+
+	if l, ok := log.Get().(*plogrus.Logger); ok && t.logOut != nil {
+		l.Logger.SetOutput(t.logOut)
+	}
+	stdout.redirect(t.stdout)
+
+	t.out.WriteRawStr("\x1b[?1049l")
+	t.out.ClearTitle()
+	t.out.ShowCursor()
+	if err := t.out.Flush(); err != nil {
+		log.WithError(err).Warn("Restoring the screen")
+	}
+	if err := t.in.TearDown(); err != nil {
+		log.WithError(err).Warn("Restoring the terminal")
+	}
+}
+
+// isRunning returns whether the dashboard is shown.
+func (t *tui) isRunning() bool {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.running
+}
+
+// tuiWriter splits the output into lines for the event log. It is not safe
+// for concurrent use, stdout serializes the writes.
+type tuiWriter struct {
+	t       *tui
+	partial []byte
+	// stdout shows the incomplete line of the standard output, e.g. a
+	// question of Prompt, in the event log.
+	stdout bool
+}
+
+func (w *tuiWriter) Write(p []byte) (int, error) {
+	w.t.mtx.Lock()
+	if w.stdout {
+		w.partial = w.t.pending
+	}
+	lines := bytes.Split(append(w.partial, p...), []byte{'\n'})
+	w.partial = append([]byte(nil), lines[len(lines)-1]...)
+	if w.stdout {
+		w.t.pending = w.partial
+	}
+	w.t.mtx.Unlock()
+
+	for _, line := range lines[:len(lines)-1] {
+		w.t.event(string(line))
+	}
+	return len(p), nil
+}
+
+// ansiEscape matches the color codes of the output.
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;?]*[A-Za-z]")
+
+func cleanLine(line string) string {
+	line = ansiEscape.ReplaceAllString(line, "")
+	line = strings.ReplaceAll(line, "\t", "    ")
+	return strings.TrimRight(line, "\r")
+}
+
+// event adds a line to the event log. Node events also refresh the on-chain
+// balance, since funding and settling change it.
+func (t *tui) event(line string) {
+	line = time.Now().Format("15:04:05 ") + cleanLine(line)
+	t.mtx.Lock()
+	t.events = append(t.events, line)
+	if len(t.events) > tuiEvents {
+		t.events = t.events[len(t.events)-tuiEvents:]
+	}
+	if t.scroll > 0 { // Keep the scrolled view.
+		t.scroll = min(t.scroll+1, len(t.events)-1)
+	}
+	t.mtx.Unlock()
+	t.update()
+	select {
+	case t.queryCh <- struct{}{}:
+	default:
+	}
+}
+
+// echo adds an entered line to the event log, after the question of Prompt
+// that it answers.
+func (t *tui) echo(line string) {
+	t.mtx.Lock()
+	echo := "> " + line
+	if len(t.pending) > 0 {
+		echo, t.pending = cleanLine(string(t.pending))+line, nil
+	}
+	t.mtx.Unlock()
+	t.event(echo)
+}
+
+// update requests a redraw.
+func (t *tui) update() {
+	select {
+	case t.redraw <- struct{}{}:
+	default:
+	}
+}
+
+// queryOnChain queries the on-chain balance of the own account after events
+// and periodically.
+func (t *tui) queryOnChain() {
+	tick := time.NewTicker(tuiChainRefresh)
+	defer tick.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Chain.TxTimeoutSec)*time.Second)
+		bals, err := backend.core.OnChainBalance(ctx, backend.core.Address())
+		cancel()
+		bal := "unknown"
+		if err == nil {
+			bal = dot.NewDotsFromPlanks(bals...)[0].String()
+		}
+		t.mtx.Lock()
+		t.onChain = bal
+		t.mtx.Unlock()
+		t.update()
+
+		select {
+		case <-t.done:
+			return
+		case <-t.queryCh:
+		case <-tick.C:
+		}
+	}
+}
+
+// render redraws the dashboard on events and periodically.
+func (t *tui) render() {
+	tick := time.NewTicker(tuiRefresh)
+	defer tick.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-t.redraw:
+		case <-tick.C:
+		}
+		t.draw()
+	}
+}
+
+// draw draws the whole screen. The peers are queried before locking t.mtx,
+// because the node may log while holding node.mtx.
+func (t *tui) draw() {
+	channels := channelLines()
+	size := t.in.GetWinSize()
+	rows, cols := int(size.Row), int(size.Col)
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if !t.running || rows < 8 || cols < 20 {
+		return
+	}
+	line := 1
+	put := func(attr, s string) {
+		t.out.CursorGoTo(line, 1)
+		if attr != "" {
+			t.out.WriteRawStr(attr)
+		}
+		t.out.WriteStr(truncate(s, cols))
+		t.out.EraseEndOfLine()
+		if attr != "" {
+			t.out.WriteRawStr("\x1b[0m")
+		}
+		line++
+	}
+
+	t.out.HideCursor()
+	title := fmt.Sprintf(" 🔑 %s · On-chain: %s", config.Alias, t.onChain)
+	clock := time.Now().Format("15:04:05 ")
+	pad := cols - runewidth.StringWidth(title) - runewidth.StringWidth(clock)
+	put("\x1b[7m", title+strings.Repeat(" ", max(pad, 1))+clock)
+
+	// The channels take at most a third of the screen.
+	put("\x1b[1m", "Peers & channels")
+	limit := max(rows/3-1, 2)
+	if len(channels) > limit {
+		more := len(channels) - limit + 1
+		channels = append(channels[:limit-1], fmt.Sprintf("… %d more, see 'info'", more))
+	}
+	for _, c := range channels {
+		put("", c)
+	}
+
+	title = "Events"
+	if t.scroll > 0 {
+		title = fmt.Sprintf("Events (%d newer below)", t.scroll)
+	}
+	put("\x1b[1m", title)
+	logRows := rows - line - 1 // The hint and the command line.
+	for _, l := range t.logLines(logRows, cols) {
+		put("", l)
+	}
+	for line <= rows-2 {
+		put("", "")
+	}
+
+	put("\x1b[2m", t.hint)
+	t.out.CursorGoTo(rows, 1)
+	doc := t.buf.Document()
+	// The command line scrolls horizontally with the cursor.
+	text, before := doc.Text, doc.TextBeforeCursor()
+	shift := 0
+	for runewidth.StringWidth(before[shift:]) > cols-3 {
+		_, n := utf8.DecodeRuneInString(before[shift:])
+		shift += n
+	}
+	t.out.WriteStr("> " + truncate(text[shift:], cols-2))
+	t.out.EraseEndOfLine()
+	t.out.CursorGoTo(rows, 3+runewidth.StringWidth(before[shift:]))
+	t.out.ShowCursor()
+	if err := t.out.Flush(); err != nil {
+		log.WithError(err).Debug("Drawing the terminal UI")
+	}
+}
+
+// logLines returns the last `n` rows of the event log wrapped at `cols`, up
+// to the scrolled event. Assumes that t.mtx is held.
+func (t *tui) logLines(n, cols int) []string {
+	events := t.events[:len(t.events)-t.scroll]
+	if len(t.pending) > 0 && t.scroll == 0 {
+		events = append(events[:len(events):len(events)], cleanLine(string(t.pending)))
+	}
+	var lines []string
+	for i := len(events) - 1; i >= 0 && len(lines) < n; i-- {
+		wrapped := wrap(events[i], cols)
+		for j := len(wrapped) - 1; j >= 0 && len(lines) < n; j-- {
+			lines = append(lines, wrapped[j])
+		}
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
+
+// channelLines returns the table of the peers with their liveness and the
+// phase, version and balances of their channel.
+func channelLines() []string {
+	states := backend.peerStates()
+	if len(states) == 0 {
+		return []string{"No peers connected, use 'connect' or 'open'."}
+	}
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "Peer\tStatus\tPhase\tVersion\tMy D\tPeer D\n")
+	for _, s := range states {
+		if c := s.Channel; c != nil {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", s.Alias, s.Status, c.Phase, c.Version, c.MyBalance, c.PeerBalance)
+		} else {
+			fmt.Fprintf(w, "%s\t%s\tConnected\t\t\t\n", s.Alias, s.Status)
+		}
+	}
+	w.Flush()
+	return strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+}
+
+// handleKey edits the command line. It returns the entered line if the key
+// was Enter and whether the user exits.
+func (t *tui) handleKey(b []byte) (line string, entered, quit bool) {
+	key := prompt.GetKey(b)
+	// The completer locks the node, which may log while holding its lock.
+	var suggests []prompt.Suggest
+	if key == prompt.Tab {
+		t.mtx.Lock()
+		doc := *t.buf.Document()
+		t.mtx.Unlock()
+		suggests = completer(doc)
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if key != prompt.Tab {
+		t.hint = tuiKeysHelp
+	}
+	switch key {
+	case prompt.Enter, prompt.ControlJ, prompt.ControlM:
+		line = t.buf.Text()
+		t.buf = prompt.NewBuffer()
+		t.histPos, t.scroll = 0, 0
+		return line, true, false
+	case prompt.ControlC:
+		return "", false, true
+	case prompt.ControlD:
+		if t.buf.Text() == "" {
+			return "", false, true
+		}
+		t.buf.Delete(1)
+	case prompt.Backspace, prompt.ControlH:
+		t.buf.DeleteBeforeCursor(1)
+	case prompt.Delete:
+		t.buf.Delete(1)
+	case prompt.Left, prompt.ControlB:
+		t.buf.CursorLeft(1)
+	case prompt.Right, prompt.ControlF:
+		t.buf.CursorRight(1)
+	case prompt.Home, prompt.ControlA:
+		t.buf.CursorLeft(len([]rune(t.buf.Document().TextBeforeCursor())))
+	case prompt.End, prompt.ControlE:
+		t.buf.CursorRight(len([]rune(t.buf.Document().TextAfterCursor())))
+	case prompt.ControlU:
+		t.buf = prompt.NewBuffer()
+	case prompt.ControlW:
+		t.buf.DeleteBeforeCursor(len([]rune(t.buf.Document().GetWordBeforeCursorWithSpace())))
+	case prompt.ControlR:
+		cmds.search(t.buf)
+	case prompt.Up, prompt.ControlP:
+		t.browseHistory(1)
+	case prompt.Down, prompt.ControlN:
+		t.browseHistory(-1)
+	case prompt.PageUp:
+		t.scroll = min(t.scroll+10, max(len(t.events)-1, 0))
+	case prompt.PageDown:
+		t.scroll = max(t.scroll-10, 0)
+	case prompt.Tab:
+		t.complete(suggests)
+	case prompt.NotDefined:
+		if text := string(b); !strings.ContainsAny(text, "\x1b\x00") {
+			t.buf.InsertText(strings.ReplaceAll(text, "\n", " "), false, true)
+		}
+	}
+	return "", false, false
+}
+
+// browseHistory replaces the command line with the `delta`th older command.
+// Assumes that t.mtx is held.
+func (t *tui) browseHistory(delta int) {
+	lines := cmds.all()
+	pos := t.histPos + delta
+	if pos < 0 || pos > len(lines) {
+		return
+	}
+	if t.histPos == 0 {
+		t.draft = t.buf.Text()
+	}
+	t.histPos = pos
+	text := t.draft
+	if pos > 0 {
+		text = lines[len(lines)-pos]
+	}
+	t.buf = prompt.NewBuffer()
+	t.buf.InsertText(text, false, true)
+}
+
+// complete completes the word before the cursor to the longest common prefix
+// of the suggestions and shows them in the hint line. Assumes that t.mtx is
+// held.
+func (t *tui) complete(suggests []prompt.Suggest) {
+	doc := t.buf.Document()
+	var texts, shown []string
+	for _, s := range suggests {
+		shown = append(shown, s.Text)
+		if !strings.HasPrefix(s.Text, "<") { // Only a hint.
+			texts = append(texts, s.Text)
+		}
+	}
+	t.hint = "No completions"
+	if len(shown) > 0 {
+		t.hint = strings.Join(shown, "  ")
+	}
+	if len(texts) == 0 {
+		return
+	}
+	common := texts[0]
+	for _, text := range texts[1:] {
+		for !strings.HasPrefix(text, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(texts) == 1 {
+		common += " "
+	}
+	word := doc.GetWordBeforeCursor()
+	if len(common) > len(word) {
+		t.buf.DeleteBeforeCursor(len([]rune(word)))
+		t.buf.InsertText(common, false, true)
+	}
+}
+
+// truncate cuts `s` to `width` columns.
+func truncate(s string, width int) string {
+	return runewidth.Truncate(s, width, "")
+}
+
+// wrap splits `s` into lines of at most `width` columns.
+func wrap(s string, width int) []string {
+	var lines []string
+	for runewidth.StringWidth(s) > width {
+		line := truncate(s, width)
+		if line == "" { // A rune wider than the screen.
+			_, n := utf8.DecodeRuneInString(s)
+			line = s[:n]
+		}
+		lines = append(lines, line)
+		s = s[len(line):]
+	}
+	return append(lines, s)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
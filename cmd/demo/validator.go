@@ -15,13 +15,22 @@
 package demo
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"math/big"
+	"net/url"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	sr25519 "github.com/perun-network/perun-polkadot-backend/pkg/sr25519"
 	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
 	dotwallet "github.com/perun-network/perun-polkadot-backend/wallet/sr25519"
 	"github.com/pkg/errors"
+
+	"github.com/perun-network/perun-polkadot-demo/cmd/relay"
+	pnode "github.com/perun-network/perun-polkadot-demo/pkg/node"
 )
 
 func valBal(input string) error {
@@ -38,6 +47,13 @@ func valUInt(input string) error {
 	return nil
 }
 
+func valString(arg string) error {
+	if arg == "" {
+		return errors.New("Value must not be empty")
+	}
+	return nil
+}
+
 func valPeer(arg string) error {
 	if !backend.ExistsPeer(arg) {
 		return errors.Errorf("Unknown peer, use 'info' to see connected")
@@ -46,12 +62,143 @@ func valPeer(arg string) error {
 }
 
 func valAlias(arg string) error {
-	for alias := range config.Peers {
-		if alias == arg {
+	if _, ok := lookupPeerCfg(arg); ok {
+		return nil
+	}
+	return errors.Errorf("Unknown alias, use 'config' to see available")
+}
+
+func valAddress(arg string) error {
+	_, err := strToAddress(arg)
+	return errors.WithMessage(err, "parsing Perun ID")
+}
+
+func valHost(arg string) error {
+	if relay.IsURL(arg) {
+		_, _, err := relay.ParseURL(arg)
+		return err
+	}
+	if isWSURL(arg) {
+		if transport() != transportWS {
+			return errors.New("WebSocket URLs require the ws transport")
+		}
+		_, err := url.Parse(arg)
+		return errors.Wrap(err, "parsing URL")
+	}
+	_, _, err := parseHostPort(arg)
+	return err
+}
+
+func valFingerprint(arg string) error {
+	if b, err := hex.DecodeString(arg); err != nil || len(b) != sha256.Size {
+		return errors.New("Expected hex encoded SHA-256 fingerprint")
+	}
+	return nil
+}
+
+func valMemo(arg string) error {
+	if len(arg) > pnode.MaxMemoLen {
+		return errors.Errorf("Memo must not be longer than %d bytes", pnode.MaxMemoLen)
+	}
+	return nil
+}
+
+func valExportFormat(arg string) error {
+	if arg != "--csv" && arg != "--json" {
+		return errors.New("Expected --csv or --json")
+	}
+	return nil
+}
+
+func valSince(arg string) error {
+	_, err := parseSince(arg)
+	return err
+}
+
+// valChallenge accepts challenge durations of at least one second.
+func valChallenge(arg string) error {
+	if d, err := time.ParseDuration(arg); err != nil {
+		return errors.New("Expected a duration like 120s")
+	} else if d < time.Second {
+		return errors.New("Challenge duration must be at least 1s")
+	}
+	return nil
+}
+
+func valTimeout(arg string) error {
+	if d, err := time.ParseDuration(arg); err != nil {
+		return errors.New("Expected a duration like 30s")
+	} else if d <= 0 {
+		return errors.New("Timeout must be positive")
+	}
+	return nil
+}
+
+func valNonce(arg string) error {
+	_, err := parseNonce(arg)
+	return err
+}
+
+// valBenchPeers accepts "all" or a comma separated list of peers.
+func valBenchPeers(arg string) error {
+	if arg == "all" {
+		return nil
+	}
+	for _, alias := range strings.Split(arg, ",") {
+		if err := valPeer(alias); err != nil {
+			return errors.WithMessagef(err, "peer '%s'", alias)
+		}
+	}
+	return nil
+}
+
+func valWorkers(arg string) error {
+	if n, err := strconv.Atoi(arg); err != nil {
+		return errors.New("Invalid integer")
+	} else if n < 1 {
+		return errors.New("Must be at least 1")
+	}
+	return nil
+}
+
+func valRate(arg string) error {
+	if r, err := strconv.ParseFloat(arg, 64); err != nil {
+		return errors.New("Invalid number")
+	} else if r < 0 {
+		return errors.New("Must not be negative")
+	}
+	return nil
+}
+
+func valBenchOut(arg string) error {
+	if ext := filepath.Ext(arg); ext != ".json" && ext != ".csv" {
+		return errors.New("Expected a .json or .csv file")
+	}
+	return nil
+}
+
+func valThreshold(arg string) error {
+	return valRate(arg)
+}
+
+func valAlpha(arg string) error {
+	if a, err := strconv.ParseFloat(arg, 64); err != nil {
+		return errors.New("Invalid number")
+	} else if a <= 0 || a >= 1 {
+		return errors.New("Must be between 0 and 1")
+	}
+	return nil
+}
+
+// optional makes an argument optional. Omitted arguments are passed as empty
+// strings.
+func optional(validator func(string) error) func(string) error {
+	return func(arg string) error {
+		if arg == "" {
 			return nil
 		}
+		return validator(arg)
 	}
-	return errors.Errorf("Unknown alias, use 'config' to see available")
 }
 
 // strToAddress parses a string as dotwallet.Address
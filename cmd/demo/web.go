@@ -0,0 +1,540 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package demo
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/pkg/errors"
+)
+
+const (
+	// webRefresh is the interval in which the web UI receives the state
+	// without events.
+	webRefresh = 2 * time.Second
+	// webChainRefresh is the interval in which the on-chain balance is
+	// queried without events.
+	webChainRefresh = 10 * time.Second
+	// webMaxBody is the maximal size of a request body.
+	webMaxBody = 1 << 16
+	// webTokenCookie is the cookie that carries the token of the web UI.
+	webTokenCookie = "perun-token"
+)
+
+// webAssets are the static files of the web UI.
+//
+//go:embed web
+var webAssets embed.FS
+
+type (
+	// webState is the state shown by the web UI.
+	webState struct {
+		Alias     string         `json:"alias"`
+		PerunID   string         `json:"perunID"`
+		OnChain   string         `json:"onChainBalance"`
+		Peers     []peerState    `json:"peers"`
+		Known     []string       `json:"knownPeers"`
+		Proposals []proposalView `json:"proposals"`
+		History   bool           `json:"history"`
+	}
+
+	// proposalView is a pending channel proposal as shown by the web UI.
+	proposalView struct {
+		ID          uint64    `json:"id"`
+		Peer        string    `json:"peer"`
+		PeerID      string    `json:"peerID"`
+		Unknown     bool      `json:"unknown"`
+		MyBalance   string    `json:"myBalance"`
+		PeerBalance string    `json:"peerBalance"`
+		Received    time.Time `json:"received"`
+	}
+
+	// webMessage is sent to the web UI over the WebSocket, either an event
+	// or the state.
+	webMessage struct {
+		Type  string    `json:"type"`
+		Time  time.Time `json:"time"`
+		Text  string    `json:"text,omitempty"`
+		State *webState `json:"state,omitempty"`
+	}
+
+	// webBalance caches the own on-chain balance for all web UIs, so that
+	// they do not query the chain on every refresh.
+	webBalance struct {
+		mtx     sync.Mutex
+		onChain string
+		queryCh chan struct{}
+	}
+
+	// webHub forwards the asynchronous events to the connected web UIs.
+	webHub struct {
+		mtx     sync.Mutex
+		clients map[chan string]struct{}
+	}
+
+	// apiFunc handles an API request and returns the JSON response.
+	apiFunc func(r *http.Request) (interface{}, error)
+
+	// apiError is an error with the HTTP status of the response.
+	apiError struct {
+		status int
+		error
+	}
+)
+
+// webEvents are the events for the web UI.
+var webEvents = &webHub{clients: make(map[chan string]struct{})}
+
+// serveWeb serves the web UI and its API on `addr`. The API requires a random
+// token that is part of the URL printed on start.
+func (n *node) serveWeb(addr string) error {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return errors.Wrap(err, "generating web UI token")
+	}
+	n.webToken = hex.EncodeToString(token)
+	assets, err := fs.Sub(webAssets, "web")
+	if err != nil {
+		return errors.Wrap(err, "loading web assets")
+	}
+	// Some systems map .js to text/plain, which browsers refuse to run.
+	if err := mime.AddExtensionType(".js", "application/javascript"); err != nil {
+		return errors.Wrap(err, "registering mime type")
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "listening for web UI")
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(assets)))
+	mux.Handle("/api/state", get(func(*http.Request) (interface{}, error) { return n.webState(), nil }))
+	mux.Handle("/api/history", get(n.webHistory))
+	mux.Handle("/api/open", post(n.webOpen))
+	mux.Handle("/api/send", post(n.webSend))
+	mux.Handle("/api/close", post(n.webClose))
+	mux.Handle("/api/proposals", post(n.webDecide))
+	mux.HandleFunc("/api/events", n.webStream)
+	srv := &http.Server{Handler: n.authorize(addr, mux), ReadHeaderTimeout: config.Node.HandleTimeout}
+	go func() {
+		<-n.ctx.Done()
+		srv.Close() // nolint:errcheck,gosec
+	}()
+	go func() {
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			n.log.WithError(err).Error("Web server stopped")
+		}
+	}()
+	go n.queryWebBalance()
+	n.log.WithField("addr", l.Addr()).Info("Serving web UI")
+	return nil
+}
+
+// webState returns the current state for the web UI.
+func (n *node) webState() *webState {
+	s := &webState{
+		Alias:     config.Alias,
+		PerunID:   n.core.Address().String(),
+		OnChain:   n.webBalance.get(),
+		Peers:     n.peerStates(),
+		Known:     knownAliases(),
+		Proposals: n.proposalViews(),
+		History:   n.history != nil,
+	}
+	return s
+}
+
+// queryWebBalance queries the on-chain balance of the own account for the web
+// UIs after node events and periodically.
+func (n *node) queryWebBalance() {
+	b := n.webBalance
+	tick := time.NewTicker(webChainRefresh)
+	defer tick.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(n.ctx, time.Duration(config.Chain.TxTimeoutSec)*time.Second)
+		bals, err := n.core.OnChainBalance(ctx, n.core.Address())
+		cancel()
+		bal := "unknown"
+		if err == nil {
+			bal = dot.NewDotFromPlank(bals[0]).String()
+		}
+		b.mtx.Lock()
+		b.onChain = bal
+		b.mtx.Unlock()
+
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-b.queryCh:
+		case <-tick.C:
+		}
+	}
+}
+
+func newWebBalance() *webBalance {
+	return &webBalance{onChain: "unknown", queryCh: make(chan struct{}, 1)}
+}
+
+func (b *webBalance) get() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.onChain
+}
+
+// refresh requests a new query of the balance. It does nothing if the web UI
+// is disabled.
+func (b *webBalance) refresh() {
+	if b == nil {
+		return
+	}
+	select {
+	case b.queryCh <- struct{}{}:
+	default:
+	}
+}
+
+// proposalViews returns the pending proposals, the oldest first.
+func (n *node) proposalViews() []proposalView {
+	proposals := n.core.Proposals()
+	views := make([]proposalView, len(proposals))
+	for i, p := range proposals {
+		views[i] = proposalView{
+			ID:          p.ID,
+			Peer:        p.Peer,
+			PeerID:      p.PeerID.String(),
+			Unknown:     p.Unknown,
+			MyBalance:   dot.NewDotFromPlank(p.MyBalance).String(),
+			PeerBalance: dot.NewDotFromPlank(p.PeerBalance).String(),
+			Received:    p.Received,
+		}
+	}
+	return views
+}
+
+// webHistory returns the history entries, optionally filtered by the peer
+// and since parameters like the 'history' command.
+func (n *node) webHistory(r *http.Request) (interface{}, error) {
+	if n.history == nil {
+		return nil, &apiError{http.StatusNotFound, errors.New("History disabled, enable it with 'node.historyPath'")}
+	}
+	filter := historyFilter{peer: r.URL.Query().Get("peer")}
+	if since := r.URL.Query().Get("since"); since != "" {
+		var err error
+		if filter.since, err = parseSince(since); err != nil {
+			return nil, &apiError{http.StatusBadRequest, err}
+		}
+	}
+	entries, err := n.history.entries(filter)
+	if entries == nil {
+		entries = []historyEntry{}
+	}
+	return entries, err
+}
+
+func (n *node) webOpen(r *http.Request) (interface{}, error) {
+	var req struct {
+		Peer        string `json:"peer"`
+		MyBalance   string `json:"myBalance"`
+		PeerBalance string `json:"peerBalance"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	return nil, runCommand("open", map[string]string{"Peer": req.Peer, "Our Balance": req.MyBalance, "Their Balance": req.PeerBalance})
+}
+
+func (n *node) webSend(r *http.Request) (interface{}, error) {
+	var req struct {
+		Peer   string `json:"peer"`
+		Amount string `json:"amount"`
+		Memo   string `json:"memo"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	return nil, runCommand("send", map[string]string{"Peer": req.Peer, "Amount": req.Amount, "Memo": req.Memo})
+}
+
+func (n *node) webClose(r *http.Request) (interface{}, error) {
+	var req struct {
+		Peer string `json:"peer"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	return nil, runCommand("close", map[string]string{"Peer": req.Peer})
+}
+
+// webDecide accepts or rejects a pending channel proposal.
+func (n *node) webDecide(r *http.Request) (interface{}, error) {
+	var req struct {
+		ID     uint64 `json:"id"`
+		Accept bool   `json:"accept"`
+	}
+	if err := decodeRequest(r, &req); err != nil {
+		return nil, err
+	}
+	p, ok := n.pendingProposal(req.ID)
+	if !ok {
+		return nil, &apiError{http.StatusNotFound, errors.Errorf("Proposal %d is not pending", req.ID)}
+	}
+	verb := "Rejecting"
+	if req.Accept {
+		verb = "Accepting"
+	}
+	PrintfAsync("🌐 %s the channel proposal from %s in the web UI.\n", verb, p.Peer)
+	if !n.decide(req.ID, req.Accept) {
+		return nil, &apiError{http.StatusNotFound, errors.Errorf("Proposal %d is not pending", req.ID)}
+	}
+	return nil, nil
+}
+
+// runCommand runs a command with the given values of its arguments by name as
+// if it was entered on the command line. The values are not parsed, so they
+// cannot set flags. Omitted arguments take their default.
+func runCommand(name string, given map[string]string) error {
+	cmd, _, ok := findCommand(strings.Fields(name))
+	if !ok {
+		return errors.Errorf("Unknown command: %s", name)
+	}
+	values := make([]string, len(cmd.Args))
+	var shown []string
+	for i, a := range cmd.Args {
+		v, ok := given[a.Name]
+		if !ok {
+			v = a.Default
+		} else if v != "" {
+			shown = append(shown, v)
+		}
+		if err := cmd.validate(a, v); err != nil {
+			return &apiError{http.StatusBadRequest, err}
+		}
+		values[i] = v
+	}
+	PrintfAsync("🌐 %s %s\n", name, strings.Join(shown, " "))
+	return cmd.Function(values)
+}
+
+// webStream sends the events and the state to a web UI over a WebSocket.
+func (n *node) webStream(w http.ResponseWriter, r *http.Request) {
+	// The default upgrader only accepts connections from the web UI itself.
+	var upgrader websocket.Upgrader
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		n.log.WithError(err).Debug("Upgrading web UI connection")
+		return
+	}
+	defer conn.Close() // nolint:errcheck,gosec
+	events := webEvents.subscribe()
+	defer webEvents.unsubscribe(events)
+
+	// The web UI does not send messages, reading detects the closing.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(msg webMessage) bool {
+		msg.Time = time.Now()
+		conn.SetWriteDeadline(time.Now().Add(config.Node.HandleTimeout)) // nolint:errcheck,gosec
+		if err := conn.WriteJSON(msg); err != nil {
+			n.log.WithError(err).Debug("Sending to web UI")
+			return false
+		}
+		return true
+	}
+	tick := time.NewTicker(webRefresh)
+	defer tick.Stop()
+	for send(webMessage{Type: "state", State: n.webState()}) {
+		select {
+		case <-closed:
+			return
+		case <-n.ctx.Done():
+			return
+		case text := <-events:
+			if !send(webMessage{Type: "event", Text: text}) {
+				return
+			}
+		case <-tick.C:
+		}
+	}
+}
+
+func (h *webHub) subscribe() chan string {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	c := make(chan string, 64)
+	h.clients[c] = struct{}{}
+	return c
+}
+
+func (h *webHub) unsubscribe(c chan string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	delete(h.clients, c)
+}
+
+// publish sends an event to all web UIs. Slow web UIs miss events.
+func (h *webHub) publish(text string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for c := range h.clients {
+		select {
+		case c <- text:
+		default:
+		}
+	}
+}
+
+// get only accepts GET requests.
+func get(f apiFunc) apiFunc {
+	return func(r *http.Request) (interface{}, error) {
+		if r.Method != http.MethodGet {
+			return nil, &apiError{http.StatusMethodNotAllowed, errors.Errorf("%s not allowed", r.Method)}
+		}
+		return f(r)
+	}
+}
+
+// post only accepts POST requests with a JSON body. Other websites cannot
+// send such requests without the consent of the node, see CORS.
+func post(f apiFunc) apiFunc {
+	return func(r *http.Request) (interface{}, error) {
+		if r.Method != http.MethodPost {
+			return nil, &apiError{http.StatusMethodNotAllowed, errors.Errorf("%s not allowed", r.Method)}
+		} else if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/json" {
+			return nil, &apiError{http.StatusUnsupportedMediaType, errors.New("Expected application/json")}
+		}
+		return f(r)
+	}
+}
+
+func (f apiFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	res, err := f(r)
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			status = apiErr.status
+		}
+		res = map[string]string{"error": err.Error()}
+	} else if res == nil {
+		res = struct{}{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		backend.log.WithError(err).Debug("Writing API response")
+	}
+}
+
+// decodeRequest decodes the JSON body of a request into `v`.
+func decodeRequest(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, webMaxBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return &apiError{http.StatusBadRequest, errors.Wrap(err, "decoding request")}
+	}
+	return nil
+}
+
+// authorize only passes requests for the web UI served on `addr`. Requests
+// to other hosts, e.g. by DNS rebinding, are rejected. The API also requires
+// the token, as bearer token or in the cookie that the web UI gets when it is
+// opened with the token in the URL.
+func (n *node) authorize(addr string, next http.Handler) http.Handler {
+	host := webHost(addr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != host && r.Host != addr {
+			deny(w, r, &apiError{http.StatusForbidden, errors.Errorf("Unknown host %s", r.Host)})
+			return
+		}
+		if token := r.URL.Query().Get("token"); r.URL.Path == "/" && token != "" {
+			if !n.isWebToken(token) {
+				deny(w, r, &apiError{http.StatusUnauthorized, errors.New("Invalid token")})
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: webTokenCookie, Value: token, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode})
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") && !n.isWebToken(requestToken(r)) {
+			deny(w, r, &apiError{http.StatusUnauthorized, errors.New("Missing or invalid token, open the web UI with the URL printed on start")})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isWebToken returns whether `token` is the token of the web UI.
+func (n *node) isWebToken(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(n.webToken)) == 1
+}
+
+// requestToken returns the bearer token of the request or the token cookie.
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if c, err := r.Cookie(webTokenCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// deny responds with the error `err`.
+func deny(w http.ResponseWriter, r *http.Request, err error) {
+	apiFunc(func(*http.Request) (interface{}, error) { return nil, err }).ServeHTTP(w, r)
+}
+
+// webHost returns the host of the web UI served on `addr` as it is used in
+// the URL. Unspecified addresses are served on 127.0.0.1.
+func webHost(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// webURL returns the URL of the web UI with the token.
+func (n *node) webURL() string {
+	return fmt.Sprintf("http://%s/?token=%s", webHost(config.Node.Web), n.webToken)
+}
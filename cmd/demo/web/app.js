@@ -0,0 +1,225 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+"use strict";
+
+const plankPerDot = 1e12;
+const maxEvents = 200;
+let historyEnabled = false;
+
+// el creates an element with the given text or child elements.
+function el(tag, children, cls) {
+	const e = document.createElement(tag);
+	if (cls) e.className = cls;
+	for (const c of [].concat(children ?? [])) {
+		e.append(c instanceof Node ? c : document.createTextNode(String(c)));
+	}
+	return e;
+}
+
+function button(text, onClick, cls) {
+	const b = el("button", text, cls);
+	b.type = "button";
+	b.addEventListener("click", () => run(b, onClick));
+	return b;
+}
+
+// run disables the button while the action is running.
+async function run(b, action) {
+	b.disabled = true;
+	try {
+		await action();
+	} finally {
+		b.disabled = false;
+	}
+}
+
+// api posts a JSON request and shows errors.
+async function api(path, body) {
+	try {
+		const res = await fetch(path, {
+			method: "POST",
+			headers: {"Content-Type": "application/json"},
+			body: JSON.stringify(body),
+		});
+		const data = await res.json();
+		if (!res.ok) throw new Error(data.error || res.statusText);
+		return true;
+	} catch (err) {
+		toast(err.message, true);
+		return false;
+	}
+}
+
+function toast(text, error) {
+	const t = document.getElementById("toast");
+	t.textContent = text;
+	t.className = error ? "error" : "";
+	t.hidden = false;
+	clearTimeout(toast.timer);
+	toast.timer = setTimeout(() => { t.hidden = true; }, 6000);
+}
+
+function formatPlank(plank) {
+	const dot = Number(plank) / plankPerDot;
+	return dot.toLocaleString(undefined, {maximumFractionDigits: 3}) + " Dot";
+}
+
+function renderState(s) {
+	document.getElementById("alias").textContent = s.alias;
+	document.getElementById("on-chain").textContent = s.onChainBalance;
+	document.getElementById("perun-id").textContent = "Perun ID " + s.perunID;
+	document.title = s.alias + " · Perun Polkadot Demo";
+	renderProposals(s.proposals);
+	renderPeers(s.peers);
+
+	const known = document.getElementById("known-peers");
+	known.replaceChildren(...s.knownPeers.map(a => { const o = el("option"); o.value = a; return o; }));
+
+	if (s.history && !historyEnabled) {
+		historyEnabled = true;
+		document.getElementById("history-section").hidden = false;
+		loadHistory();
+	}
+}
+
+function renderProposals(proposals) {
+	document.getElementById("proposals-section").hidden = proposals.length === 0;
+	document.getElementById("proposals").replaceChildren(...proposals.map(p => {
+		const from = p.unknown ? "unknown identity " + p.peerID : p.peer;
+		return el("div", [
+			el("p", ["From ", el("strong", from), " with funding ", el("strong", "My: " + p.myBalance), ", ", el("strong", "Peer: " + p.peerBalance)]),
+			button("Accept", () => api("/api/proposals", {id: p.id, accept: true})),
+			button("Reject", () => api("/api/proposals", {id: p.id, accept: false}), "secondary"),
+		], "proposal");
+	}));
+}
+
+function renderPeers(peers) {
+	document.getElementById("no-peers").hidden = peers.length > 0;
+	document.getElementById("peers").replaceChildren(...peers.map(p => {
+		const c = p.channel;
+		const actions = el("td");
+		if (c) {
+			actions.append(button("Close", async () => {
+				if (confirm("Close the channel with " + p.alias + " and settle it on-chain?")) {
+					if (await api("/api/close", {peer: p.alias})) toast("Closed the channel with " + p.alias + ".");
+				}
+			}, "secondary"));
+		}
+		return el("tr", [
+			el("td", p.alias),
+			el("td", p.status, p.online ? "online" : "offline"),
+			el("td", c ? c.phase : "Connected"),
+			el("td", c ? c.version : ""),
+			el("td", c ? c.myBalance : ""),
+			el("td", c ? c.peerBalance : ""),
+			actions,
+		]);
+	}));
+
+	// Payments can only be sent over open channels.
+	const select = document.getElementById("send-peer");
+	const selected = select.value;
+	const open = peers.filter(p => p.channel);
+	select.replaceChildren(...open.map(p => el("option", p.alias)));
+	if (open.some(p => p.alias === selected)) select.value = selected;
+	document.getElementById("send-section").hidden = open.length === 0;
+}
+
+function addEvent(time, text) {
+	const list = document.getElementById("events");
+	list.prepend(el("li", [el("time", new Date(time).toLocaleTimeString()), " ", text]));
+	while (list.children.length > maxEvents) list.lastChild.remove();
+}
+
+async function loadHistory() {
+	try {
+		const res = await fetch("/api/history");
+		const entries = await res.json();
+		if (!res.ok) throw new Error(entries.error || res.statusText);
+		document.getElementById("history").replaceChildren(...entries.reverse().map(e => el("tr", [
+			el("td", new Date(e.time).toLocaleString()),
+			el("td", e.peer),
+			el("td", e.event),
+			el("td", e.version),
+			el("td", formatPlank(e.delta)),
+			el("td", formatPlank(e.myBalance)),
+			el("td", formatPlank(e.peerBalance)),
+			el("td", e.memo ?? ""),
+		])));
+	} catch (err) {
+		toast("Loading the history: " + err.message, true);
+	}
+}
+
+// connect receives the state and the events from the node and reconnects
+// if the connection is lost.
+function connect() {
+	const status = document.getElementById("connection");
+	const proto = location.protocol === "https:" ? "wss:" : "ws:";
+	const ws = new WebSocket(proto + "//" + location.host + "/api/events");
+	ws.onopen = () => {
+		status.textContent = "Connected";
+		status.className = "online";
+	};
+	ws.onmessage = msg => {
+		const m = JSON.parse(msg.data);
+		if (m.type === "state") {
+			renderState(m.state);
+		} else if (m.type === "event") {
+			addEvent(m.time, m.text);
+			if (historyEnabled) loadHistory();
+		}
+	};
+	ws.onclose = () => {
+		status.textContent = "Disconnected, retrying…";
+		status.className = "offline";
+		setTimeout(connect, 2000);
+	};
+}
+
+function formData(form) {
+	return Object.fromEntries(new FormData(form).entries());
+}
+
+document.getElementById("open-form").addEventListener("submit", async e => {
+	e.preventDefault();
+	const form = e.target;
+	const submit = form.querySelector("button");
+	await run(submit, async () => {
+		const req = formData(form);
+		toast("Opening a channel with " + req.peer + ", this takes a few blocks…");
+		if (await api("/api/open", req)) {
+			toast("Opened a channel with " + req.peer + ".");
+			form.reset();
+		}
+	});
+});
+
+document.getElementById("send-form").addEventListener("submit", async e => {
+	e.preventDefault();
+	const form = e.target;
+	const submit = form.querySelector("button");
+	await run(submit, async () => {
+		const req = formData(form);
+		if (await api("/api/send", req)) {
+			toast("Sent " + req.amount + " Dot to " + req.peer + ".");
+			form.amount.value = "";
+			form.memo.value = "";
+		}
+	});
+});
+
+connect();
@@ -0,0 +1,95 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"runtime"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Log components that can have their own level.
+const (
+	compWire   = "wire"
+	compClient = "client"
+	compChain  = "chain"
+	compDemo   = "demo"
+)
+
+var components = []string{compWire, compClient, compChain, compDemo}
+
+// componentPrefixes maps function name prefixes to the component that they
+// belong to. The first match wins, everything else belongs to the demo.
+var componentPrefixes = []struct{ prefix, comp string }{
+	{"perun.network/go-perun/wire", compWire},
+	{"perun.network/go-perun/", compClient},
+	{"github.com/perun-network/perun-polkadot-backend/", compChain},
+	{"github.com/centrifuge/go-substrate-rpc-client/", compChain},
+}
+
+// componentFormatter adds the `component` field to every entry and drops
+// entries that are below the level of their component.
+type componentFormatter struct {
+	logrus.Formatter
+	levels map[string]logrus.Level
+}
+
+func (f *componentFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	comp, ok := e.Data["component"].(string)
+	if !ok {
+		comp = callerComponent()
+	}
+	if lvl, ok := f.levels[comp]; ok && e.Level > lvl {
+		return nil, nil
+	}
+	// Data is a copy that belongs to this entry.
+	e.Data["component"] = comp
+	return f.Formatter.Format(e)
+}
+
+// callerComponent returns the component of the function that logged the
+// current entry.
+func callerComponent() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	inLogger := false
+	for {
+		frame, more := frames.Next()
+		if isLoggerFunc(frame.Function) {
+			inLogger = true
+		} else if inLogger {
+			return funcComponent(frame.Function)
+		}
+		if !more {
+			return compDemo
+		}
+	}
+}
+
+func isLoggerFunc(fn string) bool {
+	return strings.HasPrefix(fn, "github.com/sirupsen/logrus.") ||
+		strings.HasPrefix(fn, "perun.network/go-perun/log.") ||
+		strings.HasPrefix(fn, "perun.network/go-perun/log/")
+}
+
+func funcComponent(fn string) string {
+	for _, p := range componentPrefixes {
+		if strings.HasPrefix(fn, p.prefix) {
+			return p.comp
+		}
+	}
+	return compDemo
+}
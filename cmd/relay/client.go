@@ -0,0 +1,253 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"perun.network/go-perun/log"
+	pkgsync "perun.network/go-perun/pkg/sync"
+	"perun.network/go-perun/wallet"
+)
+
+// Scheme is the URL scheme of relay addresses.
+const Scheme = "relay"
+
+const (
+	// reconnectDelay is the delay before a lost control connection is
+	// re-established.
+	reconnectDelay = 5 * time.Second
+	// keepAlivePeriod is the interval of the TCP keep-alive probes on the
+	// control connections. They detect a silently dead connection, e.g. a
+	// NAT mapping that expired.
+	keepAlivePeriod = 15 * time.Second
+)
+
+// URL returns the relay address of the node `id` at the relay `host`.
+func URL(host, id string) string {
+	return Scheme + "://" + host + "/" + id
+}
+
+// IsURL returns whether `addr` is a relay address.
+func IsURL(addr string) bool {
+	return strings.HasPrefix(addr, Scheme+"://")
+}
+
+// ParseURL returns the relay host and node ID of a relay address.
+func ParseURL(addr string) (host, id string, err error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", errors.Wrap(err, "parsing relay URL")
+	}
+	id = strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != Scheme || u.Host == "" || id == "" {
+		return "", "", errors.Errorf("invalid relay URL '%s', expected %s", addr, URL("host:port", "<perunID>"))
+	}
+	return u.Host, id, nil
+}
+
+// Dial connects to the node `id` through the relay at `host`.
+func Dial(ctx context.Context, host, id string) (net.Conn, error) {
+	return request(ctx, host, cmdConnect, id)
+}
+
+// request dials the relay and sends a request.
+func request(ctx context.Context, host, cmd, arg string) (net.Conn, error) {
+	return exchange(ctx, host, func(conn net.Conn) error {
+		return writeLine(conn, cmd, arg)
+	})
+}
+
+// exchange dials the relay and runs `send` before it reads the response.
+func exchange(ctx context.Context, host string, send func(net.Conn) error) (net.Conn, error) {
+	d := net.Dialer{KeepAlive: keepAlivePeriod}
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, errors.Wrap(err, "dialing relay")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline) // nolint:errcheck,gosec
+	}
+	if err := send(conn); err != nil {
+		conn.Close() // nolint:errcheck,gosec
+		return nil, err
+	}
+	if err := readResp(conn); err != nil {
+		conn.Close() // nolint:errcheck,gosec
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{}) // nolint:errcheck,gosec
+	return conn, nil
+}
+
+// sendRegister registers as `id` and signs the challenge of the relay with
+// `acc`.
+func sendRegister(conn net.Conn, id string, acc wallet.Account) error {
+	if err := writeLine(conn, cmdRegister, id); err != nil {
+		return err
+	}
+	cmd, nonce, err := readCmd(conn)
+	if err != nil {
+		return err
+	}
+	switch cmd {
+	case cmdChallenge:
+	case respErr:
+		return errors.Errorf("relay: %s", nonce)
+	default:
+		return errors.Errorf("unexpected relay response: %s", cmd)
+	}
+	sig, err := acc.SignData(registerMsg(id, nonce))
+	if err != nil {
+		return errors.WithMessage(err, "signing challenge")
+	}
+	return writeLine(conn, cmdSignature, hex.EncodeToString(sig))
+}
+
+// Listener registers a node at a relay and accepts the connections that the
+// relay forwards to it. It re-registers if the relay connection is lost.
+type Listener struct {
+	host, id string
+	acc      wallet.Account
+	timeout  time.Duration
+	conns    chan net.Conn
+	log      log.Logger
+
+	mtx     sync.Mutex // Protects control.
+	control net.Conn   // The current control connection.
+
+	pkgsync.Closer
+}
+
+// Listen registers the node with the Perun account `acc` at the relay `host`.
+// Requests to the relay time out after `timeout`.
+func Listen(host string, acc wallet.Account, timeout time.Duration) (*Listener, error) {
+	l := &Listener{
+		host:    host,
+		id:      acc.Address().String(),
+		acc:     acc,
+		timeout: timeout,
+		conns:   make(chan net.Conn),
+		log:     log.WithField("relay", host),
+	}
+	control, err := l.register()
+	if err != nil {
+		return nil, err
+	}
+	l.control = control
+	l.OnCloseAlways(func() {
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		l.control.Close() // nolint:errcheck,gosec
+	})
+	go l.serve(control)
+	return l, nil
+}
+
+func (l *Listener) register() (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(l.Ctx(), l.timeout)
+	defer cancel()
+	conn, err := exchange(ctx, l.host, func(conn net.Conn) error {
+		return sendRegister(conn, l.id, l.acc)
+	})
+	return conn, errors.WithMessage(err, "registering at relay")
+}
+
+// serve handles the notifications on the control connection.
+func (l *Listener) serve(control net.Conn) {
+	for {
+		cmd, token, err := readCmd(control)
+		if err != nil {
+			control.Close() // nolint:errcheck,gosec
+			if control = l.reconnect(); control == nil {
+				return
+			}
+			continue
+		}
+		if cmd != cmdIncoming {
+			l.log.Warnf("Unexpected relay command: %s", cmd)
+			continue
+		}
+		go l.accept(token)
+	}
+}
+
+// reconnect re-registers until it succeeds or the listener is closed.
+func (l *Listener) reconnect() net.Conn {
+	for {
+		select {
+		case <-l.Closed():
+			return nil
+		case <-time.After(reconnectDelay):
+		}
+		control, err := l.register()
+		if err == nil {
+			l.log.Info("Re-registered at relay")
+			return l.setControl(control)
+		}
+		l.log.WithError(err).Warn("Re-registering at relay")
+	}
+}
+
+// setControl makes `control` the current control connection, which is closed
+// with the listener. It returns nil and closes `control` if the listener is
+// already closed.
+func (l *Listener) setControl(control net.Conn) net.Conn {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if l.IsClosed() {
+		control.Close() // nolint:errcheck,gosec
+		return nil
+	}
+	l.control = control
+	return control
+}
+
+func (l *Listener) accept(token string) {
+	ctx, cancel := context.WithTimeout(l.Ctx(), l.timeout)
+	defer cancel()
+	conn, err := request(ctx, l.host, cmdAccept, token)
+	if err != nil {
+		l.log.WithError(err).Warn("Accepting relayed connection")
+		return
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.Closed():
+		conn.Close() // nolint:errcheck,gosec
+	}
+}
+
+// Accept returns the next relayed connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.Closed():
+		return nil, errors.New("listener closed")
+	}
+}
+
+// URL returns the relay address of the listening node.
+func (l *Listener) URL() string {
+	return URL(l.host, l.id)
+}
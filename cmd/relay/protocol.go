@@ -0,0 +1,147 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package relay implements a relay server that forwards connections to nodes
+// which are not reachable directly, e.g. because they are behind a NAT.
+//
+// Nodes register with the relay over a control connection. When a peer
+// connects to a registered node, the relay notifies the node, which opens a
+// new connection to the relay. Both connections are then spliced together.
+// A node proves that it owns its ID by signing a nonce of the relay with its
+// Perun key. A new registration replaces the old one of the same node. The
+// relay only forwards bytes. It can read the wire messages and, since the wire
+// handshake is not signed, impersonate the nodes to each other unless the
+// nodes secure their connections with TLS. The demo therefore only uses relays
+// with TLS and pinned certificates.
+//
+// The protocol consists of single text lines:
+//
+//	REGISTER <id>      node -> relay, on the control connection
+//	CHALLENGE <nonce>  relay -> node, answer to REGISTER
+//	SIGNATURE <sig>    node -> relay, signature of the challenge
+//	INCOMING <token>   relay -> node, on the control connection
+//	ACCEPT <token>     node -> relay, on a new connection
+//	CONNECT <id>       peer -> relay
+//	OK | ERR <msg>     relay -> node/peer, answer to the above requests
+package relay
+
+import (
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+
+	sr25519 "github.com/perun-network/perun-polkadot-backend/pkg/sr25519"
+	dotwallet "github.com/perun-network/perun-polkadot-backend/wallet/sr25519"
+	"github.com/pkg/errors"
+)
+
+const (
+	cmdRegister  = "REGISTER"
+	cmdChallenge = "CHALLENGE"
+	cmdSignature = "SIGNATURE"
+	cmdIncoming  = "INCOMING"
+	cmdAccept    = "ACCEPT"
+	cmdConnect   = "CONNECT"
+	respOK       = "OK"
+	respErr      = "ERR"
+
+	// registerDomain separates the signatures of registrations from other
+	// signatures of the Perun key.
+	registerDomain = "perun-relay-register"
+
+	// maxLineLen is the maximal length of a protocol line.
+	maxLineLen = 256
+)
+
+// readLine reads a single line byte by byte, so that no data after the line is
+// consumed from the connection.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < maxLineLen {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", errors.Wrap(err, "reading line")
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("line too long")
+}
+
+// writeLine writes the words as one line.
+func writeLine(w io.Writer, words ...string) error {
+	_, err := io.WriteString(w, strings.Join(words, " ")+"\n")
+	return errors.Wrap(err, "writing line")
+}
+
+// readCmd reads a line and splits it into command and argument.
+func readCmd(r io.Reader) (cmd, arg string, err error) {
+	line, err := readLine(r)
+	if err != nil {
+		return "", "", err
+	}
+	parts := strings.SplitN(line, " ", 2)
+	if len(parts) == 2 {
+		arg = parts[1]
+	}
+	return parts[0], arg, nil
+}
+
+// readResp reads an OK or ERR response.
+func readResp(conn net.Conn) error {
+	cmd, arg, err := readCmd(conn)
+	switch {
+	case err != nil:
+		return err
+	case cmd == respOK:
+		return nil
+	case cmd == respErr:
+		return errors.Errorf("relay: %s", arg)
+	default:
+		return errors.Errorf("unexpected relay response: %s", cmd)
+	}
+}
+
+// normalizeID makes IDs comparable independent of their case.
+func normalizeID(id string) string {
+	return strings.ToLower(id)
+}
+
+// registerMsg returns the message that a node signs to register as `id`.
+func registerMsg(id, nonce string) []byte {
+	return []byte(registerDomain + " " + normalizeID(id) + " " + nonce)
+}
+
+// verifyRegistration checks that the hex encoded signature `sig` of the
+// challenge `nonce` was created by the Perun key of the node `id`.
+func verifyRegistration(id, nonce, sig string) error {
+	pk, err := sr25519.NewPKFromHex(id)
+	if err != nil {
+		return errors.WithMessage(err, "parsing ID")
+	}
+	sigBytes, err := hex.DecodeString(sig)
+	if err != nil {
+		return errors.Wrap(err, "decoding signature")
+	}
+	ok, err := new(dotwallet.Backend).VerifySignature(registerMsg(id, nonce), sigBytes, dotwallet.NewAddressFromPK(pk))
+	if err != nil {
+		return errors.WithMessage(err, "verifying signature")
+	} else if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/spf13/cobra"
+	"perun.network/go-perun/log"
+)
+
+var relayCmd = &cobra.Command{
+	Use:   "relay",
+	Short: "Relay server for nodes behind a NAT",
+	Long: `Runs a relay server that forwards connections to nodes which cannot be reached directly.
+	Nodes register with 'node.relay' in their config and are then addressed as
+	relay://<host>:<port>/<perunID> in the network config of their peers.`,
+	Run: runRelay,
+}
+
+var relayFlags struct {
+	listen        string
+	acceptTimeout time.Duration
+}
+
+func init() {
+	relayCmd.Flags().StringVar(&relayFlags.listen, "listen", "0.0.0.0:5760", "Listen address")
+	relayCmd.Flags().DurationVar(&relayFlags.acceptTimeout, "accept-timeout", 10*time.Second, "Timeout for nodes to accept a connection")
+}
+
+// GetRelayCmd exposes relayCmd so that it can be used as a sub-command by another cobra command instance.
+func GetRelayCmd() *cobra.Command {
+	return relayCmd
+}
+
+func runRelay(c *cobra.Command, args []string) {
+	l, err := net.Listen("tcp", relayFlags.listen)
+	if err != nil {
+		log.WithError(err).Fatalln("Could not start listener.")
+	}
+	fmt.Printf("Relay listening on %s\n", l.Addr())
+	if err := NewServer(relayFlags.acceptTimeout).Serve(l); err != nil {
+		log.WithError(err).Fatalln("Relay stopped.")
+	}
+}
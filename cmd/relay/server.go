@@ -0,0 +1,255 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package relay
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"perun.network/go-perun/log"
+)
+
+type (
+	// Server forwards connections from peers to registered nodes.
+	Server struct {
+		log           log.Logger
+		acceptTimeout time.Duration
+
+		// Protects nodes and pending.
+		mtx     sync.Mutex
+		nodes   map[string]*registration
+		pending map[string]chan net.Conn
+	}
+
+	// registration is the control connection of a registered node.
+	registration struct {
+		mtx  sync.Mutex // Protects writes to conn.
+		conn net.Conn
+	}
+)
+
+// NewServer creates a relay server. Clients must send their requests and nodes
+// must accept incoming connections within `acceptTimeout`.
+func NewServer(acceptTimeout time.Duration) *Server {
+	return &Server{
+		log:           log.Get(),
+		acceptTimeout: acceptTimeout,
+		nodes:         make(map[string]*registration),
+		pending:       make(map[string]chan net.Conn),
+	}
+}
+
+// Serve handles connections from the listener until it is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return errors.Wrap(err, "accepting connection")
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	// The deadline is lifted once the request is complete, see endRequest.
+	conn.SetDeadline(time.Now().Add(s.acceptTimeout)) // nolint:errcheck,gosec
+	cmd, arg, err := readCmd(conn)
+	if err != nil {
+		s.log.WithError(err).Debug("Reading command")
+		conn.Close() // nolint:errcheck,gosec
+		return
+	}
+	log := s.log.WithField("remote", conn.RemoteAddr())
+	log.Debugf("%s %s", cmd, arg)
+
+	switch cmd {
+	case cmdRegister:
+		err = s.register(conn, normalizeID(arg))
+	case cmdConnect:
+		endRequest(conn)
+		err = s.connect(conn, normalizeID(arg))
+	case cmdAccept:
+		endRequest(conn)
+		err = s.accept(conn, arg)
+	default:
+		err = errors.Errorf("unknown command: %s", cmd)
+	}
+	if err != nil {
+		log.WithError(err).Warn("Handling connection")
+		writeLine(conn, respErr, err.Error()) // nolint:errcheck,gosec
+		conn.Close()                          // nolint:errcheck,gosec
+	}
+}
+
+// endRequest lifts the deadline of a connection after the request was read.
+func endRequest(conn net.Conn) {
+	conn.SetDeadline(time.Time{}) // nolint:errcheck,gosec
+}
+
+// register authenticates the node and keeps its control connection until it
+// is closed. A new registration of a node replaces the old one and closes its
+// connection, e.g. after the NAT mapping of the old one died silently. Only
+// the owner of the ID can do so, since it must sign a fresh challenge.
+func (s *Server) register(conn net.Conn, id string) error {
+	nonce, err := newToken()
+	if err != nil {
+		return err
+	}
+	if err := writeLine(conn, cmdChallenge, nonce); err != nil {
+		return err
+	}
+	cmd, sig, err := readCmd(conn)
+	if err != nil {
+		return err
+	} else if cmd != cmdSignature {
+		return errors.Errorf("expected %s, got %s", cmdSignature, cmd)
+	}
+	if err := verifyRegistration(id, nonce, sig); err != nil {
+		return errors.WithMessage(err, "authenticating node")
+	}
+	endRequest(conn)
+
+	keepAlive(conn)
+	reg := &registration{conn: conn}
+	s.mtx.Lock()
+	old := s.nodes[id]
+	s.nodes[id] = reg
+	s.mtx.Unlock()
+	if old != nil {
+		s.log.WithField("id", id).Info("Replacing registration")
+		old.conn.Close() // nolint:errcheck,gosec
+	}
+
+	if err := reg.write(respOK); err != nil {
+		s.unregister(id, reg)
+		return err
+	}
+	s.log.WithField("id", id).Info("Node registered")
+
+	// The node never sends anything on the control connection, so this only
+	// returns when it is closed.
+	io.Copy(io.Discard, conn) // nolint:errcheck,gosec
+	conn.Close()              // nolint:errcheck,gosec
+
+	s.unregister(id, reg)
+	s.log.WithField("id", id).Info("Node unregistered")
+	return nil
+}
+
+func (s *Server) unregister(id string, reg *registration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.nodes[id] == reg {
+		delete(s.nodes, id)
+	}
+}
+
+// connect asks the node to accept the connection and splices both.
+func (s *Server) connect(conn net.Conn, id string) error {
+	s.mtx.Lock()
+	reg, ok := s.nodes[id]
+	s.mtx.Unlock()
+	if !ok {
+		return errors.Errorf("%s not registered", id)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	accepted := make(chan net.Conn, 1)
+	s.mtx.Lock()
+	s.pending[token] = accepted
+	s.mtx.Unlock()
+	defer func() {
+		s.mtx.Lock()
+		delete(s.pending, token)
+		s.mtx.Unlock()
+	}()
+
+	if err := reg.write(cmdIncoming, token); err != nil {
+		return errors.WithMessage(err, "notifying node")
+	}
+
+	select {
+	case nodeConn := <-accepted:
+		if err := writeLine(conn, respOK); err != nil {
+			nodeConn.Close() // nolint:errcheck,gosec
+			return err
+		}
+		splice(conn, nodeConn)
+		return nil
+	case <-time.After(s.acceptTimeout):
+		return errors.New("node did not accept in time")
+	}
+}
+
+// accept hands the connection of a node to the waiting peer connection.
+func (s *Server) accept(conn net.Conn, token string) error {
+	s.mtx.Lock()
+	accepted, ok := s.pending[token]
+	delete(s.pending, token)
+	s.mtx.Unlock()
+	if !ok {
+		return errors.New("unknown token")
+	}
+	if err := writeLine(conn, respOK); err != nil {
+		return err
+	}
+	accepted <- conn
+	return nil
+}
+
+func (r *registration) write(words ...string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return writeLine(r.conn, words...)
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating token")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// splice copies data between both connections until one of them is closed.
+func splice(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	cp := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src) // nolint:errcheck,gosec
+		dst.Close()       // nolint:errcheck,gosec
+		src.Close()       // nolint:errcheck,gosec
+	}
+	go cp(a, b)
+	go cp(b, a)
+	wg.Wait()
+}
+
+// keepAlive enables the TCP keep-alive probes on a control connection.
+func keepAlive(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetKeepAlive(true)                  // nolint:errcheck,gosec
+		tcp.SetKeepAlivePeriod(keepAlivePeriod) // nolint:errcheck,gosec
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"perun.network/go-perun/log"
 
 	"github.com/perun-network/perun-polkadot-demo/cmd/demo"
+	"github.com/perun-network/perun-polkadot-demo/cmd/relay"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -44,8 +45,14 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	rootCmd.PersistentFlags().StringVar(&logConfig.Format, "log-format", "text", "Log format: text or json")
+	err = viper.BindPFlag("log.format", rootCmd.PersistentFlags().Lookup("log-format"))
+	if err != nil {
+		panic(err)
+	}
 
 	rootCmd.AddCommand(demo.GetDemoCmd())
+	rootCmd.AddCommand(relay.GetRelayCmd())
 }
 
 func runRoot(c *cobra.Command, args []string) {
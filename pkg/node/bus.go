@@ -0,0 +1,97 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"perun.network/go-perun/log"
+	pkgsync "perun.network/go-perun/pkg/sync"
+	"perun.network/go-perun/wire"
+	wirenet "perun.network/go-perun/wire/net"
+)
+
+// msgBus wraps the wire bus and intercepts all messages for which a handler
+// was registered. All other messages are forwarded to the client.
+type msgBus struct {
+	*wirenet.Bus
+
+	// Protects handlers, see Node.HandleMessage.
+	mtx      sync.RWMutex
+	handlers map[wire.Type]func(*wire.Envelope)
+}
+
+var _ wire.Bus = (*msgBus)(nil)
+
+func newMsgBus(bus *wirenet.Bus) *msgBus {
+	return &msgBus{
+		Bus:      bus,
+		handlers: make(map[wire.Type]func(*wire.Envelope)),
+	}
+}
+
+// Handle registers a handler for messages of type `t`. Messages that arrive
+// before are passed to the client, which drops them. The handler is called on
+// the receive loop of the peer connection and must not block.
+func (b *msgBus) Handle(t wire.Type, handler func(*wire.Envelope)) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.handlers[t] = handler
+}
+
+func (b *msgBus) handler(t wire.Type) (func(*wire.Envelope), bool) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	h, ok := b.handlers[t]
+	return h, ok
+}
+
+func (b *msgBus) isHandled(e *wire.Envelope) bool {
+	_, ok := b.handler(e.Msg.Type())
+	return ok
+}
+
+// SubscribeClient subscribes the client to all messages that are not handled
+// by the node itself.
+func (b *msgBus) SubscribeClient(c wire.Consumer, addr wire.Address) error {
+	relay := wire.NewRelay()
+	if err := relay.Subscribe(c, func(e *wire.Envelope) bool { return !b.isHandled(e) }); err != nil {
+		return errors.WithMessage(err, "subscribing client")
+	}
+	if err := relay.Subscribe(&handlerConsumer{bus: b}, b.isHandled); err != nil {
+		return errors.WithMessage(err, "subscribing handlers")
+	}
+	c.OnCloseAlways(func() {
+		if err := relay.Close(); err != nil {
+			log.WithError(err).Warn("Closing relay")
+		}
+	})
+	return b.Bus.SubscribeClient(relay, addr)
+}
+
+// handlerConsumer calls the handlers synchronously. This guarantees that a
+// handled message is processed before any later message of the same peer
+// reaches the client. Handlers must therefore not block.
+type handlerConsumer struct {
+	pkgsync.Closer
+	bus *msgBus
+}
+
+func (c *handlerConsumer) Put(e *wire.Envelope) {
+	if h, ok := c.bus.handler(e.Msg.Type()); ok {
+		h(e)
+	}
+}
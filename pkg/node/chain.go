@@ -0,0 +1,97 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v3/types"
+	"github.com/pkg/errors"
+
+	"github.com/perun-network/perun-polkadot-backend/channel/pallet"
+	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	dotwallet "github.com/perun-network/perun-polkadot-backend/wallet/sr25519"
+	pchannel "perun.network/go-perun/channel"
+	pwallet "perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+)
+
+type (
+	// ChainOptions configure the connection to the Polkadot node.
+	ChainOptions struct {
+		// NodeURL is the WebSocket URL of the Polkadot node. Required.
+		NodeURL   string
+		NetworkID dot.NetworkID
+		// TxTimeout is the timeout of on-chain queries.
+		TxTimeout time.Duration
+		// BlockQueryDepth is the number of past blocks that are searched for
+		// events, between 1 and 1000.
+		BlockQueryDepth uint32 // Actually of type types.BlockNumber.
+	}
+
+	dotSetup struct {
+		Api         *dot.API
+		Funder      pchannel.Funder
+		Adjudicator pchannel.Adjudicator
+	}
+)
+
+func newDotSetup(acc pwallet.Account, cfg ChainOptions) (*dotSetup, error) {
+	api, err := dot.NewAPI(cfg.NodeURL, cfg.NetworkID)
+	if err != nil {
+		return nil, err
+	}
+	perun := pallet.NewPallet(pallet.NewPerunPallet(api), api.Metadata())
+	funder := pallet.NewFunder(perun, acc, 3)
+	adj := pallet.NewAdjudicator(acc, perun, api, types.BlockNumber(cfg.BlockQueryDepth))
+	return &dotSetup{api, funder, adj}, nil
+}
+
+// validate checks the options for some obvious errors.
+func (c *ChainOptions) validate() error {
+	switch {
+	case c.NodeURL == "":
+		return errors.New("empty node url")
+	case c.BlockQueryDepth < 1 || c.BlockQueryDepth > 1000:
+		return errors.New("block query depth out of range")
+	default:
+		return nil
+	}
+}
+
+// OnChainBalance returns the free on-chain balances of the accounts `ids` in
+// Plank.
+func (n *Node) OnChainBalance(ctx context.Context, ids ...wire.Address) ([]*big.Int, error) {
+	bals := make([]*big.Int, len(ids))
+	for i, id := range ids {
+		accInfo, err := n.api.AccountInfo(dotwallet.AsAddr(id).AccountID())
+		if err != nil {
+			return nil, errors.Wrap(err, "querying on-chain balance")
+		}
+		bals[i] = accInfo.Free.Int
+	}
+	return bals, nil
+}
+
+// BlockNumber returns the number of the latest block.
+func (n *Node) BlockNumber() (uint64, error) {
+	header, err := n.api.LastHeader()
+	if err != nil {
+		return 0, errors.Wrap(err, "querying last block")
+	}
+	return uint64(header.Number), nil
+}
@@ -0,0 +1,322 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"context"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/pkg/errors"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/wire"
+)
+
+type (
+	// ChannelInfo is a snapshot of a channel. Balances are in Plank.
+	ChannelInfo struct {
+		ID          channel.ID
+		Peer        string
+		PeerID      wire.Address
+		Phase       channel.Phase
+		Version     uint64
+		IsFinal     bool
+		MyBalance   *big.Int
+		PeerBalance *big.Int
+		// Collisions counts the received updates that collided with an own
+		// update of the same version.
+		Collisions uint64
+	}
+
+	// Payment is a sent payment. Amounts are in Plank.
+	Payment struct {
+		Peer        string
+		Channel     channel.ID
+		Version     uint64
+		Amount      *big.Int
+		MyBalance   *big.Int
+		PeerBalance *big.Int
+		Memo        string
+		// Duration is the time from proposing the update until the peer
+		// accepted it.
+		Duration time.Duration
+		// SignDuration is the part of Duration spent signing the new state.
+		SignDuration time.Duration
+	}
+
+	paymentChannel struct {
+		*client.Channel
+
+		alias   string // Alias of the peer.
+		memos   *memos
+		limits  *limiter
+		signs   *signTimer
+		metrics *Metrics
+		emit    func(Event)
+		// timeout of answering an update of the peer.
+		timeout time.Duration
+		// entry is the base logger of the channel, use log() to log.
+		entry   log.Logger
+		version uint64 // Version of the latest known state, accessed atomically.
+		// proposing is the version of the pending or timed out own update,
+		// zero if none or once the channel or an update of the peer reached
+		// this version. Accessed atomically.
+		proposing uint64
+		// collisions counts the received updates that collided with an own
+		// update, accessed atomically.
+		collisions uint64
+	}
+)
+
+func (n *Node) newPaymentChannel(ch *client.Channel, alias string) *paymentChannel {
+	n.memos.register(ch.ID(), ch.Peers()[1-ch.Idx()]) // assumes two-party channel
+	return &paymentChannel{
+		Channel: ch,
+		alias:   alias,
+		memos:   n.memos,
+		limits:  n.limits,
+		signs:   n.signs,
+		metrics: n.opts.Metrics,
+		emit:    n.emit,
+		timeout: n.opts.UpdateTimeout,
+		entry: log.WithFields(log.Fields{
+			"channel": ch.ID(),
+			"peer":    ch.Peers()[1-ch.Idx()], // assumes two-party channel
+		}),
+		version: ch.State().Version,
+	}
+}
+
+// log returns a logger with the channel, peer and version fields.
+func (ch *paymentChannel) log() log.Logger {
+	return ch.entry.WithField("version", atomic.LoadUint64(&ch.version))
+}
+
+// setVersion records the latest known version. A pending or timed out own
+// update of this or an older version cannot collide anymore.
+func (ch *paymentChannel) setVersion(v uint64) {
+	atomic.StoreUint64(&ch.version, v)
+	ch.clearProposing(v)
+}
+
+// clearProposing forgets the own update if its version is at most `v`.
+func (ch *paymentChannel) clearProposing(v uint64) {
+	if p := atomic.LoadUint64(&ch.proposing); p != 0 && p <= v {
+		atomic.CompareAndSwapUint64(&ch.proposing, p, 0)
+	}
+}
+
+// info returns a snapshot of the channel.
+func (ch *paymentChannel) info() ChannelInfo {
+	state := ch.State()
+	bals := stateBals(state)
+	return ChannelInfo{
+		ID:          ch.ID(),
+		Peer:        ch.alias,
+		PeerID:      ch.Peers()[1-ch.Idx()], // assumes two-party channel
+		Phase:       ch.Phase(),
+		Version:     state.Version,
+		IsFinal:     state.IsFinal,
+		MyBalance:   new(big.Int).Set(bals[ch.Idx()]),
+		PeerBalance: new(big.Int).Set(bals[1-ch.Idx()]),
+		Collisions:  atomic.LoadUint64(&ch.collisions),
+	}
+}
+
+// sendMoney sends `amount` to the peer if the limits allow it. The optional
+// memo is sent along.
+func (ch *paymentChannel) sendMoney(ctx context.Context, amount *big.Int, memo string) (Payment, error) {
+	release, err := ch.limits.reserve(ch.alias, amount)
+	if err != nil {
+		return Payment{}, err
+	}
+	min := ch.limits.minBalance(ch.alias)
+	ch.signs.take(ch.ID())
+	start := time.Now()
+	state, err := ch.sendUpdate(ctx,
+		func(state *channel.State) error {
+			transferBal(stateBals(state), ch.Idx(), amount)
+			if stateBals(state)[ch.Idx()].Cmp(min) < 0 {
+				return errors.Errorf("Payment would leave less than the minimum balance of %v", dot.NewDotFromPlank(min))
+			}
+			return nil
+		}, "sendMoney", memo)
+	if err != nil {
+		release()
+		return Payment{}, err
+	}
+
+	e := newChannelEvent(EventPaymentSent, ch, state)
+	e.Amount, e.Memo = new(big.Int).Set(amount), memo
+	ch.emit(e)
+	return Payment{
+		Peer:         ch.alias,
+		Channel:      ch.ID(),
+		Version:      e.Version,
+		Amount:       e.Amount,
+		MyBalance:    e.MyBalance,
+		PeerBalance:  e.PeerBalance,
+		Memo:         memo,
+		Duration:     time.Since(start),
+		SignDuration: ch.signs.take(ch.ID()),
+	}, nil
+}
+
+// sendFinal agrees with the peer on the current state as final state.
+func (ch *paymentChannel) sendFinal(ctx context.Context) error {
+	ch.log().Debugf("Sending final state")
+	state, err := ch.sendUpdate(ctx, func(state *channel.State) error {
+		state.IsFinal = true
+		return nil
+	}, "final", "")
+	if err == nil {
+		e := newChannelEvent(EventChannelFinalized, ch, state)
+		e.Amount = new(big.Int)
+		ch.emit(e)
+	}
+	return err
+}
+
+// sendUpdate proposes the update to the peer and returns the new state.
+func (ch *paymentChannel) sendUpdate(ctx context.Context, update func(*channel.State) error, desc, memo string) (*channel.State, error) {
+	ch.log().Debugf("Sending update: %s", desc)
+	stateBefore := ch.State()
+	// The update is applied locally first, so that its checks fail before
+	// the memo is sent.
+	next := stateBefore.Clone()
+	if err := update(next); err != nil {
+		return nil, err
+	}
+	next.Version++
+	peer := ch.Peers()[1-ch.Idx()] // assumes two-party channel
+	if memo != "" {
+		if err := ch.memos.send(ctx, peer, ch.ID(), next, memo); err != nil {
+			return nil, err
+		}
+	}
+	atomic.StoreUint64(&ch.proposing, next.Version)
+	start := time.Now()
+	err := ch.UpdateBy(ctx, update)
+	if err == nil {
+		ch.metrics.updateSent(time.Since(start))
+	} else if memo != "" && outcome(err) == outcomeError {
+		// The peer may not have received the update, retract the memo.
+		rctx, cancel := context.WithTimeout(context.Background(), ch.timeout)
+		if rerr := ch.memos.send(rctx, peer, ch.ID(), next, ""); rerr != nil {
+			ch.log().WithError(rerr).Warn("Could not retract memo")
+		}
+		cancel()
+	}
+	if outcome(err) != outcomeError {
+		// The peer processed the update, so it cannot collide anymore.
+		atomic.StoreUint64(&ch.proposing, 0)
+	}
+	ch.metrics.update(dirSent, outcome(err))
+	ch.log().Debugf("Sent update: %s, err: %v", desc, err)
+
+	state := ch.State()
+	ch.setVersion(state.Version)
+	// A memo of the peer for this version belongs to an update that lost.
+	ch.memos.expire(ch.ID(), state.Version)
+	return state, err
+}
+
+func transferBal(bals []channel.Bal, ourIdx channel.Index, amount *big.Int) {
+	a := new(big.Int).Set(amount) // local copy because we mutate it
+	otherIdx := ourIdx ^ 1
+	ourBal := bals[ourIdx]
+	otherBal := bals[otherIdx]
+	otherBal.Add(otherBal, a)
+	ourBal.Sub(ourBal, a)
+}
+
+func stateBals(state *channel.State) []channel.Bal {
+	return state.Balances[0]
+}
+
+// Handle handles an update of the peer and returns whether it was accepted.
+func (ch *paymentChannel) Handle(old *channel.State, update client.ChannelUpdate, res *client.UpdateResponder) (accepted bool) {
+	if v := atomic.LoadUint64(&ch.proposing); v != 0 && v == update.State.Version {
+		// Both sides proposed the same version at the same time. The own
+		// update waited for the peer, which waited for us, until it timed out.
+		atomic.AddUint64(&ch.collisions, 1)
+		ch.metrics.collision()
+		ch.log().Warnf("Update collision: both sides proposed version %d", v)
+	}
+	// Only the first update of the peer at this version can collide with the
+	// own update.
+	ch.clearProposing(update.State.Version)
+	memo := ch.memos.take(ch.ID(), update.State)
+	ctx, cancel := context.WithTimeout(context.Background(), ch.timeout)
+	defer cancel()
+	// The final update is exempt from the rate limit, so that a cooperative
+	// close is not rejected under load. It is the last update of the channel
+	// and must not reduce our balance either, see assertValidTransition.
+	if !update.State.IsFinal && !ch.limits.allowUpdate(ch.alias) {
+		ch.metrics.update(dirReceived, outcomeRejected)
+		ch.log().Warn("Rejecting update: rate limit exceeded")
+		if err := res.Reject(ctx, "rate limit exceeded"); err != nil {
+			ch.log().WithError(err).Error("Could not reject channel update")
+		}
+		return false
+	} else if err := assertValidTransition(old, update.State, update.ActorIdx); err != nil {
+		ch.metrics.update(dirReceived, outcomeRejected)
+		if err := res.Reject(ctx, "invalid transition"); err != nil {
+			ch.log().WithError(err).Error("Could not reject channel proposal")
+		}
+		return false
+	} else if err := res.Accept(ctx); err != nil {
+		ch.metrics.update(dirReceived, outcomeError)
+		err = errors.WithMessage(err, "handling payment update")
+		ch.log().Error(err)
+		e := newChannelEvent(EventError, ch, update.State)
+		e.Err = err
+		ch.emit(e)
+		return false
+	}
+
+	ch.setVersion(update.State.Version)
+	ch.metrics.update(dirReceived, outcomeAccepted)
+	e := newChannelEvent(EventPaymentReceived, ch, update.State)
+	if update.State.IsFinal {
+		e.Type = EventChannelFinalized
+	}
+	e.Amount = new(big.Int).Sub(stateBals(update.State)[ch.Idx()], stateBals(old)[ch.Idx()])
+	e.Memo = memo
+	ch.emit(e)
+	return true
+}
+
+// assertValidTransition checks that money flows only from the actor to the
+// other participants.
+func assertValidTransition(from, to *channel.State, actor channel.Index) error {
+	if !channel.IsNoData(to.Data) {
+		return errors.New("channel must not have app data")
+	}
+	for i, asset := range from.Balances {
+		for j, bal := range asset {
+			if int(actor) == j && bal.Cmp(to.Balances[i][j]) == -1 {
+				return errors.Errorf("payer[%d] steals asset %d, so %d < %d", j, i, bal, to.Balances[i][j])
+			} else if int(actor) != j && bal.Cmp(to.Balances[i][j]) == 1 {
+				return errors.Errorf("payer[%d] reduces participant[%d]'s asset %d", actor, j, i)
+			}
+		}
+	}
+	return nil
+}
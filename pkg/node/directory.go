@@ -0,0 +1,91 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"sync"
+
+	"perun.network/go-perun/wire"
+	wirenet "perun.network/go-perun/wire/net"
+)
+
+type (
+	// Directory resolves the peers of a node. It is queried whenever a peer
+	// is connected or proposes a channel, so it may change at runtime.
+	Directory interface {
+		// Lookup returns the Perun ID and the address of the peer with
+		// `alias`. The address is registered with the Dialer.
+		Lookup(alias string) (id wire.Address, addr string, ok bool)
+		// Alias returns the alias of the peer with the Perun ID `id`.
+		Alias(id wire.Address) (alias string, ok bool)
+	}
+
+	// Dialer dials the peers at the addresses that are registered for them.
+	Dialer interface {
+		wirenet.Dialer
+		Register(id wire.Address, addr string)
+	}
+
+	// MemDirectory is a Directory in memory. It is safe for concurrent use.
+	MemDirectory struct {
+		mtx   sync.RWMutex
+		peers map[string]memEntry
+	}
+
+	memEntry struct {
+		id   wire.Address
+		addr string
+	}
+)
+
+var _ Directory = (*MemDirectory)(nil)
+
+// NewMemDirectory returns an empty MemDirectory.
+func NewMemDirectory() *MemDirectory {
+	return &MemDirectory{peers: make(map[string]memEntry)}
+}
+
+// Add adds the peer with `alias`, Perun ID `id` and address `addr`. An
+// existing peer with the same alias is replaced.
+func (d *MemDirectory) Add(alias string, id wire.Address, addr string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.peers[alias] = memEntry{id, addr}
+}
+
+// Remove removes the peer with `alias`.
+func (d *MemDirectory) Remove(alias string) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	delete(d.peers, alias)
+}
+
+func (d *MemDirectory) Lookup(alias string) (wire.Address, string, bool) {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+	e, ok := d.peers[alias]
+	return e.id, e.addr, ok
+}
+
+func (d *MemDirectory) Alias(id wire.Address) (string, bool) {
+	d.mtx.RLock()
+	defer d.mtx.RUnlock()
+	for alias, e := range d.peers {
+		if e.id.Equals(id) {
+			return alias, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"math/rand"
+	"testing"
+
+	sr25519test "github.com/perun-network/perun-polkadot-backend/wallet/sr25519/test"
+	"perun.network/go-perun/wire"
+)
+
+// newRandomAddress returns a random Perun ID.
+func newRandomAddress(rng *rand.Rand) wire.Address {
+	return sr25519test.NewRandomizer().NewRandomAddress(rng)
+}
+
+func TestMemDirectory(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	alice, bob := newRandomAddress(rng), newRandomAddress(rng)
+	d := NewMemDirectory()
+
+	if _, _, ok := d.Lookup("alice"); ok {
+		t.Error("Lookup found a peer in an empty directory")
+	}
+	d.Add("alice", alice, "127.0.0.1:5750")
+	d.Add("bob", bob, "127.0.0.1:5751")
+
+	id, addr, ok := d.Lookup("alice")
+	if !ok || !id.Equals(alice) || addr != "127.0.0.1:5750" {
+		t.Errorf("Lookup(alice) = %v, %q, %t", id, addr, ok)
+	}
+	if alias, ok := d.Alias(bob); !ok || alias != "bob" {
+		t.Errorf("Alias(bob) = %q, %t", alias, ok)
+	}
+
+	// Adding an existing alias replaces the peer.
+	d.Add("alice", alice, "127.0.0.1:6750")
+	if _, addr, _ := d.Lookup("alice"); addr != "127.0.0.1:6750" {
+		t.Errorf("Lookup(alice) after replacing = %q", addr)
+	}
+
+	d.Remove("bob")
+	if _, _, ok := d.Lookup("bob"); ok {
+		t.Error("Lookup found a removed peer")
+	}
+	if _, ok := d.Alias(bob); ok {
+		t.Error("Alias found a removed peer")
+	}
+	if _, ok := d.Alias(newRandomAddress(rng)); ok {
+		t.Error("Alias found an unknown peer")
+	}
+}
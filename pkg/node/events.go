@@ -0,0 +1,147 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wire"
+)
+
+// EventType is the type of an Event.
+type EventType string
+
+// Types of the events.
+const (
+	// EventProposalReceived is emitted for a channel proposal of a peer.
+	// The proposal must be accepted or rejected, see Node.Accept.
+	EventProposalReceived EventType = "proposal_received"
+	// EventProposalExpired is emitted when a received proposal was
+	// rejected because it was not decided within the FundTimeout.
+	EventProposalExpired EventType = "proposal_expired"
+	// EventChannelOpened is emitted when a channel is funded.
+	EventChannelOpened EventType = "channel_opened"
+	// EventPaymentSent is emitted when the peer accepted a payment.
+	EventPaymentSent EventType = "payment_sent"
+	// EventPaymentReceived is emitted for an accepted payment of the peer.
+	EventPaymentReceived EventType = "payment_received"
+	// EventChannelFinalized is emitted when both sides agreed on the final
+	// state of a channel.
+	EventChannelFinalized EventType = "finalized"
+	// EventChannelConcluded is emitted when a channel was concluded
+	// on-chain, by either side.
+	EventChannelConcluded EventType = "concluded"
+	// EventChannelSettled is emitted when the own balance was withdrawn and
+	// the channel removed.
+	EventChannelSettled EventType = "settled"
+	// EventPeerOnline is emitted when a peer answers a ping again.
+	EventPeerOnline EventType = "peer_online"
+	// EventPeerOffline is emitted when a peer stopped answering pings.
+	EventPeerOffline EventType = "peer_offline"
+	// EventError is emitted when an operation failed that was not started
+	// by a method call, e.g. handling an update or settling a concluded
+	// channel, and when settling a channel failed.
+	EventError EventType = "error"
+)
+
+type (
+	// Event is emitted by a node, see Node.Subscribe. All amounts are in
+	// Plank and from the perspective of the node. Fields that do not apply
+	// to the type of the event are zero.
+	Event struct {
+		Type EventType
+		Time time.Time
+		// Peer is the alias of the peer.
+		Peer    string
+		PeerID  wire.Address
+		Channel channel.ID
+		Version uint64
+		// Amount of a payment.
+		Amount      *big.Int
+		MyBalance   *big.Int
+		PeerBalance *big.Int
+		// Memo of a payment, empty if none.
+		Memo string
+		// RTT is the round-trip time of a peer that is online again.
+		RTT time.Duration
+		// Proposal is the received proposal.
+		Proposal *Proposal
+		Err      error
+	}
+
+	// subscribers are the event handlers of a node.
+	subscribers struct {
+		mtx      sync.RWMutex
+		next     uint64
+		handlers []subscriber
+	}
+
+	subscriber struct {
+		id     uint64
+		handle func(Event)
+	}
+)
+
+// Subscribe calls `handle` for every event of the node until the returned
+// function is called. The events are passed in order and synchronously, so
+// `handle` must neither block nor call the operations of the node, but start
+// them in a goroutine instead.
+func (n *Node) Subscribe(handle func(Event)) (unsubscribe func()) {
+	s := &n.subs
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.next++
+	id := s.next
+	s.handlers = append(s.handlers, subscriber{id, handle})
+	return func() {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+		for i, sub := range s.handlers {
+			if sub.id == id {
+				s.handlers = append(s.handlers[:i:i], s.handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// emit passes the event to the subscribers. It must not be called while
+// holding n.mtx.
+func (n *Node) emit(e Event) {
+	e.Time = time.Now()
+	n.subs.mtx.RLock()
+	handlers := n.subs.handlers
+	n.subs.mtx.RUnlock()
+	for _, sub := range handlers {
+		sub.handle(e)
+	}
+}
+
+// newChannelEvent returns an event of the channel in state `s`.
+func newChannelEvent(t EventType, ch *paymentChannel, s *channel.State) Event {
+	bals := stateBals(s)
+	return Event{
+		Type:        t,
+		Peer:        ch.alias,
+		PeerID:      ch.Peers()[1-ch.Idx()], // assumes two-party channel
+		Channel:     s.ID,
+		Version:     s.Version,
+		MyBalance:   new(big.Int).Set(bals[ch.Idx()]),
+		PeerBalance: new(big.Int).Set(bals[1-ch.Idx()]),
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import "testing"
+
+func TestSubscribe(t *testing.T) {
+	var n Node
+	var first, second []EventType
+	unsubscribe := n.Subscribe(func(e Event) {
+		if e.Time.IsZero() {
+			t.Error("Event without time")
+		}
+		first = append(first, e.Type)
+	})
+	n.Subscribe(func(e Event) { second = append(second, e.Type) })
+
+	n.emit(Event{Type: EventChannelOpened})
+	n.emit(Event{Type: EventPaymentReceived})
+	unsubscribe()
+	unsubscribe() // Idempotent.
+	n.emit(Event{Type: EventChannelSettled})
+
+	want := []EventType{EventChannelOpened, EventPaymentReceived}
+	if !equalTypes(first, want) {
+		t.Errorf("Unsubscribed handler got %v, want %v", first, want)
+	}
+	want = append(want, EventChannelSettled)
+	if !equalTypes(second, want) {
+		t.Errorf("Handler got %v, want %v", second, want)
+	}
+}
+
+func equalTypes(a, b []EventType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"math"
+	"math/big"
+	"sync"
+	"time"
+
+	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	"github.com/pkg/errors"
+)
+
+const defaultLimitWindow = time.Minute
+
+type (
+	// Limits limit the payments of a node. The global limits apply to all
+	// peers together, the limits in Peers additionally to single peers. Nil
+	// and zero values disable a limit. Amounts are in Plank.
+	Limits struct {
+		PaymentLimits
+		// Window is the time window of MaxPerWindow. Defaults to 1m.
+		Window time.Duration
+		// Peers contains the limits per peer alias.
+		Peers map[string]PaymentLimits
+	}
+
+	// PaymentLimits are the limits of the payments with one or all peers.
+	PaymentLimits struct {
+		// MaxPayment is the maximal amount of a single outgoing payment.
+		MaxPayment *big.Int
+		// MaxPerWindow is the maximal amount of outgoing payments per Window.
+		MaxPerWindow *big.Int
+		// MaxUpdateRate is the maximal number of updates per second that a
+		// peer may send. The global value applies to every peer. Final
+		// updates are exempt.
+		MaxUpdateRate float64
+		// MinBalance is the own balance that must remain in a channel.
+		MinBalance *big.Int
+	}
+
+	// limiter enforces the Limits.
+	limiter struct {
+		cfg Limits
+
+		mtx     sync.Mutex
+		global  spendWindow
+		spent   map[string]*spendWindow // By peer alias.
+		buckets map[string]*tokenBucket // By peer alias.
+	}
+
+	// spendWindow records the outgoing payments within the limit window.
+	spendWindow struct {
+		payments []payment
+		sum      *big.Int
+	}
+
+	payment struct {
+		time   time.Time
+		amount *big.Int
+	}
+
+	// tokenBucket allows `rate` events per second with bursts of up to
+	// `rate` events, but at least one.
+	tokenBucket struct {
+		tokens float64
+		last   time.Time
+	}
+)
+
+func newLimiter(cfg Limits) *limiter {
+	if cfg.Window <= 0 {
+		cfg.Window = defaultLimitWindow
+	}
+	return &limiter{
+		cfg:     cfg,
+		global:  spendWindow{sum: new(big.Int)},
+		spent:   make(map[string]*spendWindow),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// reserve checks an outgoing payment of `amount` Plank to `alias` against the
+// limits and reserves it. The returned function releases the reservation if
+// the payment fails.
+func (l *limiter) reserve(alias string, amount *big.Int) (release func(), err error) {
+	peer := l.cfg.Peers[alias]
+	for _, max := range []*big.Int{l.cfg.MaxPayment, peer.MaxPayment} {
+		if exceeds(amount, max) {
+			return nil, errors.Errorf("Payment exceeds the limit of %v per payment", dot.NewDotFromPlank(max))
+		}
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	now := time.Now()
+	spent, ok := l.spent[alias]
+	if !ok {
+		spent = &spendWindow{sum: new(big.Int)}
+		l.spent[alias] = spent
+	}
+	l.global.expire(now.Add(-l.cfg.Window))
+	spent.expire(now.Add(-l.cfg.Window))
+	for _, w := range []struct {
+		spent *spendWindow
+		max   *big.Int
+	}{{&l.global, l.cfg.MaxPerWindow}, {spent, peer.MaxPerWindow}} {
+		if exceeds(new(big.Int).Add(w.spent.sum, amount), w.max) {
+			return nil, errors.Errorf("Payment exceeds the limit of %v per %v", dot.NewDotFromPlank(w.max), l.cfg.Window)
+		}
+	}
+
+	p := payment{time: now, amount: amount}
+	l.global.add(p)
+	spent.add(p)
+	return func() {
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		l.global.remove(p)
+		spent.remove(p)
+	}, nil
+}
+
+// minBalance returns the own balance in Plank that must remain in a channel
+// with `alias`.
+func (l *limiter) minBalance(alias string) *big.Int {
+	min := new(big.Int)
+	for _, m := range []*big.Int{l.cfg.MinBalance, l.cfg.Peers[alias].MinBalance} {
+		if m != nil && m.Cmp(min) > 0 {
+			min = m
+		}
+	}
+	return min
+}
+
+// allowUpdate returns whether `alias` may send another update.
+func (l *limiter) allowUpdate(alias string) bool {
+	rate := l.cfg.MaxUpdateRate
+	if peer := l.cfg.Peers[alias].MaxUpdateRate; peer > 0 {
+		rate = peer
+	}
+	if rate <= 0 {
+		return true
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	b, ok := l.buckets[alias]
+	if !ok {
+		b = &tokenBucket{tokens: math.Max(rate, 1), last: time.Now()}
+		l.buckets[alias] = b
+	}
+	return b.take(rate)
+}
+
+// exceeds returns whether `amount` exceeds the limit `max`.
+func exceeds(amount, max *big.Int) bool {
+	return max != nil && max.Sign() > 0 && amount.Cmp(max) > 0
+}
+
+func (w *spendWindow) add(p payment) {
+	w.payments = append(w.payments, p)
+	w.sum.Add(w.sum, p.amount)
+}
+
+// expire removes the payments before `t`.
+func (w *spendWindow) expire(t time.Time) {
+	i := 0
+	for ; i < len(w.payments) && w.payments[i].time.Before(t); i++ {
+		w.sum.Sub(w.sum, w.payments[i].amount)
+	}
+	w.payments = w.payments[i:]
+}
+
+func (w *spendWindow) remove(p payment) {
+	for i, q := range w.payments {
+		if q == p {
+			w.payments = append(w.payments[:i], w.payments[i+1:]...)
+			w.sum.Sub(w.sum, p.amount)
+			return
+		}
+	}
+}
+
+func (b *tokenBucket) take(rate float64) bool {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if burst := math.Max(rate, 1); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,149 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestLimiterMaxPayment(t *testing.T) {
+	l := newLimiter(Limits{
+		PaymentLimits: PaymentLimits{MaxPayment: big.NewInt(10)},
+		Peers:         map[string]PaymentLimits{"bob": {MaxPayment: big.NewInt(5)}},
+	})
+	tests := []struct {
+		peer   string
+		amount int64
+		ok     bool
+	}{
+		{"alice", 10, true},
+		{"alice", 11, false},
+		{"bob", 5, true},
+		{"bob", 6, false},
+	}
+	for _, tt := range tests {
+		_, err := l.reserve(tt.peer, big.NewInt(tt.amount))
+		if (err == nil) != tt.ok {
+			t.Errorf("reserve(%s, %d) = %v, want ok %t", tt.peer, tt.amount, err, tt.ok)
+		}
+	}
+}
+
+func TestLimiterMaxPerWindow(t *testing.T) {
+	l := newLimiter(Limits{
+		PaymentLimits: PaymentLimits{MaxPerWindow: big.NewInt(10)},
+		Window:        100 * time.Millisecond,
+		Peers:         map[string]PaymentLimits{"bob": {MaxPerWindow: big.NewInt(4)}},
+	})
+	if _, err := l.reserve("bob", big.NewInt(4)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.reserve("bob", big.NewInt(1)); err == nil {
+		t.Error("reserve exceeded the limit of the peer")
+	}
+	release, err := l.reserve("alice", big.NewInt(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.reserve("alice", big.NewInt(1)); err == nil {
+		t.Error("reserve exceeded the global limit")
+	}
+
+	// Releasing a failed payment frees its amount.
+	release()
+	if _, err := l.reserve("alice", big.NewInt(6)); err != nil {
+		t.Errorf("reserve after release: %v", err)
+	}
+
+	// Payments leave the window.
+	time.Sleep(150 * time.Millisecond)
+	if _, err := l.reserve("bob", big.NewInt(4)); err != nil {
+		t.Errorf("reserve after the window: %v", err)
+	}
+}
+
+func TestLimiterMinBalance(t *testing.T) {
+	l := newLimiter(Limits{
+		PaymentLimits: PaymentLimits{MinBalance: big.NewInt(3)},
+		Peers: map[string]PaymentLimits{
+			"bob":   {MinBalance: big.NewInt(5)},
+			"carol": {MinBalance: big.NewInt(1)},
+		},
+	})
+	for peer, want := range map[string]int64{"alice": 3, "bob": 5, "carol": 3} {
+		if got := l.minBalance(peer); got.Cmp(big.NewInt(want)) != 0 {
+			t.Errorf("minBalance(%s) = %v, want %d", peer, got, want)
+		}
+	}
+	if got := newLimiter(Limits{}).minBalance("alice"); got.Sign() != 0 {
+		t.Errorf("minBalance without limit = %v, want 0", got)
+	}
+}
+
+func TestLimiterAllowUpdate(t *testing.T) {
+	l := newLimiter(Limits{
+		PaymentLimits: PaymentLimits{MaxUpdateRate: 2},
+		Peers: map[string]PaymentLimits{
+			"bob":   {MaxUpdateRate: 0.5},
+			"carol": {MaxUpdateRate: 100},
+		},
+	})
+	tests := []struct {
+		peer    string
+		allowed int // Updates allowed in a burst.
+	}{
+		{"alice", 2},
+		{"bob", 1}, // At least one.
+		{"carol", 100},
+	}
+	for _, tt := range tests {
+		allowed := 0
+		for i := 0; i < 2*tt.allowed; i++ {
+			if l.allowUpdate(tt.peer) {
+				allowed++
+			}
+		}
+		if allowed != tt.allowed {
+			t.Errorf("allowUpdate(%s) allowed %d updates, want %d", tt.peer, allowed, tt.allowed)
+		}
+	}
+
+	unlimited := newLimiter(Limits{})
+	for i := 0; i < 1000; i++ {
+		if !unlimited.allowUpdate("alice") {
+			t.Fatal("allowUpdate without limit rejected an update")
+		}
+	}
+}
+
+func TestExceeds(t *testing.T) {
+	tests := []struct {
+		amount int64
+		max    *big.Int
+		want   bool
+	}{
+		{5, nil, false},
+		{5, big.NewInt(0), false},
+		{5, big.NewInt(5), false},
+		{6, big.NewInt(5), true},
+	}
+	for _, tt := range tests {
+		if got := exceeds(big.NewInt(tt.amount), tt.max); got != tt.want {
+			t.Errorf("exceeds(%d, %v) = %t, want %t", tt.amount, tt.max, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+)
+
+// pinger sends ping messages to peers and waits for their pongs.
+type pinger struct {
+	bus  *msgBus
+	self wire.Address
+	// timeout of sending a pong.
+	timeout time.Duration
+
+	// Protects waiting
+	mtx     sync.Mutex
+	waiting map[wallet.AddrKey][]chan struct{}
+}
+
+func newPinger(bus *msgBus, self wire.Address, timeout time.Duration) *pinger {
+	p := &pinger{
+		bus:     bus,
+		self:    self,
+		timeout: timeout,
+		waiting: make(map[wallet.AddrKey][]chan struct{}),
+	}
+	bus.Handle(wire.Ping, p.handlePing)
+	bus.Handle(wire.Pong, p.handlePong)
+	return p
+}
+
+// Ping dials the peer if necessary and measures the round-trip time of a
+// ping message.
+func (p *pinger) Ping(ctx context.Context, peer wire.Address) (time.Duration, error) {
+	pong := make(chan struct{}, 1)
+	key := wallet.Key(peer)
+	p.mtx.Lock()
+	p.waiting[key] = append(p.waiting[key], pong)
+	p.mtx.Unlock()
+	defer p.stopWaiting(key, pong)
+
+	start := time.Now()
+	if err := p.bus.Publish(ctx, &wire.Envelope{
+		Sender:    p.self,
+		Recipient: peer,
+		Msg:       wire.NewPingMsg(),
+	}); err != nil {
+		return 0, errors.WithMessage(err, "sending ping")
+	}
+
+	select {
+	case <-pong:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, errors.Wrap(ctx.Err(), "waiting for pong")
+	}
+}
+
+func (p *pinger) stopWaiting(key wallet.AddrKey, pong chan struct{}) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	chans := p.waiting[key]
+	for i, c := range chans {
+		if c == pong {
+			p.waiting[key] = append(chans[:i], chans[i+1:]...)
+			break
+		}
+	}
+	if len(p.waiting[key]) == 0 {
+		delete(p.waiting, key)
+	}
+}
+
+func (p *pinger) handlePing(e *wire.Envelope) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		defer cancel()
+		if err := p.bus.Publish(ctx, &wire.Envelope{
+			Sender:    e.Recipient,
+			Recipient: e.Sender,
+			Msg:       wire.NewPongMsg(),
+		}); err != nil {
+			log.WithField("peer", e.Sender).WithError(err).Warn("Sending pong")
+		}
+	}()
+}
+
+func (p *pinger) handlePong(e *wire.Envelope) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, pong := range p.waiting[wallet.Key(e.Sender)] {
+		select {
+		case pong <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// pingPeers periodically pings all connected peers and updates their status
+// until the node is stopped.
+func (n *Node) pingPeers(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n.mtx.Lock()
+		peers := make([]*peer, 0, len(n.peers))
+		for _, p := range n.peers {
+			peers = append(peers, p)
+		}
+		n.mtx.Unlock()
+
+		for _, p := range peers {
+			ctx, cancel := context.WithTimeout(n.ctx, n.opts.DialTimeout)
+			rtt, err := n.pinger.Ping(ctx, p.perunID)
+			cancel()
+			n.updateStatus(p, rtt, err)
+		}
+	}
+}
+
+// updateStatus records the result of a ping and emits status changes.
+func (n *Node) updateStatus(p *peer, rtt time.Duration, err error) {
+	n.mtx.Lock()
+	wasOnline, online := p.online, err == nil
+	p.online = online
+	if online {
+		p.rtt = rtt
+		p.lastSeen = time.Now()
+	} else {
+		p.log().WithError(err).Debug("Ping failed")
+	}
+	e := Event{Peer: p.alias, PeerID: p.perunID}
+	n.mtx.Unlock()
+
+	switch {
+	case wasOnline && !online:
+		e.Type = EventPeerOffline
+		n.emit(e)
+	case !wasOnline && online:
+		e.Type, e.RTT = EventPeerOnline, rtt
+		n.emit(e)
+	}
+}
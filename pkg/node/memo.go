@@ -0,0 +1,192 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"context"
+	"crypto/sha256"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+	perunio "perun.network/go-perun/pkg/io"
+	"perun.network/go-perun/wire"
+)
+
+// MaxMemoLen is the maximal length of a memo in bytes.
+const MaxMemoLen = 256
+
+// memoType is the wire type of memo messages. It is outside of the range of
+// the Perun wire protocol.
+const memoType = wire.LastType + 1
+
+func init() {
+	wire.RegisterExternalDecoder(memoType, decodeMemoMsg, "Memo")
+}
+
+// memoMsg carries the memo of a payment. It is sent right before the update
+// with the given version and thereby arrives before it. It is bound to the
+// update by the digest of the new state, see stateDigest. A memoMsg with an
+// empty memo retracts the memo of an update that failed.
+type memoMsg struct {
+	Channel channel.ID
+	Version uint64
+	State   [32]byte
+	Memo    string
+}
+
+func (*memoMsg) Type() wire.Type {
+	return memoType
+}
+
+func (m *memoMsg) Encode(w io.Writer) error {
+	return perunio.Encode(w, m.Channel, m.Version, m.State, m.Memo)
+}
+
+func decodeMemoMsg(r io.Reader) (wire.Msg, error) {
+	var m memoMsg
+	if err := perunio.Decode(r, &m.Channel, &m.Version, &m.State, &m.Memo); err != nil {
+		return nil, err
+	}
+	if len(m.Memo) > MaxMemoLen {
+		return nil, errors.Errorf("memo too long: %d > %d", len(m.Memo), MaxMemoLen)
+	}
+	return &m, nil
+}
+
+// memos exchanges the memos of payments with the peers.
+type memos struct {
+	bus  *msgBus
+	self wire.Address
+
+	// Protects pending
+	mtx sync.Mutex
+	// pending holds the last memo of every open channel. Memos of unknown
+	// channels are dropped.
+	pending map[channel.ID]*pendingMemo
+}
+
+type pendingMemo struct {
+	peer    wire.Address
+	version uint64
+	state   [32]byte
+	memo    string
+}
+
+// stateDigest returns the hash of the encoded state, which binds a memo to
+// the update to this state.
+func stateDigest(state *channel.State) ([32]byte, error) {
+	h := sha256.New()
+	if err := state.Encode(h); err != nil {
+		return [32]byte{}, errors.WithMessage(err, "encoding state")
+	}
+	var digest [32]byte
+	copy(digest[:], h.Sum(nil))
+	return digest, nil
+}
+
+func newMemos(bus *msgBus, self wire.Address) *memos {
+	m := &memos{
+		bus:     bus,
+		self:    self,
+		pending: make(map[channel.ID]*pendingMemo),
+	}
+	bus.Handle(memoType, m.handle)
+	return m
+}
+
+// register accepts memos from `peer` for the channel `id`.
+func (m *memos) register(id channel.ID, peer wire.Address) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.pending[id] = &pendingMemo{peer: peer}
+}
+
+func (m *memos) unregister(id channel.ID) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.pending, id)
+}
+
+// send sends the memo for the update of channel `id` to `state`. An empty
+// memo retracts the memo of the update.
+func (m *memos) send(ctx context.Context, peer wire.Address, id channel.ID, state *channel.State, memo string) error {
+	digest, err := stateDigest(state)
+	if err != nil {
+		return err
+	}
+	err = m.bus.Publish(ctx, &wire.Envelope{
+		Sender:    m.self,
+		Recipient: peer,
+		Msg:       &memoMsg{Channel: id, Version: state.Version, State: digest, Memo: memo},
+	})
+	return errors.WithMessage(err, "sending memo")
+}
+
+func (m *memos) handle(e *wire.Envelope) {
+	msg := e.Msg.(*memoMsg)
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	p, ok := m.pending[msg.Channel]
+	if !ok || !p.peer.Equals(e.Sender) {
+		log.WithField("peer", e.Sender).WithField("channel", msg.Channel).Warn("Dropping memo for unknown channel")
+		return
+	}
+	if msg.Memo == "" {
+		if p.version == msg.Version && p.state == msg.State {
+			p.clear()
+		}
+		return
+	}
+	p.version, p.state, p.memo = msg.Version, msg.State, msg.Memo
+}
+
+// take returns the memo for the update of channel `id` to `state`. It returns
+// the empty string if there is none. The pending memo is dropped if its
+// update did not arrive, so that it is not attached to a later update.
+func (m *memos) take(id channel.ID, state *channel.State) string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	p, ok := m.pending[id]
+	if !ok || p.memo == "" || p.version > state.Version {
+		return ""
+	}
+	version, digest, memo := p.version, p.state, p.memo
+	p.clear()
+	if version != state.Version {
+		return ""
+	}
+	if d, err := stateDigest(state); err != nil || d != digest {
+		log.WithField("channel", id).Warn("Dropping memo for different update")
+		return ""
+	}
+	return memo
+}
+
+// expire drops the pending memo of channel `id` if its update did not arrive
+// until the channel reached `version`.
+func (m *memos) expire(id channel.ID, version uint64) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if p, ok := m.pending[id]; ok && p.version <= version {
+		p.clear()
+	}
+}
+
+func (p *pendingMemo) clear() {
+	p.version, p.state, p.memo = 0, [32]byte{}, ""
+}
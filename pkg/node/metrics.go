@@ -0,0 +1,179 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+)
+
+// Label values of the metrics.
+const (
+	dirSent     = "sent"
+	dirReceived = "received"
+
+	outcomeAccepted = "accepted"
+	outcomeRejected = "rejected"
+	outcomeError    = "error"
+
+	opFund   = "fund"
+	opSettle = "settle"
+)
+
+// Metrics are the Prometheus metrics of a node. They implement
+// prometheus.Collector and must be registered to be exported. A nil *Metrics
+// records nothing.
+type Metrics struct {
+	proposals         *prometheus.CounterVec
+	updates           *prometheus.CounterVec
+	updateDuration    prometheus.Histogram
+	collisions        prometheus.Counter
+	onChainTx         *prometheus.CounterVec
+	onChainTxDuration *prometheus.HistogramVec
+}
+
+var _ prometheus.Collector = (*Metrics)(nil)
+
+// NewMetrics returns the metrics with the given namespace, e.g. "perun_demo"
+// for perun_demo_updates_total.
+func NewMetrics(namespace string) *Metrics {
+	return &Metrics{
+		proposals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "proposals_total",
+			Help:      "Channel proposals by direction and outcome.",
+		}, []string{"direction", "outcome"}),
+
+		updates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "updates_total",
+			Help:      "Channel updates by direction and outcome.",
+		}, []string{"direction", "outcome"}),
+
+		updateDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "update_duration_seconds",
+			Help:      "Duration of sent channel updates, from proposing to acceptance by the peer.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}),
+
+		collisions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "update_collisions_total",
+			Help:      "Received updates that collided with an own update of the same version.",
+		}),
+
+		onChainTx: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "onchain_tx_total",
+			Help:      "On-chain operations by type and outcome.",
+		}, []string{"op", "outcome"}),
+
+		onChainTxDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "onchain_tx_duration_seconds",
+			Help:      "Duration of on-chain operations until confirmation.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 8),
+		}, []string{"op"}),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.proposals, m.updates, m.updateDuration, m.collisions, m.onChainTx, m.onChainTxDuration}
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// proposal counts a channel proposal.
+func (m *Metrics) proposal(dir, outcome string) {
+	if m != nil {
+		m.proposals.WithLabelValues(dir, outcome).Inc()
+	}
+}
+
+// update counts a channel update.
+func (m *Metrics) update(dir, outcome string) {
+	if m != nil {
+		m.updates.WithLabelValues(dir, outcome).Inc()
+	}
+}
+
+// updateSent records the duration of an accepted own update.
+func (m *Metrics) updateSent(d time.Duration) {
+	if m != nil {
+		m.updateDuration.Observe(d.Seconds())
+	}
+}
+
+func (m *Metrics) collision() {
+	if m != nil {
+		m.collisions.Inc()
+	}
+}
+
+// onChain records an on-chain operation that started at `start`.
+func (m *Metrics) onChain(op string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	if err == nil {
+		m.onChainTxDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+		m.onChainTx.WithLabelValues(op, outcomeAccepted).Inc()
+	} else {
+		m.onChainTx.WithLabelValues(op, outcomeError).Inc()
+	}
+}
+
+// timedFunder records the duration of the on-chain funding, from the own
+// deposit until all participants funded the channel. Unlike timing the
+// proposal, this excludes the time the peer takes to accept.
+type timedFunder struct {
+	channel.Funder
+	metrics *Metrics
+}
+
+func (f *timedFunder) Fund(ctx context.Context, req channel.FundingReq) error {
+	start := time.Now()
+	err := f.Funder.Fund(ctx, req)
+	f.metrics.onChain(opFund, start, err)
+	return err
+}
+
+// outcome returns the outcome label of an operation that returned `err`.
+func outcome(err error) string {
+	switch errors.Cause(err).(type) {
+	case nil:
+		return outcomeAccepted
+	case client.PeerRejectedError:
+		return outcomeRejected
+	default:
+		return outcomeError
+	}
+}
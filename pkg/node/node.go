@@ -0,0 +1,819 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package node is a Go SDK for Perun payment channels on Polkadot. A Node
+// connects to peers, opens ledger channels with them, sends payments and
+// settles the channels on-chain. Its methods return typed results and the
+// activity of the node, including that of its peers, is reported as events,
+// see Node.Subscribe.
+package node
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	dotchannel "github.com/perun-network/perun-polkadot-backend/channel"
+	sr25519 "github.com/perun-network/perun-polkadot-backend/pkg/sr25519"
+	dot "github.com/perun-network/perun-polkadot-backend/pkg/substrate"
+	dotwallet "github.com/perun-network/perun-polkadot-backend/wallet/sr25519"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence/keyvalue"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/pkg/sortedkv/leveldb"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+	wirenet "perun.network/go-perun/wire/net"
+	"perun.network/go-perun/wire/net/simple"
+)
+
+type (
+	// Node is a Perun node with ledger channels to its peers. Every peer has
+	// at most one channel. It is safe for concurrent use.
+	Node struct {
+		opts Options
+		log  log.Logger
+		// ctx is cancelled on Shutdown.
+		ctx    context.Context
+		cancel context.CancelFunc
+
+		bus       *msgBus
+		client    *client.Client
+		pinger    *pinger
+		memos     *memos
+		limits    *limiter
+		proposals *proposals
+		subs      subscribers
+		api       *dot.API
+
+		// Account for signing on-chain TX. Currently also the Perun-ID.
+		onChain *dotwallet.Account
+		// Account for signing off-chain TX. Currently one Account for all
+		// state channels, later one we want one Account per Channel.
+		offChain wallet.Account
+		wallet   *dotwallet.Wallet
+		// signs times the signatures of the off-chain account.
+		signs *signTimer
+
+		adjudicator channel.Adjudicator
+		funder      channel.Funder
+
+		// Protects peers and their ch, online, rtt and lastSeen fields. It
+		// must not be held during network or chain operations, use peer.mtx
+		// for that.
+		mtx   sync.Mutex
+		peers map[string]*peer
+
+		// persister is nil if persistence is disabled.
+		persister *keyvalue.PersistRestorer
+
+		// Protects closing
+		opMtx        sync.Mutex
+		closing      bool
+		ops          sync.WaitGroup // In-flight operations, see beginOp.
+		shutdownOnce sync.Once
+		stillOpen    []ChannelInfo
+		shutdownErr  error
+	}
+
+	peer struct {
+		alias   string
+		perunID wire.Address
+		// mtx serializes the operations on the channel with the peer:
+		// opening, payments and closing. It is held during network and
+		// chain operations and must be acquired before Node.mtx.
+		mtx sync.Mutex
+		// ch is nil if no channel is open.
+		ch *paymentChannel
+
+		// Liveness as determined by the last ping.
+		online   bool
+		rtt      time.Duration
+		lastSeen time.Time
+	}
+
+	// PeerInfo is a snapshot of a peer.
+	PeerInfo struct {
+		Alias   string
+		PerunID wire.Address
+		// Online, RTT and LastSeen are the result of the last ping.
+		Online   bool
+		RTT      time.Duration
+		LastSeen time.Time
+		// Channel is nil if no channel is open.
+		Channel *ChannelInfo
+	}
+
+	// OpenOptions are the options of a channel proposal.
+	OpenOptions struct {
+		// ChallengeDuration defaults to Options.ChallengeDuration.
+		ChallengeDuration time.Duration
+		// Nonce is the own share of the channel nonce, random if nil.
+		Nonce *client.NonceShare
+	}
+
+	// handler handles the proposals, updates and adjudicator events of the
+	// client, so that the Node does not export the handler methods.
+	handler struct{ n *Node }
+)
+
+// New creates a Node, connects it to the chain and starts listening for
+// peers. Persisted channels are restored and watched. Their peers are added
+// offline until they answer a ping. No events are emitted for them since
+// nobody subscribed yet, see Channels.
+func New(opts Options) (*Node, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, errors.WithMessage(err, "invalid options")
+	}
+	wallet, acc, err := setupWallet(opts.SecretKey)
+	if err != nil {
+		return nil, errors.WithMessage(err, "importing mnemonic")
+	}
+	dot, err := newDotSetup(acc, opts.Chain)
+	if err != nil {
+		return nil, errors.WithMessage(err, "creating dot setup")
+	}
+	return newNode(opts, wallet, acc, dot)
+}
+
+// newNode creates a node with the validated `opts` on the chain `dot`.
+func newNode(opts Options, wallet *dotwallet.Wallet, acc *dotwallet.Account, dot *dotSetup) (*Node, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Node{
+		opts:        opts,
+		log:         log.Get(),
+		ctx:         ctx,
+		cancel:      cancel,
+		onChain:     acc,
+		wallet:      wallet,
+		api:         dot.Api,
+		adjudicator: dot.Adjudicator,
+		funder:      &timedFunder{Funder: dot.Funder, metrics: opts.Metrics},
+		limits:      newLimiter(opts.Limits),
+		peers:       make(map[string]*peer),
+	}
+	if err := n.setup(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *Node) setup() error {
+	var err error
+
+	sk, err := sr25519.NewSKFromRng(rand.Reader)
+	if err != nil {
+		return errors.WithMessage(err, "generating off-chain account")
+	}
+	n.offChain = n.wallet.ImportSK(sk)
+	n.log.WithField("off-chain", n.offChain.Address()).Info("Generated account")
+	n.bus = newMsgBus(wirenet.NewBus(n.onChain, n.opts.Dialer))
+	n.pinger = newPinger(n.bus, n.onChain.Address(), n.opts.DialTimeout)
+	n.memos = newMemos(n.bus, n.onChain.Address())
+	n.proposals = newProposals()
+
+	n.signs = newSignTimer()
+	timed := &timedWallet{Wallet: n.wallet, timer: n.signs}
+	if n.client, err = client.New(n.onChain.Address(), n.bus, n.funder, n.adjudicator, timed); err != nil {
+		return errors.WithMessage(err, "creating client")
+	}
+
+	listeners := n.opts.Listeners
+	if len(listeners) == 0 {
+		n.log.WithField("host", n.opts.Host).Trace("Listening for connections")
+		l, err := simple.NewTCPListener(n.opts.Host)
+		if err != nil {
+			return errors.WithMessage(err, "could not start listener")
+		}
+		listeners = []wirenet.Listener{l}
+	}
+
+	n.client.OnNewChannel(n.setupChannel)
+	if err := n.setupPersistence(); err != nil {
+		return errors.WithMessage(err, "setting up persistence")
+	}
+	go n.client.Handle(handler{n}, handler{n})
+	for _, l := range listeners {
+		n.Listen(l)
+	}
+	if n.opts.PingInterval > 0 {
+		go n.pingPeers(n.opts.PingInterval)
+	}
+	return nil
+}
+
+func (n *Node) setupPersistence() error {
+	if n.opts.PersistencePath == "" {
+		n.log.Info("Persistence disabled")
+		return nil
+	}
+	n.log.Info("Starting persistence")
+	db, err := leveldb.LoadDatabase(n.opts.PersistencePath)
+	if err != nil {
+		return errors.WithMessage(err, "creating/loading database")
+	}
+	n.persister = keyvalue.NewPersistRestorer(db)
+	n.client.EnablePersistence(n.persister)
+
+	ctx, cancel := context.WithTimeout(n.ctx, n.opts.ReconnectTimeout)
+	defer cancel()
+	if err := n.client.Restore(ctx); err != nil {
+		n.log.WithError(err).Warn("Could not restore client")
+	}
+	return nil
+}
+
+func setupWallet(hexSk string) (*dotwallet.Wallet, *dotwallet.Account, error) {
+	wallet := dotwallet.NewWallet()
+	sk, err := sr25519.NewSKFromHex(hexSk)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "creating hdwallet")
+	}
+	return wallet, wallet.ImportSK(sk), nil
+}
+
+// PerunID returns the Perun ID of the node with the hex encoded secret key
+// `secretKey`, see Options.SecretKey.
+func PerunID(secretKey string) (wire.Address, error) {
+	_, acc, err := setupWallet(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return acc.Address(), nil
+}
+
+// Listen accepts the wire connections of `l` until Shutdown, in addition to
+// the listeners of the Options.
+func (n *Node) Listen(l wirenet.Listener) {
+	go n.bus.Listen(l)
+}
+
+// Address returns the on-chain address of the node, which is also its Perun
+// ID.
+func (n *Node) Address() wire.Address {
+	return n.onChain.Address()
+}
+
+// OffChainAddress returns the address that signs the channel states.
+func (n *Node) OffChainAddress() wallet.Address {
+	return n.offChain.Address()
+}
+
+// Connect dials the peer with `alias`, which must be known to the Directory,
+// and measures the round-trip time. The DialTimeout applies if `ctx` has no
+// deadline.
+func (n *Node) Connect(ctx context.Context, alias string) (PeerInfo, error) {
+	p, err := n.connect(ctx, alias)
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return p.info(), nil
+}
+
+func (n *Node) connect(ctx context.Context, alias string) (*peer, error) {
+	n.log.Traceln("Connecting...")
+	if n.getPeer(alias) != nil {
+		return nil, errors.New("Peer already connected")
+	}
+	id, host, ok := n.opts.Directory.Lookup(alias)
+	if !ok {
+		return nil, errors.Errorf("Alias '%s' unknown. Add it with 'peer add'.", alias)
+	}
+	n.opts.Dialer.Register(id, host)
+
+	// Publishing a ping dials the peer and performs the wire handshake.
+	ctx, cancel := withTimeout(ctx, n.opts.DialTimeout)
+	defer cancel()
+	rtt, err := n.pinger.Ping(ctx, id)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "%s unreachable at %s", alias, host)
+	}
+
+	p := &peer{
+		alias:    alias,
+		perunID:  id,
+		online:   true,
+		rtt:      rtt,
+		lastSeen: time.Now(),
+	}
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.peers[alias] != nil {
+		return nil, errors.New("Peer already connected")
+	}
+	n.peers[alias] = p
+	return p, nil
+}
+
+// getPeer returns the peer with `alias` or nil if not found.
+func (n *Node) getPeer(alias string) *peer {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.peers[alias]
+}
+
+// getChannel returns the channel with `p` or nil if none is open.
+func (n *Node) getChannel(p *peer) *paymentChannel {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return p.ch
+}
+
+// log returns a logger with the peer field and, if a channel is open, the
+// fields of the channel. Assumes that Node.mtx is held.
+func (p *peer) log() log.Logger {
+	if p.ch != nil {
+		return p.ch.log()
+	}
+	return log.WithField("peer", p.perunID)
+}
+
+// info returns a snapshot of the peer without its channel. Assumes that
+// Node.mtx is held.
+func (p *peer) info() PeerInfo {
+	return PeerInfo{
+		Alias:    p.alias,
+		PerunID:  p.perunID,
+		Online:   p.online,
+		RTT:      p.rtt,
+		LastSeen: p.lastSeen,
+	}
+}
+
+// peer returns the peer with the address `addr` or nil if not found. Assumes
+// that n.mtx is held.
+func (n *Node) peer(addr wire.Address) *peer {
+	for _, peer := range n.peers {
+		if peer.perunID.Equals(addr) {
+			return peer
+		}
+	}
+	return nil
+}
+
+// channel returns the channel with `id` or nil if not found. Assumes that
+// n.mtx is held.
+func (n *Node) channel(id channel.ID) *paymentChannel {
+	for _, p := range n.peers {
+		if p.ch != nil && p.ch.ID() == id {
+			return p.ch
+		}
+	}
+	return nil
+}
+
+// Peers returns a snapshot of the connected peers sorted by alias.
+func (n *Node) Peers() []PeerInfo {
+	n.mtx.Lock()
+	infos := make([]PeerInfo, 0, len(n.peers))
+	chs := make([]*paymentChannel, 0, len(n.peers))
+	for _, p := range n.peers {
+		infos = append(infos, p.info())
+		chs = append(chs, p.ch)
+	}
+	n.mtx.Unlock()
+
+	// The channels are queried without holding n.mtx.
+	for i, ch := range chs {
+		if ch != nil {
+			info := ch.info()
+			infos[i].Channel = &info
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Alias < infos[j].Alias })
+	return infos
+}
+
+// Peer returns a snapshot of the peer with `alias`.
+func (n *Node) Peer(alias string) (PeerInfo, bool) {
+	n.mtx.Lock()
+	p, ok := n.peers[alias]
+	if !ok {
+		n.mtx.Unlock()
+		return PeerInfo{}, false
+	}
+	info, ch := p.info(), p.ch
+	n.mtx.Unlock()
+	if ch != nil {
+		chInfo := ch.info()
+		info.Channel = &chInfo
+	}
+	return info, true
+}
+
+// Channels returns a snapshot of the open channels sorted by the alias of the
+// peer.
+func (n *Node) Channels() []ChannelInfo {
+	var chs []ChannelInfo
+	for _, p := range n.Peers() {
+		if p.Channel != nil {
+			chs = append(chs, *p.Channel)
+		}
+	}
+	return chs
+}
+
+// ChannelPeer returns the alias and Perun ID of the peer of the open channel
+// `id` and the number of update collisions of the channel. Unlike Channels,
+// it does not wait for a running update of the channel, so it may be called
+// from message handlers and subscribers.
+func (n *Node) ChannelPeer(id channel.ID) (alias string, peerID wire.Address, collisions uint64, ok bool) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	ch := n.channel(id)
+	if ch == nil {
+		return "", nil, 0, false
+	}
+	return ch.alias, ch.Peers()[1-ch.Idx()], atomic.LoadUint64(&ch.collisions), true // assumes two-party channel
+}
+
+// RemovePeer forgets the connected peer with `alias`. It fails if a channel
+// with the peer is open.
+func (n *Node) RemovePeer(alias string) error {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if p := n.peers[alias]; p != nil && p.ch != nil {
+		return errors.Errorf("Close the channel with '%s' first", alias)
+	}
+	delete(n.peers, alias)
+	return nil
+}
+
+// RenamePeer renames the connected peer with the Perun ID `id` to `alias`,
+// e.g. when a peer that was accepted as unknown identity was added to the
+// Directory. It does nothing if the peer is not connected.
+func (n *Node) RenamePeer(id wire.Address, alias string) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if p := n.peer(id); p != nil && p.alias != alias {
+		delete(n.peers, p.alias)
+		p.alias = alias
+		n.peers[alias] = p
+	}
+}
+
+func (n *Node) setupChannel(ch *client.Channel) {
+	if len(ch.Peers()) != 2 {
+		n.log.WithField("channel", ch.ID()).Error("Only channels with two participants are currently supported")
+		return
+	}
+
+	perunID := ch.Peers()[1-ch.Idx()] // assumes two-party channel
+	n.mtx.Lock()
+	p := n.peer(perunID)
+	if p == nil {
+		// Restored channels are set up before their peer is connected.
+		p = n.addRestoredPeer(perunID)
+	} else if p.ch != nil {
+		log := p.log()
+		n.mtx.Unlock()
+		log.Warn("Peer tried to open more than one channel")
+		return
+	}
+	pch := n.newPaymentChannel(ch, p.alias)
+	p.ch = pch
+	n.mtx.Unlock()
+
+	// Start watching.
+	go func() {
+		pch.log().Debug("Watcher started")
+		err := ch.Watch(handler{n})
+		pch.log().WithError(err).Debug("Watcher stopped")
+	}()
+
+	n.emit(newChannelEvent(EventChannelOpened, pch, ch.State()))
+}
+
+// addRestoredPeer adds the peer of a restored channel. It is resolved by the
+// Directory and stays offline until it answers a ping. Assumes that n.mtx is
+// held.
+func (n *Node) addRestoredPeer(id wire.Address) *peer {
+	alias, known := n.opts.Directory.Alias(id)
+	if known {
+		if _, host, ok := n.opts.Directory.Lookup(alias); ok {
+			n.opts.Dialer.Register(id, host)
+		}
+	} else {
+		// The peer was accepted as unknown identity, it can only reconnect
+		// to us.
+		alias = n.unknownAlias(id)
+	}
+	p := &peer{alias: alias, perunID: id}
+	n.peers[alias] = p
+	n.log.WithField("peer", id).WithField("alias", alias).Debug("Restored peer")
+	return p
+}
+
+// channelInfo returns the info of the channel `id` with `p` after it was set
+// up by setupChannel.
+func (n *Node) channelInfo(p *peer, id channel.ID) (ChannelInfo, error) {
+	ch := n.getChannel(p)
+	if ch == nil || ch.ID() != id {
+		return ChannelInfo{}, errors.New("OnNewChannel handler could not setup channel")
+	}
+	return ch.info(), nil
+}
+
+// Open proposes a channel with the balances `myBal` and `peerBal` in Plank to
+// the peer with `alias` and waits until it is funded. The peer is connected
+// first if necessary. The FundTimeout applies if `ctx` has no deadline.
+func (n *Node) Open(ctx context.Context, alias string, myBal, peerBal *big.Int, opts OpenOptions) (ChannelInfo, error) {
+	if err := n.beginOp(); err != nil {
+		return ChannelInfo{}, err
+	}
+	defer n.endOp()
+	p := n.getPeer(alias)
+	if p == nil {
+		var err error
+		if p, err = n.connect(ctx, alias); err != nil {
+			return ChannelInfo{}, err
+		}
+	}
+	return n.openChannel(ctx, p, []*big.Int{myBal, peerBal}, opts)
+}
+
+// openChannel proposes a channel with the balances `bals` to `p` and waits
+// until it is funded.
+func (n *Node) openChannel(ctx context.Context, p *peer, bals []*big.Int, opts OpenOptions) (ChannelInfo, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if n.getChannel(p) != nil {
+		return ChannelInfo{}, errors.Errorf("Channel with %s already open", p.alias)
+	}
+	challenge := opts.ChallengeDuration
+	if challenge == 0 {
+		challenge = n.opts.ChallengeDuration
+	} else if challenge < time.Second {
+		return ChannelInfo{}, errors.New("challenge duration must be at least 1s")
+	}
+	nonce := client.WithRandomNonce()
+	if opts.Nonce != nil {
+		nonce = client.WithNonce(*opts.Nonce)
+	}
+	initBals := &channel.Allocation{
+		Assets:   []channel.Asset{dotchannel.Asset},
+		Balances: [][]*big.Int{bals},
+	}
+
+	prop, err := client.NewLedgerChannelProposal(
+		uint64(challenge/time.Second),
+		n.offChain.Address(),
+		initBals,
+		[]wire.Address{n.onChain.Address(), p.perunID},
+		nonce,
+	)
+	if err != nil {
+		return ChannelInfo{}, errors.WithMessage(err, "creating channel proposal")
+	}
+
+	ctx, cancel := withTimeout(ctx, n.opts.FundTimeout)
+	defer cancel()
+	n.log.Debug("Proposing channel")
+	ch, err := n.client.ProposeChannel(ctx, prop)
+	n.opts.Metrics.proposal(dirSent, outcome(err))
+	if err != nil {
+		return ChannelInfo{}, errors.WithMessage(err, "proposing channel failed")
+	}
+	return n.channelInfo(p, ch.ID())
+}
+
+// Send sends `amount` Plank to the peer with `alias` over the open channel.
+// The optional memo of at most MaxMemoLen bytes is sent along. The
+// UpdateTimeout applies if `ctx` has no deadline.
+func (n *Node) Send(ctx context.Context, alias string, amount *big.Int, memo string) (Payment, error) {
+	if err := n.beginOp(); err != nil {
+		return Payment{}, err
+	}
+	defer n.endOp()
+	n.log.Traceln("Sending...")
+
+	if len(memo) > MaxMemoLen {
+		return Payment{}, errors.Errorf("Memo must not be longer than %d bytes", MaxMemoLen)
+	}
+	p := n.getPeer(alias)
+	if p == nil {
+		return Payment{}, errors.Errorf("peer not found %s", alias)
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	ch := n.getChannel(p)
+	if ch == nil {
+		return Payment{}, errors.Errorf("connect to peer first")
+	}
+	ctx, cancel := withTimeout(ctx, n.opts.UpdateTimeout)
+	defer cancel()
+	return ch.sendMoney(ctx, amount, memo)
+}
+
+// Finalize agrees with the peer with `alias` on the current state of their
+// channel as final state, so that it can be settled without waiting for the
+// challenge duration. The UpdateTimeout applies if `ctx` has no deadline.
+func (n *Node) Finalize(ctx context.Context, alias string) (ChannelInfo, error) {
+	if err := n.beginOp(); err != nil {
+		return ChannelInfo{}, err
+	}
+	defer n.endOp()
+	_, ch, unlock, err := n.lockChannel(alias)
+	if err != nil {
+		return ChannelInfo{}, err
+	}
+	defer unlock()
+
+	ctx, cancel := withTimeout(ctx, n.opts.UpdateTimeout)
+	defer cancel()
+	if err := ch.sendFinal(ctx); err != nil {
+		return ChannelInfo{}, errors.WithMessage(err, "sending final state")
+	}
+	return ch.info(), nil
+}
+
+// Settle concludes the channel with the peer with `alias` on-chain, withdraws
+// the own balance and removes the channel. It returns the last state of the
+// channel. The SettleTimeout applies if `ctx` has no deadline.
+func (n *Node) Settle(ctx context.Context, alias string) (ChannelInfo, error) {
+	if err := n.beginOp(); err != nil {
+		return ChannelInfo{}, err
+	}
+	defer n.endOp()
+	p, ch, unlock, err := n.lockChannel(alias)
+	if err != nil {
+		return ChannelInfo{}, err
+	}
+	defer unlock()
+
+	ctx, cancel := withTimeout(ctx, n.opts.SettleTimeout)
+	defer cancel()
+	return n.settle(ctx, p, ch)
+}
+
+// CloseChannel finalizes and settles the channel with the peer with `alias`,
+// see Finalize and Settle.
+func (n *Node) CloseChannel(ctx context.Context, alias string) (ChannelInfo, error) {
+	if err := n.beginOp(); err != nil {
+		return ChannelInfo{}, err
+	}
+	defer n.endOp()
+	n.log.Traceln("Closing...")
+	p, ch, unlock, err := n.lockChannel(alias)
+	if err != nil {
+		return ChannelInfo{}, err
+	}
+	defer unlock()
+	return n.closeChannel(ctx, p, ch)
+}
+
+// lockChannel locks the peer with `alias` and returns its open channel.
+// `unlock` must be called if no error is returned.
+func (n *Node) lockChannel(alias string) (p *peer, ch *paymentChannel, unlock func(), err error) {
+	if p = n.getPeer(alias); p == nil {
+		return nil, nil, nil, errors.Errorf("Unknown peer: %s", alias)
+	}
+	p.mtx.Lock()
+	if ch = n.getChannel(p); ch == nil {
+		p.mtx.Unlock()
+		return nil, nil, nil, errors.Errorf("No open channel with %s", alias)
+	}
+	return p, ch, p.mtx.Unlock, nil
+}
+
+// closeChannel finalizes and settles the channel `ch` with `p`. Assumes that
+// p.mtx is held.
+func (n *Node) closeChannel(ctx context.Context, p *peer, ch *paymentChannel) (ChannelInfo, error) {
+	updateCtx, cancel := withTimeout(ctx, n.opts.UpdateTimeout)
+	defer cancel()
+	if err := ch.sendFinal(updateCtx); err != nil {
+		return ChannelInfo{}, errors.WithMessage(err, "sending final state for state closing")
+	}
+
+	settleCtx, cancel := withTimeout(ctx, n.opts.SettleTimeout)
+	defer cancel()
+	info, err := n.settle(settleCtx, p, ch)
+	return info, errors.WithMessage(err, "settling")
+}
+
+// settle settles the channel `ch` with `p` and removes it. Assumes that p.mtx
+// is held.
+func (n *Node) settle(ctx context.Context, p *peer, ch *paymentChannel) (ChannelInfo, error) {
+	ch.log().Debug("Settling")
+	start := time.Now()
+	err := ch.Settle(ctx, false)
+	n.opts.Metrics.onChain(opSettle, start, err)
+	if err != nil {
+		return ChannelInfo{}, errors.WithMessage(err, "settling the channel")
+	}
+	info := ch.info()
+	n.emit(newChannelEvent(EventChannelSettled, ch, ch.State()))
+
+	if err := ch.Close(); err != nil {
+		return info, errors.WithMessage(err, "channel closing")
+	}
+	ch.log().Debug("Removing channel")
+	n.memos.unregister(ch.ID())
+	n.mtx.Lock()
+	p.ch = nil
+	n.mtx.Unlock()
+	return info, nil
+}
+
+// HandleMessage calls `handle` for every received wire message of type `t`,
+// which must be outside of the range of the Perun wire protocol. The node
+// itself uses wire.LastType+1 for memos. Messages that arrive before are
+// dropped. `handle` is called on the receive loop of the peer connection and
+// must not block.
+func (n *Node) HandleMessage(t wire.Type, handle func(*wire.Envelope)) {
+	n.bus.Handle(t, handle)
+}
+
+// Publish sends the wire message `msg` to the peer with the Perun ID `peer`.
+func (n *Node) Publish(ctx context.Context, peer wire.Address, msg wire.Msg) error {
+	return n.bus.Publish(ctx, &wire.Envelope{Sender: n.onChain.Address(), Recipient: peer, Msg: msg})
+}
+
+func (h handler) HandleProposal(prop client.ChannelProposal, res *client.ProposalResponder) {
+	h.n.handleProposal(prop, res)
+}
+
+func (h handler) HandleUpdate(old *channel.State, update client.ChannelUpdate, res *client.UpdateResponder) {
+	n := h.n
+	log := n.log.WithField("channel", update.State.ID)
+	if err := n.beginOp(); err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), n.opts.UpdateTimeout)
+		defer cancel()
+		if err := res.Reject(ctx, "shutting down"); err != nil {
+			log.WithError(err).Warn("Rejecting update")
+		}
+		return
+	}
+	defer n.endOp()
+	log.Debug("Channel update")
+
+	n.mtx.Lock()
+	ch := n.channel(update.State.ID)
+	n.mtx.Unlock()
+	if ch == nil {
+		log.Error("Channel for ID not found")
+		return
+	}
+	ch.Handle(old, update, res)
+}
+
+// HandleAdjudicatorEvent settles a channel that was concluded on-chain by the
+// peer.
+func (h handler) HandleAdjudicatorEvent(e channel.AdjudicatorEvent) {
+	if _, ok := e.(*channel.ConcludedEvent); !ok {
+		return
+	}
+	n := h.n
+	n.mtx.Lock()
+	ch := n.channel(e.ID())
+	var p *peer
+	if ch != nil {
+		p = n.peer(ch.Peers()[1-ch.Idx()]) // assumes two-party channel
+	}
+	n.mtx.Unlock()
+	if ch != nil {
+		n.emit(newChannelEvent(EventChannelConcluded, ch, ch.State()))
+	} else {
+		n.emit(Event{Type: EventChannelConcluded, Channel: e.ID()})
+	}
+	if p == nil {
+		// If we initiated the channel closing, then the channel should
+		// already be removed and we return.
+		return
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if n.getChannel(p) != ch {
+		// Closed while we waited for the lock.
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), n.opts.SettleTimeout)
+	defer cancel()
+	if _, err := n.settle(ctx, p, ch); err != nil {
+		n.log.WithField("channel", e.ID()).WithError(err).Error("Settling concluded channel")
+		n.emit(Event{Type: EventError, Peer: ch.alias, PeerID: p.perunID, Channel: e.ID(), Err: err})
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	wirenet "perun.network/go-perun/wire/net"
+	"perun.network/go-perun/wire/net/simple"
+)
+
+// Defaults of the Options.
+const (
+	DefaultTimeout           = 30 * time.Second
+	DefaultChallengeDuration = 60 * time.Second
+	DefaultBlockQueryDepth   = 100
+)
+
+// Options configure a Node. Zero values are replaced by the defaults.
+type Options struct {
+	// SecretKey is the hex encoded sr25519 secret key of the on-chain
+	// account. Its address is also the Perun ID of the node. Required.
+	SecretKey string
+	// Chain configures the connection to the blockchain.
+	Chain ChainOptions
+
+	// Host is the host:port on which the node accepts TCP connections if no
+	// Listeners are given.
+	Host string
+	// Listeners accept the wire connections of the peers, e.g. over TLS or
+	// WebSockets. Optional.
+	Listeners []wirenet.Listener
+	// Dialer dials the peers. Defaults to TCP.
+	Dialer Dialer
+	// Directory resolves the peers by their alias. Defaults to an empty
+	// MemDirectory.
+	Directory Directory
+
+	// DialTimeout is the timeout for dialing and pinging a peer.
+	DialTimeout time.Duration
+	// HandleTimeout is the timeout for answering channel proposals and for
+	// the in-flight operations on shutdown.
+	HandleTimeout time.Duration
+	// ReconnectTimeout is the timeout for restoring the persisted channels.
+	ReconnectTimeout time.Duration
+	// UpdateTimeout is the timeout of a channel update.
+	UpdateTimeout time.Duration
+	// FundTimeout is the timeout of a channel proposal and its funding.
+	// Received proposals that are not decided within it are rejected.
+	FundTimeout time.Duration
+	// SettleTimeout is the timeout of the settlement of a channel.
+	SettleTimeout time.Duration
+	// ChallengeDuration of the proposed channels, at least one second.
+	ChallengeDuration time.Duration
+	// PingInterval is the interval in which connected peers are pinged.
+	// Zero disables the pings.
+	PingInterval time.Duration
+
+	// PersistencePath is the LevelDB directory in which the channels are
+	// persisted. Empty disables the persistence.
+	PersistencePath string
+	// CloseOnShutdown closes the open channels cooperatively on Shutdown.
+	// Otherwise they stay open.
+	CloseOnShutdown bool
+	// Limits limit the payments of the node.
+	Limits Limits
+	// Metrics record the activity of the node. Optional.
+	Metrics *Metrics
+}
+
+// withDefaults returns the options with the defaults for zero values.
+func (o Options) withDefaults() Options {
+	for _, d := range []*time.Duration{
+		&o.DialTimeout, &o.HandleTimeout, &o.ReconnectTimeout,
+		&o.UpdateTimeout, &o.FundTimeout, &o.SettleTimeout, &o.Chain.TxTimeout,
+	} {
+		if *d <= 0 {
+			*d = DefaultTimeout
+		}
+	}
+	if o.ChallengeDuration <= 0 {
+		o.ChallengeDuration = DefaultChallengeDuration
+	}
+	if o.Chain.BlockQueryDepth == 0 {
+		o.Chain.BlockQueryDepth = DefaultBlockQueryDepth
+	}
+	if o.Dialer == nil {
+		o.Dialer = simple.NewTCPDialer(o.DialTimeout)
+	}
+	if o.Directory == nil {
+		o.Directory = NewMemDirectory()
+	}
+	return o
+}
+
+// validate checks the options for some obvious errors.
+func (o *Options) validate() error {
+	switch {
+	case o.SecretKey == "":
+		return errors.New("empty secret key")
+	case o.Host == "" && len(o.Listeners) == 0:
+		return errors.New("empty host")
+	case o.ChallengeDuration < time.Second:
+		return errors.New("challenge duration must be at least 1s")
+	default:
+		return o.Chain.validate()
+	}
+}
+
+// withTimeout returns a context with the default `timeout` if `ctx` has no
+// deadline. Callers can so override the timeouts of the Options.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"testing"
+	"time"
+
+	wirenet "perun.network/go-perun/wire/net"
+)
+
+func TestOptionsWithDefaults(t *testing.T) {
+	o := Options{}.withDefaults()
+	for name, d := range map[string]time.Duration{
+		"DialTimeout":      o.DialTimeout,
+		"HandleTimeout":    o.HandleTimeout,
+		"ReconnectTimeout": o.ReconnectTimeout,
+		"UpdateTimeout":    o.UpdateTimeout,
+		"FundTimeout":      o.FundTimeout,
+		"SettleTimeout":    o.SettleTimeout,
+		"Chain.TxTimeout":  o.Chain.TxTimeout,
+	} {
+		if d != DefaultTimeout {
+			t.Errorf("%s = %v, want %v", name, d, DefaultTimeout)
+		}
+	}
+	if o.ChallengeDuration != DefaultChallengeDuration {
+		t.Errorf("ChallengeDuration = %v, want %v", o.ChallengeDuration, DefaultChallengeDuration)
+	}
+	if o.Chain.BlockQueryDepth != DefaultBlockQueryDepth {
+		t.Errorf("Chain.BlockQueryDepth = %d, want %d", o.Chain.BlockQueryDepth, DefaultBlockQueryDepth)
+	}
+	if o.Dialer == nil || o.Directory == nil {
+		t.Error("Dialer and Directory must default to non-nil")
+	}
+}
+
+func TestOptionsWithDefaultsKeepsValues(t *testing.T) {
+	dir := NewMemDirectory()
+	o := Options{
+		FundTimeout:       time.Minute,
+		ChallengeDuration: 2 * time.Minute,
+		Chain:             ChainOptions{BlockQueryDepth: 10},
+		Directory:         dir,
+	}.withDefaults()
+	if o.FundTimeout != time.Minute || o.ChallengeDuration != 2*time.Minute || o.Chain.BlockQueryDepth != 10 {
+		t.Errorf("withDefaults replaced set values: %+v", o)
+	}
+	if o.Directory != dir {
+		t.Error("withDefaults replaced the Directory")
+	}
+	if o.UpdateTimeout != DefaultTimeout {
+		t.Errorf("UpdateTimeout = %v, want %v", o.UpdateTimeout, DefaultTimeout)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	valid := func() Options {
+		return Options{
+			SecretKey: "0x01",
+			Host:      "127.0.0.1:5750",
+			Chain:     ChainOptions{NodeURL: "ws://127.0.0.1:9944"},
+		}.withDefaults()
+	}
+	tests := []struct {
+		name   string
+		modify func(*Options)
+		valid  bool
+	}{
+		{"valid", func(*Options) {}, true},
+		{"listeners instead of host", func(o *Options) { o.Host, o.Listeners = "", make([]wirenet.Listener, 1) }, true},
+		{"empty secret key", func(o *Options) { o.SecretKey = "" }, false},
+		{"empty host", func(o *Options) { o.Host = "" }, false},
+		{"short challenge duration", func(o *Options) { o.ChallengeDuration = 500 * time.Millisecond }, false},
+		{"empty node url", func(o *Options) { o.Chain.NodeURL = "" }, false},
+		{"zero block query depth", func(o *Options) { o.Chain.BlockQueryDepth = 0 }, false},
+		{"block query depth too large", func(o *Options) { o.Chain.BlockQueryDepth = 1001 }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := valid()
+			tt.modify(&o)
+			if err := o.validate(); (err == nil) != tt.valid {
+				t.Errorf("validate() = %v, want valid %t", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"context"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/wire"
+)
+
+// ErrUnknownProposal is returned for a proposal that was already decided or
+// expired.
+var ErrUnknownProposal = errors.New("Proposal not found or already decided")
+
+const (
+	// maxPeerProposals is the number of pending proposals of a peer.
+	// Further proposals of the peer are rejected.
+	maxPeerProposals = 4
+	// maxProposals is the number of pending proposals of all peers.
+	maxProposals = 64
+)
+
+type (
+	// Proposal is a channel proposal of a peer that waits for a decision,
+	// see Node.Accept and Node.Reject. Balances are in Plank. It is rejected
+	// if it is not decided within the FundTimeout, since the peer stops
+	// waiting for it by then.
+	Proposal struct {
+		ID     uint64
+		Peer   string
+		PeerID wire.Address
+		// Unknown is set if the Directory does not know the peer. Peer is
+		// then a temporary alias derived from PeerID, under which the peer
+		// is added if the proposal is accepted.
+		Unknown           bool
+		MyBalance         *big.Int
+		PeerBalance       *big.Int
+		ChallengeDuration time.Duration
+		Received          time.Time
+
+		peer  *peer
+		req   *client.LedgerChannelProposal
+		res   *client.ProposalResponder
+		timer *time.Timer // Expires the proposal.
+	}
+
+	// proposals are the proposals that were neither accepted nor rejected
+	// yet.
+	proposals struct {
+		mtx     sync.Mutex
+		next    uint64
+		pending map[uint64]*Proposal
+	}
+)
+
+func newProposals() *proposals {
+	return &proposals{pending: make(map[uint64]*Proposal)}
+}
+
+// add adds a proposal, sets its ID and calls `expire` with it after `ttl`
+// unless it was taken. It returns false if the peer or all peers have too
+// many pending proposals.
+func (ps *proposals) add(p *Proposal, ttl time.Duration, expire func(id uint64)) bool {
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+	if len(ps.pending) >= maxProposals {
+		return false
+	}
+	count := 0
+	for _, q := range ps.pending {
+		if q.PeerID.Equals(p.PeerID) {
+			count++
+		}
+	}
+	if count >= maxPeerProposals {
+		return false
+	}
+	ps.next++
+	p.ID = ps.next
+	ps.pending[p.ID] = p
+	id := p.ID
+	p.timer = time.AfterFunc(ttl, func() { expire(id) })
+	return true
+}
+
+// take removes the proposal with `id` to decide it. It returns false if the
+// proposal was already decided or expired.
+func (ps *proposals) take(id uint64) (*Proposal, bool) {
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+	p, ok := ps.pending[id]
+	if ok {
+		p.timer.Stop()
+		delete(ps.pending, id)
+	}
+	return p, ok
+}
+
+// Proposals returns the pending proposals, the oldest first.
+func (n *Node) Proposals() []Proposal {
+	ps := n.proposals
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+	list := make([]Proposal, 0, len(ps.pending))
+	for _, p := range ps.pending {
+		list = append(list, *p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Accept accepts the proposal with `id` and waits until the channel is
+// funded. The HandleTimeout applies if `ctx` has no deadline.
+func (n *Node) Accept(ctx context.Context, id uint64) (ChannelInfo, error) {
+	prop, ok := n.proposals.take(id)
+	if !ok {
+		return ChannelInfo{}, ErrUnknownProposal
+	}
+	if prop.Unknown {
+		n.mtx.Lock()
+		switch p := n.peers[prop.Peer]; {
+		case p == nil:
+			n.peers[prop.Peer] = prop.peer
+		case p.perunID.Equals(prop.PeerID):
+			// Added by an earlier proposal of the peer.
+			prop.peer = p
+		default:
+			n.mtx.Unlock()
+			err := errors.Errorf("Alias '%s' of unknown peer %v is used by another peer", prop.Peer, prop.PeerID)
+			n.opts.Metrics.proposal(dirReceived, outcomeRejected)
+			rctx, cancel := withTimeout(ctx, n.opts.HandleTimeout)
+			defer cancel()
+			if rerr := prop.res.Reject(rctx, "alias collision"); rerr != nil {
+				n.log.Error(errors.WithMessage(rerr, "rejecting channel proposal"))
+			}
+			return ChannelInfo{}, err
+		}
+		n.mtx.Unlock()
+		n.log.WithField("peer", prop.PeerID).WithField("alias", prop.Peer).Info("Added unknown peer")
+	}
+
+	ctx, cancel := withTimeout(ctx, n.opts.HandleTimeout)
+	defer cancel()
+	a := prop.req.Accept(n.offChain.Address(), client.WithRandomNonce())
+	ch, err := prop.res.Accept(ctx, a)
+	n.opts.Metrics.proposal(dirReceived, outcome(err))
+	if err != nil {
+		return ChannelInfo{}, errors.WithMessage(err, "accepting channel proposal")
+	}
+	return n.channelInfo(prop.peer, ch.ID())
+}
+
+// Reject rejects the proposal with `id` for `reason`, which is sent to the
+// peer.
+func (n *Node) Reject(ctx context.Context, id uint64, reason string) error {
+	prop, ok := n.proposals.take(id)
+	if !ok {
+		return ErrUnknownProposal
+	}
+	n.opts.Metrics.proposal(dirReceived, outcomeRejected)
+	ctx, cancel := withTimeout(ctx, n.opts.HandleTimeout)
+	defer cancel()
+	return errors.WithMessage(prop.res.Reject(ctx, reason), "rejecting channel proposal")
+}
+
+// handleProposal records a proposal of a peer until it is decided and emits
+// it.
+func (n *Node) handleProposal(prop client.ChannelProposal, res *client.ProposalResponder) {
+	req, ok := prop.(*client.LedgerChannelProposal)
+	if !ok || len(req.Peers) != 2 {
+		n.log.Warn("Rejecting channel proposal: only two-party ledger channels are supported")
+		ctx, cancel := context.WithTimeout(n.ctx, n.opts.HandleTimeout)
+		defer cancel()
+		if err := res.Reject(ctx, "only two-party ledger channels are supported"); err != nil {
+			n.log.Error(errors.WithMessage(err, "rejecting channel proposal"))
+		}
+		return
+	}
+
+	id := req.Peers[0]
+	n.log.WithField("peer", id).Debug("Channel proposal")
+
+	// Find the peer by its perunID and create it if not present
+	n.mtx.Lock()
+	p := n.peer(id)
+	unknown := false
+	if p == nil {
+		alias, known := n.opts.Directory.Alias(id)
+		if unknown = !known; unknown {
+			// Unknown identities get a temporary alias derived from their
+			// Perun ID. They are only added if the proposal is accepted.
+			alias = n.unknownAlias(id)
+		}
+		p = &peer{
+			alias:   alias,
+			perunID: id,
+			// The peer just sent us a proposal.
+			online:   true,
+			lastSeen: time.Now(),
+		}
+		if !unknown {
+			n.peers[alias] = p
+			n.log.WithField("peer", id).WithField("alias", alias).Debug("New peer")
+		}
+	}
+	alias := p.alias
+	n.mtx.Unlock()
+
+	bals := req.InitBals.Balances[0]
+	pending := &Proposal{
+		Peer:              alias,
+		PeerID:            id,
+		Unknown:           unknown,
+		MyBalance:         new(big.Int).Set(bals[1]), // proposal receiver has index 1
+		PeerBalance:       new(big.Int).Set(bals[0]), // proposer has index 0
+		ChallengeDuration: time.Duration(req.ChallengeDuration) * time.Second,
+		Received:          time.Now(),
+		peer:              p,
+		req:               req,
+		res:               res,
+	}
+	if !n.proposals.add(pending, n.opts.FundTimeout, n.expireProposal) {
+		n.log.WithField("peer", id).Warn("Rejecting channel proposal: too many pending proposals")
+		n.opts.Metrics.proposal(dirReceived, outcomeRejected)
+		ctx, cancel := context.WithTimeout(n.ctx, n.opts.HandleTimeout)
+		defer cancel()
+		if err := res.Reject(ctx, "too many pending proposals"); err != nil {
+			n.log.Error(errors.WithMessage(err, "rejecting channel proposal"))
+		}
+		return
+	}
+	n.emit(Event{
+		Type:        EventProposalReceived,
+		Peer:        alias,
+		PeerID:      id,
+		MyBalance:   pending.MyBalance,
+		PeerBalance: pending.PeerBalance,
+		Proposal:    pending,
+	})
+}
+
+// expireProposal rejects the proposal with `id` if it is still pending.
+func (n *Node) expireProposal(id uint64) {
+	prop, ok := n.proposals.take(id)
+	if !ok {
+		return
+	}
+	n.log.WithField("peer", prop.PeerID).Info("Channel proposal expired")
+	n.opts.Metrics.proposal(dirReceived, outcomeRejected)
+	n.emit(Event{
+		Type:        EventProposalExpired,
+		Peer:        prop.Peer,
+		PeerID:      prop.PeerID,
+		MyBalance:   prop.MyBalance,
+		PeerBalance: prop.PeerBalance,
+		Proposal:    prop,
+	})
+	ctx, cancel := context.WithTimeout(n.ctx, n.opts.HandleTimeout)
+	defer cancel()
+	if err := prop.res.Reject(ctx, "proposal expired"); err != nil {
+		n.log.WithError(err).Debug("Rejecting expired channel proposal")
+	}
+}
+
+// unknownAlias returns the temporary alias of a peer that is not in the
+// Directory. It is the start of its Perun ID, or the whole ID if the start is
+// already used by another peer. Assumes that n.mtx is held.
+func (n *Node) unknownAlias(id wire.Address) string {
+	str := id.String()
+	if len(str) <= 10 {
+		return str
+	}
+	short := str[:10]
+	_, _, inDir := n.opts.Directory.Lookup(short)
+	if p := n.peers[short]; inDir || (p != nil && !p.perunID.Equals(id)) ||
+		n.proposals.aliasTaken(short, id) {
+		return str
+	}
+	return short
+}
+
+// aliasTaken returns whether a pending proposal of a peer other than `id`
+// uses `alias`.
+func (ps *proposals) aliasTaken(alias string, id wire.Address) bool {
+	ps.mtx.Lock()
+	defer ps.mtx.Unlock()
+	for _, p := range ps.pending {
+		if p.Peer == alias && !p.PeerID.Equals(id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestProposalsAddTake(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ps := newProposals()
+	never := func(uint64) { t.Error("Proposal expired") }
+
+	a, b := &Proposal{PeerID: newRandomAddress(rng)}, &Proposal{PeerID: newRandomAddress(rng)}
+	if !ps.add(a, time.Hour, never) || !ps.add(b, time.Hour, never) {
+		t.Fatal("add rejected a proposal")
+	}
+	if a.ID == 0 || b.ID <= a.ID {
+		t.Errorf("IDs not increasing: %d, %d", a.ID, b.ID)
+	}
+
+	if p, ok := ps.take(a.ID); !ok || p != a {
+		t.Errorf("take(%d) = %v, %t", a.ID, p, ok)
+	}
+	if _, ok := ps.take(a.ID); ok {
+		t.Error("took a proposal twice")
+	}
+	n := &Node{proposals: ps}
+	if list := n.Proposals(); len(list) != 1 || list[0].ID != b.ID {
+		t.Errorf("Proposals() = %v, want only %d", list, b.ID)
+	}
+}
+
+func TestProposalsCap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ps := newProposals()
+	noop := func(uint64) {}
+
+	peer := newRandomAddress(rng)
+	for i := 0; i < maxPeerProposals; i++ {
+		if !ps.add(&Proposal{PeerID: peer}, time.Hour, noop) {
+			t.Fatalf("add rejected proposal %d of the peer", i)
+		}
+	}
+	if ps.add(&Proposal{PeerID: peer}, time.Hour, noop) {
+		t.Error("add exceeded the limit per peer")
+	}
+
+	for i := maxPeerProposals; i < maxProposals; i++ {
+		if !ps.add(&Proposal{PeerID: newRandomAddress(rng)}, time.Hour, noop) {
+			t.Fatalf("add rejected proposal %d", i)
+		}
+	}
+	if ps.add(&Proposal{PeerID: newRandomAddress(rng)}, time.Hour, noop) {
+		t.Error("add exceeded the global limit")
+	}
+}
+
+func TestProposalsExpire(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ps := newProposals()
+	expired := make(chan uint64, 2)
+	expire := func(id uint64) { expired <- id }
+
+	p := &Proposal{PeerID: newRandomAddress(rng)}
+	ps.add(p, 10*time.Millisecond, expire)
+	select {
+	case id := <-expired:
+		if id != p.ID {
+			t.Errorf("expired %d, want %d", id, p.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Proposal did not expire")
+	}
+
+	// Taken proposals do not expire.
+	taken := &Proposal{PeerID: newRandomAddress(rng)}
+	ps.add(taken, 50*time.Millisecond, expire)
+	ps.take(taken.ID)
+	select {
+	case id := <-expired:
+		t.Errorf("Taken proposal %d expired", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUnknownAlias(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	id, other := newRandomAddress(rng), newRandomAddress(rng)
+	short, full := id.String()[:10], id.String()
+	newTestNode := func() *Node {
+		return &Node{
+			opts:      Options{Directory: NewMemDirectory()},
+			peers:     make(map[string]*peer),
+			proposals: newProposals(),
+		}
+	}
+
+	if alias := newTestNode().unknownAlias(id); alias != short {
+		t.Errorf("Free alias: %s, want %s", alias, short)
+	}
+
+	n := newTestNode()
+	n.peers[short] = &peer{alias: short, perunID: id}
+	if alias := n.unknownAlias(id); alias != short {
+		t.Errorf("Alias of the same peer: %s, want %s", alias, short)
+	}
+
+	n = newTestNode()
+	n.peers[short] = &peer{alias: short, perunID: other}
+	if alias := n.unknownAlias(id); alias != full {
+		t.Errorf("Alias used by a peer: %s, want %s", alias, full)
+	}
+
+	n = newTestNode()
+	n.proposals.add(&Proposal{Peer: short, PeerID: other}, time.Hour, func(uint64) {})
+	if alias := n.unknownAlias(id); alias != full {
+		t.Errorf("Alias used by a proposal: %s, want %s", alias, full)
+	}
+
+	n = newTestNode()
+	n.opts.Directory.(*MemDirectory).Add(short, other, "")
+	if alias := n.unknownAlias(id); alias != full {
+		t.Errorf("Alias in the directory: %s, want %s", alias, full)
+	}
+}
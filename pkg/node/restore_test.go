@@ -0,0 +1,160 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	sr25519 "github.com/perun-network/perun-polkadot-backend/pkg/sr25519"
+	dotwallet "github.com/perun-network/perun-polkadot-backend/wallet/sr25519"
+	"perun.network/go-perun/channel"
+)
+
+type (
+	// testFunder funds every channel instantly.
+	testFunder struct{}
+
+	// testAdjudicator accepts all transactions and emits no events.
+	testAdjudicator struct{}
+
+	testSubscription struct{ closed chan struct{} }
+
+	testPeer struct {
+		wallet *dotwallet.Wallet
+		acc    *dotwallet.Account
+		host   string
+	}
+)
+
+func (testFunder) Fund(context.Context, channel.FundingReq) error { return nil }
+
+func (testAdjudicator) Register(context.Context, channel.AdjudicatorReq, []channel.SignedState) error {
+	return nil
+}
+
+func (testAdjudicator) Withdraw(context.Context, channel.AdjudicatorReq, channel.StateMap) error {
+	return nil
+}
+
+func (testAdjudicator) Progress(context.Context, channel.ProgressReq) error { return nil }
+
+func (testAdjudicator) Subscribe(context.Context, channel.ID) (channel.AdjudicatorSubscription, error) {
+	return &testSubscription{closed: make(chan struct{})}, nil
+}
+
+func (s *testSubscription) Next() channel.AdjudicatorEvent {
+	<-s.closed
+	return nil
+}
+
+func (s *testSubscription) Err() error { return nil }
+
+func (s *testSubscription) Close() error {
+	select {
+	case <-s.closed:
+	default:
+		close(s.closed)
+	}
+	return nil
+}
+
+func newTestPeer(t *testing.T) testPeer {
+	t.Helper()
+	sk, err := sr25519.NewSKFromRng(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	wallet := dotwallet.NewWallet()
+	return testPeer{wallet, wallet.ImportSK(sk), l.Addr().String()}
+}
+
+// start starts a node of `p` without a chain.
+func (p testPeer) start(t *testing.T, dir Directory, persistence string) *Node {
+	t.Helper()
+	opts := Options{
+		Host:            p.host,
+		Directory:       dir,
+		DialTimeout:     5 * time.Second,
+		PersistencePath: persistence,
+	}.withDefaults()
+	n, err := newNode(opts, p.wallet, p.acc, &dotSetup{Funder: testFunder{}, Adjudicator: testAdjudicator{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestRestoredChannel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	alice, bob := newTestPeer(t), newTestPeer(t)
+	aliceDir, bobDir := NewMemDirectory(), NewMemDirectory()
+	aliceDir.Add("bob", bob.acc.Address(), bob.host)
+	bobDir.Add("alice", alice.acc.Address(), alice.host)
+	persistence := t.TempDir()
+
+	bobNode := bob.start(t, bobDir, "")
+	defer bobNode.Shutdown() // nolint:errcheck
+	received := make(chan Event, 1)
+	bobNode.Subscribe(func(e Event) {
+		switch e.Type {
+		case EventProposalReceived:
+			go bobNode.Accept(ctx, e.Proposal.ID) // nolint:errcheck
+		case EventPaymentReceived:
+			received <- e
+		}
+	})
+
+	aliceNode := alice.start(t, aliceDir, persistence)
+	opened, err := aliceNode.Open(ctx, "bob", big.NewInt(100), big.NewInt(100), OpenOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open, err := aliceNode.Shutdown(); err != nil || len(open) != 1 {
+		t.Fatalf("Shutdown: %d channels stay open, error %v", len(open), err)
+	}
+
+	aliceNode = alice.start(t, aliceDir, persistence)
+	defer aliceNode.Shutdown() // nolint:errcheck
+	chs := aliceNode.Channels()
+	if len(chs) != 1 || chs[0].ID != opened.ID || chs[0].Peer != "bob" {
+		t.Fatalf("Restored channels: %+v, want the channel with bob", chs)
+	}
+	if info, ok := aliceNode.Peer("bob"); !ok || info.Online {
+		t.Fatalf("Restored peer bob: %+v, %t, want offline peer", info, ok)
+	}
+
+	if _, err := aliceNode.Send(ctx, "bob", big.NewInt(10), "after restart"); err != nil {
+		t.Fatal("Sending over restored channel:", err)
+	}
+	select {
+	case e := <-received:
+		if e.Amount.Int64() != 10 || e.Memo != "after restart" || !e.PeerID.Equals(alice.acc.Address()) {
+			t.Errorf("Bob received %+v", e)
+		}
+	case <-ctx.Done():
+		t.Fatal("Bob did not receive the payment")
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrShuttingDown is returned by the operations that are started after
+// Shutdown.
+var ErrShuttingDown = errors.New("Node is shutting down")
+
+// beginOp registers an operation that changes a channel. It fails once the
+// shutdown started. Every successful call must be followed by endOp.
+func (n *Node) beginOp() error {
+	n.opMtx.Lock()
+	defer n.opMtx.Unlock()
+	if n.closing {
+		return ErrShuttingDown
+	}
+	n.ops.Add(1)
+	return nil
+}
+
+func (n *Node) endOp() {
+	n.ops.Done()
+}
+
+// Shutdown waits for the in-flight operations up to the HandleTimeout and
+// stops the node. Open channels are closed if CloseOnShutdown is set and
+// otherwise stay open. The channels that stay open are returned. Shutdown is
+// idempotent.
+func (n *Node) Shutdown() ([]ChannelInfo, error) {
+	n.shutdownOnce.Do(func() { n.stillOpen, n.shutdownErr = n.shutdown() })
+	return n.stillOpen, n.shutdownErr
+}
+
+func (n *Node) shutdown() ([]ChannelInfo, error) {
+	n.opMtx.Lock()
+	n.closing = true
+	n.opMtx.Unlock()
+	n.waitForOps(n.opts.HandleTimeout)
+
+	if n.opts.CloseOnShutdown {
+		n.closeAll()
+	}
+	open := n.Channels()
+
+	// Stops the pings.
+	n.cancel()
+	// Stops the watchers.
+	err := errors.WithMessage(n.client.Close(), "closing client")
+	if n.persister != nil {
+		if perr := n.persister.Close(); perr != nil && err == nil {
+			err = errors.WithMessage(perr, "closing persistence")
+		}
+	}
+	if berr := n.bus.Close(); berr != nil && err == nil {
+		err = errors.WithMessage(berr, "closing bus")
+	}
+	return open, err
+}
+
+// waitForOps waits until all in-flight operations finished or the timeout
+// passed.
+func (n *Node) waitForOps(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		n.ops.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		n.log.Warn("Timed out waiting for in-flight operations")
+		n.emit(Event{Type: EventError, Err: errors.New("timed out waiting for in-flight operations")})
+	}
+}
+
+// closeAll cooperatively closes all open channels.
+func (n *Node) closeAll() {
+	for _, info := range n.Channels() {
+		p, ch, unlock, err := n.lockChannel(info.Peer)
+		if err != nil {
+			continue // Closed meanwhile.
+		}
+		if _, err := n.closeChannel(context.Background(), p, ch); err != nil {
+			err = errors.WithMessagef(err, "closing channel with %s", info.Peer)
+			n.log.Warn(err)
+			n.emit(Event{Type: EventError, Peer: info.Peer, PeerID: info.PeerID, Channel: info.ID, Err: err})
+		}
+		unlock()
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 - See NOTICE file for copyright holders.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package node
+
+import (
+	"sync"
+	"time"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+)
+
+type (
+	// signTimer records the time spent signing states per channel.
+	signTimer struct {
+		mtx   sync.Mutex
+		times map[channel.ID]time.Duration
+	}
+
+	// timedWallet wraps a wallet to time the signatures of its accounts.
+	timedWallet struct {
+		wallet.Wallet
+		timer *signTimer
+	}
+
+	timedAccount struct {
+		wallet.Account
+		timer *signTimer
+	}
+)
+
+func newSignTimer() *signTimer {
+	return &signTimer{times: make(map[channel.ID]time.Duration)}
+}
+
+func (t *signTimer) add(id channel.ID, d time.Duration) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.times[id] += d
+}
+
+// take returns and resets the time spent signing states of channel `id`.
+func (t *signTimer) take(id channel.ID) time.Duration {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	d := t.times[id]
+	delete(t.times, id)
+	return d
+}
+
+func (w *timedWallet) Unlock(addr wallet.Address) (wallet.Account, error) {
+	acc, err := w.Wallet.Unlock(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &timedAccount{Account: acc, timer: w.timer}, nil
+}
+
+// SignData signs `data` and records the time. The Polkadot backend signs SCALE
+// encoded states, which begin with the channel ID.
+func (a *timedAccount) SignData(data []byte) ([]byte, error) {
+	start := time.Now()
+	sig, err := a.Account.SignData(data)
+	var id channel.ID
+	if len(data) >= len(id) {
+		copy(id[:], data)
+		a.timer.add(id, time.Since(start))
+	}
+	return sig, err
+}